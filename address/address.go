@@ -22,6 +22,14 @@ func BytesToAddress(b []byte) Address {
 	return a
 }
 
+// PublicKeyToAddress returns the address of the account controlled by the public key,
+// it is the last 20 bytes of the keccak256 hash of the serialized key.
+func PublicKeyToAddress(pub []byte) Address {
+	sha := sha3.NewKeccak256()
+	sha.Write(pub)
+	return BytesToAddress(sha.Sum(nil))
+}
+
 // BigToAddress returns Address with byte values of b.
 // If b is larger than len(h), b will be cropped from the left.
 func BigToAddress(b *big.Int) Address { return BytesToAddress(b.Bytes()) }
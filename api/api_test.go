@@ -1,13 +1,19 @@
 package api
 
 import (
+	"bytes"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/golang/protobuf/proto"
 	"github.com/spacemeshos/go-spacemesh/address"
 	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/mempool"
 	"github.com/spacemeshos/go-spacemesh/mesh"
 	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/spacemeshos/go-spacemesh/state"
 	"github.com/stretchr/testify/require"
 	"io/ioutil"
 	"math/big"
@@ -24,6 +30,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // Better a small code duplication than a small dependency
@@ -31,6 +39,11 @@ import (
 type NodeAPIMock struct {
 	balances map[address.Address]*big.Int
 	nonces   map[address.Address]uint64
+	receipts map[common.Hash]*state.Receipt
+	proofs   map[address.Address]*state.AccountProof
+	layers   map[state.LayerID]*state.StateDB
+	pool     []*mempool.Tx
+	evidence []*mesh.Evidence
 }
 
 type NetworkMock struct {
@@ -50,6 +63,9 @@ func NewNodeAPIMock() NodeAPIMock {
 	return NodeAPIMock{
 		balances: make(map[address.Address]*big.Int),
 		nonces:   make(map[address.Address]uint64),
+		receipts: make(map[common.Hash]*state.Receipt),
+		proofs:   make(map[address.Address]*state.AccountProof),
+		layers:   make(map[state.LayerID]*state.StateDB),
 	}
 }
 
@@ -66,6 +82,51 @@ func (n NodeAPIMock) Exist(address address.Address) bool {
 	return ok
 }
 
+func (n NodeAPIMock) GetTransactionReceipt(hash common.Hash) (*state.Receipt, error) {
+	r, ok := n.receipts[hash]
+	if !ok {
+		return nil, errors.New("no receipt")
+	}
+	return r, nil
+}
+
+func (n NodeAPIMock) GetAccountProof(layer state.LayerID, address address.Address) (*state.AccountProof, error) {
+	p, ok := n.proofs[address]
+	if !ok || p.Layer != layer {
+		return nil, errors.New("layer was not applied")
+	}
+	return p, nil
+}
+
+func (n NodeAPIMock) StateAt(layer state.LayerID) (*state.StateDB, error) {
+	st, ok := n.layers[layer]
+	if !ok {
+		return nil, errors.New("layer was not applied")
+	}
+	return st, nil
+}
+
+func (n NodeAPIMock) Transactions() []*mempool.Tx {
+	return n.pool
+}
+
+func (n NodeAPIMock) Evidence() ([]*mesh.Evidence, error) {
+	return n.evidence, nil
+}
+
+type MiningMock struct {
+	mining   bool
+	coinbase address.Address
+	produced map[mesh.LayerID]int
+}
+
+func (m *MiningMock) StartMining()                         { m.mining = true }
+func (m *MiningMock) StopMining()                          { m.mining = false }
+func (m *MiningMock) MiningEligible() bool                 { return m.mining }
+func (m *MiningMock) SetCoinbase(addr address.Address)     { m.coinbase = addr }
+func (m *MiningMock) Coinbase() address.Address            { return m.coinbase }
+func (m *MiningMock) ProducedBlocks() map[mesh.LayerID]int { return m.produced }
+
 func TestServersConfig(t *testing.T) {
 
 	port1, err := node.GetUnboundedPort()
@@ -76,7 +137,7 @@ func TestServersConfig(t *testing.T) {
 	config.ConfigValues.GrpcServerPort = port2
 	ap := NodeAPIMock{}
 	net := NetworkMock{}
-	grpcService := NewGrpcService(&net, ap)
+	grpcService := NewGrpcService(&net, ap, ap, ap, ap, ap, &MiningMock{}, ap)
 	jsonService := NewJSONHTTPServer()
 
 	assert.Equal(t, grpcService.Port, uint(config.ConfigValues.GrpcServerPort), "Expected same port")
@@ -96,7 +157,7 @@ func TestGrpcApi(t *testing.T) {
 	ap := NodeAPIMock{}
 	net := NetworkMock{}
 
-	grpcService := NewGrpcService(&net, ap)
+	grpcService := NewGrpcService(&net, ap, ap, ap, ap, ap, &MiningMock{}, ap)
 	grpcStatus := make(chan bool, 2)
 
 	// start a server
@@ -137,7 +198,7 @@ func TestJsonApi(t *testing.T) {
 	config.ConfigValues.GrpcServerPort = port2
 	ap := NodeAPIMock{}
 	net := NetworkMock{}
-	grpcService := NewGrpcService(&net, ap)
+	grpcService := NewGrpcService(&net, ap, ap, ap, ap, ap, &MiningMock{}, ap)
 	jsonService := NewJSONHTTPServer()
 
 	jsonStatus := make(chan bool, 2)
@@ -211,7 +272,7 @@ func TestJsonWalletApi(t *testing.T) {
 	net := NetworkMock{broadcasted: []byte{0x00}}
 	ap.nonces[addr] = 10
 	ap.balances[addr] = big.NewInt(100)
-	grpcService := NewGrpcService(&net, ap)
+	grpcService := NewGrpcService(&net, ap, ap, ap, ap, ap, &MiningMock{}, ap)
 	jsonService := NewJSONHTTPServer()
 
 	jsonStatus := make(chan bool, 2)
@@ -279,7 +340,38 @@ func TestJsonWalletApi(t *testing.T) {
 	value = resp.Header.Get("Content-Type")
 	assert.Equal(t, value, contentType)
 
-	txParams := pb.SignedTransaction{SrcAddress: "01020304", DstAddress: "01", Amount: "10", Nonce: "12"}
+	priv, pub, err := crypto.GenerateKeyPair()
+	assert.NoError(t, err)
+	tx := mesh.NewSerializableTransaction(12, address.HexToAddress("01"), big.NewInt(10), big.NewInt(10), 10)
+	assert.NoError(t, tx.Sign(priv))
+	src := address.PublicKeyToAddress(pub.Bytes())
+
+	//the transaction is signed by another account
+	txParams := pb.SignedTransaction{SrcAddress: "01020304", DstAddress: "01", Amount: "10", Nonce: "12", Price: "10", GasLimit: "10", Signature: hex.EncodeToString(tx.Signature)}
+	payload, err = m.MarshalToString(&txParams)
+	url = fmt.Sprintf("http://127.0.0.1:%d/v1/submittransaction", config.ConfigValues.JSONServerPort)
+	resp, err = http.Post(url, contentType, strings.NewReader(payload))
+	assert.NoError(t, err, "failed to http post to api endpoint")
+	resp.Body.Close()
+	assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []byte{0x00}, net.broadcasted, "the transaction should not be broadcast")
+
+	//the price and gas limit are part of the signed body
+	for _, params := range []pb.SignedTransaction{
+		{SrcAddress: src.Hex(), DstAddress: "01", Amount: "10", Nonce: "12", Price: "11", GasLimit: "10", Signature: hex.EncodeToString(tx.Signature)},
+		{SrcAddress: src.Hex(), DstAddress: "01", Amount: "10", Nonce: "12", Price: "10", GasLimit: "9", Signature: hex.EncodeToString(tx.Signature)},
+		{SrcAddress: src.Hex(), DstAddress: "01", Amount: "10", Nonce: "12", Price: "ten", GasLimit: "10", Signature: hex.EncodeToString(tx.Signature)},
+	} {
+		payload, err = m.MarshalToString(&params)
+		assert.NoError(t, err)
+		resp, err = http.Post(url, contentType, strings.NewReader(payload))
+		assert.NoError(t, err, "failed to http post to api endpoint")
+		resp.Body.Close()
+		assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, []byte{0x00}, net.broadcasted, "the transaction should not be broadcast")
+	}
+
+	txParams = pb.SignedTransaction{SrcAddress: src.Hex(), DstAddress: "01", Amount: "10", Nonce: "12", Price: "10", GasLimit: "10", Signature: hex.EncodeToString(tx.Signature)}
 	payload, err = m.MarshalToString(&txParams)
 	url = fmt.Sprintf("http://127.0.0.1:%d/v1/submittransaction", config.ConfigValues.JSONServerPort)
 	resp, err = http.Post(url, contentType, strings.NewReader(payload)) //todo: we currently accept all kinds of payloads
@@ -296,26 +388,15 @@ func TestJsonWalletApi(t *testing.T) {
 	err = jsonpb.UnmarshalString(string(buf), &msg)
 	assert.NoError(t, err)
 
-	gotV, wantV = msg.Value, "ok"
-	assert.Equal(t, wantV, gotV)
+	st, err := mesh.SerializableTransaction2StateTransaction(tx)
+	assert.NoError(t, err)
+	gotV, wantV = msg.Value, st.Hash().Hex()
+	assert.Equal(t, wantV, gotV, "the hash of the transaction should be returned to query its receipt with")
 
 	_, err = proto.Marshal(&txParams)
 	assert.NoError(t, err)
 
-	tx := mesh.SerializableTransaction{}
-	rec := address.HexToAddress(txParams.DstAddress)
-	tx.Recipient = &rec
-	tx.Origin = address.HexToAddress(txParams.SrcAddress)
-
-	num, _ := strconv.ParseInt(txParams.Nonce, 10, 64)
-	tx.AccountNonce = uint64(num)
-	amount := big.Int{}
-	amount.SetString(txParams.Amount, 10)
-	tx.Amount = amount.Bytes()
-	tx.GasLimit = 10
-	tx.Price = big.NewInt(10).Bytes()
-
-	val, err := mesh.TransactionAsBytes(&tx)
+	val, err := mesh.TransactionAsBytes(tx)
 
 	assert.Equal(t, val, net.broadcasted)
 
@@ -340,7 +421,7 @@ func TestJsonWalletApi_Errors(t *testing.T) {
 	ap := NewNodeAPIMock()
 	net := NetworkMock{}
 
-	grpcService := NewGrpcService(&net, ap)
+	grpcService := NewGrpcService(&net, ap, ap, ap, ap, ap, &MiningMock{}, ap)
 	jsonService := NewJSONHTTPServer()
 
 	jsonStatus := make(chan bool, 2)
@@ -399,6 +480,439 @@ func TestJsonWalletApi_Errors(t *testing.T) {
 	<-grpcStatus
 }
 
+func TestGrpcApi_AccountProof(t *testing.T) {
+
+	port1, err := node.GetUnboundedPort()
+	port2, err := node.GetUnboundedPort()
+	assert.NoError(t, err, "Should be able to establish a connection on a port")
+
+	config.ConfigValues.JSONServerPort = port1
+	config.ConfigValues.GrpcServerPort = port2
+
+	ap := NewNodeAPIMock()
+	addr := address.HexToAddress("0102")
+	ap.proofs[addr] = &state.AccountProof{Address: addr, Layer: 3, Root: common.HexToHash("0a0b"), Balance: big.NewInt(100), Nonce: 5, Nodes: [][]byte{{0x01, 0x02}, {0x03}}}
+	net := NetworkMock{}
+
+	grpcService := NewGrpcService(&net, ap, ap, ap, ap, ap, &MiningMock{}, ap)
+	grpcStatus := make(chan bool, 2)
+
+	// start a server
+	grpcService.StartService(grpcStatus)
+	<-grpcStatus
+
+	// start a client
+	conn, err := grpc.Dial("localhost:"+strconv.Itoa(int(config.ConfigValues.GrpcServerPort)), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	c := pb.NewSpacemeshServiceClient(conn)
+
+	r, err := c.GetAccountProof(context.Background(), &pb.AccountProofRequest{Address: addr.Hex(), Layer: 3})
+	require.NoError(t, err)
+	assert.Equal(t, addr.Hex(), r.Address)
+	assert.Equal(t, uint64(3), r.Layer)
+	assert.Equal(t, common.HexToHash("0a0b").Hex(), r.Root)
+	assert.Equal(t, "100", r.Balance)
+	assert.Equal(t, uint64(5), r.Nonce)
+	assert.Equal(t, []string{"0102", "03"}, r.Proof)
+
+	_, err = c.GetAccountProof(context.Background(), &pb.AccountProofRequest{Address: addr.Hex(), Layer: 4})
+	assert.Error(t, err)
+
+	// stop the server
+	grpcService.StopService()
+	<-grpcStatus
+}
+
+func TestJsonApi_BalanceAtLayer(t *testing.T) {
+
+	port1, err := node.GetUnboundedPort()
+	port2, err := node.GetUnboundedPort()
+	assert.NoError(t, err, "Should be able to establish a connection on a port")
+
+	config.ConfigValues.JSONServerPort = port1
+	config.ConfigValues.GrpcServerPort = port2
+
+	ap := NewNodeAPIMock()
+	addr := address.HexToAddress("0102")
+	ap.nonces[addr] = 7
+	ap.balances[addr] = big.NewInt(300)
+	st, err := state.New(common.Hash{}, state.NewDatabase(database.NewMemDatabase()))
+	require.NoError(t, err)
+	st.SetBalance(addr, big.NewInt(120))
+	st.SetNonce(addr, 2)
+	ap.layers[5] = st
+	net := NetworkMock{}
+
+	grpcService := NewGrpcService(&net, ap, ap, ap, ap, ap, &MiningMock{}, ap)
+	jsonService := NewJSONHTTPServer()
+	jsonStatus := make(chan bool, 2)
+	grpcStatus := make(chan bool, 2)
+	grpcService.StartService(grpcStatus)
+	<-grpcStatus
+	jsonService.StartService(jsonStatus)
+	<-jsonStatus
+	time.Sleep(3 * time.Second)
+
+	query := func(endpoint, layer string) (int, string) {
+		var m jsonpb.Marshaler
+		payload, err := m.MarshalToString(&pb.AccountId{Address: addr.Hex(), Layer: layer})
+		require.NoError(t, err)
+		url := fmt.Sprintf("http://127.0.0.1:%d/v1/%v", config.ConfigValues.JSONServerPort, endpoint)
+		resp, err := http.Post(url, "application/json", strings.NewReader(payload))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		var msg pb.SimpleMessage
+		if resp.StatusCode == http.StatusOK {
+			assert.NoError(t, jsonpb.UnmarshalString(string(buf), &msg))
+		}
+		return resp.StatusCode, msg.Value
+	}
+
+	for _, tc := range []struct {
+		endpoint, layer string
+		status          int
+		value           string
+	}{
+		{"balance", "", http.StatusOK, "300"},
+		{"nonce", "", http.StatusOK, "7"},
+		{"balance", "5", http.StatusOK, "120"},
+		{"nonce", "5", http.StatusOK, "2"},
+		{"balance", "6", http.StatusInternalServerError, ""},
+		{"nonce", "five", http.StatusInternalServerError, ""},
+	} {
+		status, value := query(tc.endpoint, tc.layer)
+		assert.Equal(t, tc.status, status, "%v at layer %q", tc.endpoint, tc.layer)
+		assert.Equal(t, tc.value, value, "%v at layer %q", tc.endpoint, tc.layer)
+	}
+
+	//the grpc endpoint answers from the same state
+	conn, err := grpc.Dial("localhost:"+strconv.Itoa(int(config.ConfigValues.GrpcServerPort)), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	r, err := pb.NewSpacemeshServiceClient(conn).GetBalance(context.Background(), &pb.AccountId{Address: addr.Hex(), Layer: "5"})
+	require.NoError(t, err)
+	assert.Equal(t, "120", r.Value)
+
+	jsonService.StopService()
+	<-jsonStatus
+	grpcService.StopService()
+	<-grpcStatus
+}
+
+func TestJsonApi_TransactionReceipt(t *testing.T) {
+
+	port1, err := node.GetUnboundedPort()
+	port2, err := node.GetUnboundedPort()
+	assert.NoError(t, err, "Should be able to establish a connection on a port")
+	config.ConfigValues.JSONServerPort = port1
+	config.ConfigValues.GrpcServerPort = port2
+	ap := NewNodeAPIMock()
+	hash := common.HexToHash("0102")
+	ap.receipts[hash] = &state.Receipt{Layer: 7, Status: state.ReceiptFailed, Reason: state.ErrNonce, Fee: big.NewInt(0)}
+	net := NetworkMock{}
+
+	grpcService := NewGrpcService(&net, ap, ap, ap, ap, ap, &MiningMock{}, ap)
+	jsonService := NewJSONHTTPServer()
+
+	jsonStatus := make(chan bool, 2)
+	grpcStatus := make(chan bool, 2)
+
+	// start grp and json server
+	grpcService.StartService(grpcStatus)
+	<-grpcStatus
+
+	jsonService.StartService(jsonStatus)
+	<-jsonStatus
+
+	const contentType = "application/json"
+	var m jsonpb.Marshaler
+
+	// Without this running this on Travis CI might generate a connection refused error
+	// because the server may not be ready to accept connections just yet.
+	time.Sleep(3 * time.Second)
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/v1/txreceipt", config.ConfigValues.JSONServerPort)
+	for _, id := range []string{hash.Hex(), "0x0103", "not hex"} {
+		payload, err := m.MarshalToString(&pb.TransactionId{Id: id})
+		assert.NoError(t, err, "failed to marshal to string")
+		resp, err := http.Post(url, contentType, strings.NewReader(payload))
+		assert.NoError(t, err, "failed to http post to api endpoint")
+		buf, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err, "failed to read response body")
+		resp.Body.Close()
+
+		if id != hash.Hex() {
+			assert.Equal(t, http.StatusInternalServerError, resp.StatusCode, "there is no receipt for %v", id)
+			continue
+		}
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		var r pb.TransactionReceipt
+		assert.NoError(t, jsonpb.UnmarshalString(string(buf), &r))
+		assert.Equal(t, pb.TransactionReceipt{Id: hash.Hex(), Layer: 7, Status: "failed", Reason: state.ErrNonce, Fee: "0"}, r)
+	}
+
+	// stop the services
+	jsonService.StopService()
+	<-jsonStatus
+	grpcService.StopService()
+	<-grpcStatus
+}
+
+func TestJsonApi_Mempool(t *testing.T) {
+
+	port1, err := node.GetUnboundedPort()
+	port2, err := node.GetUnboundedPort()
+	assert.NoError(t, err, "Should be able to establish a connection on a port")
+	config.ConfigValues.JSONServerPort = port1
+	config.ConfigValues.GrpcServerPort = port2
+	ap := NewNodeAPIMock()
+	senders := make([]address.Address, 0, 2)
+	for i := 0; i < 2; i++ {
+		priv, pub, err := crypto.GenerateKeyPair()
+		require.NoError(t, err)
+		tx := state.NewTransaction(uint64(i), address.HexToAddress("0a"), big.NewInt(100), 10, big.NewInt(2))
+		require.NoError(t, tx.Sign(priv))
+		ap.pool = append(ap.pool, &mempool.Tx{Transaction: tx})
+		senders = append(senders, address.PublicKeyToAddress(pub.Bytes()))
+	}
+	net := NetworkMock{}
+
+	grpcService := NewGrpcService(&net, ap, ap, ap, ap, ap, &MiningMock{}, ap)
+	jsonService := NewJSONHTTPServer()
+	jsonStatus := make(chan bool, 2)
+	grpcStatus := make(chan bool, 2)
+	grpcService.StartService(grpcStatus)
+	<-grpcStatus
+	jsonService.StartService(jsonStatus)
+	<-jsonStatus
+	time.Sleep(3 * time.Second)
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/v1/mempool", config.ConfigValues.JSONServerPort)
+	for _, tc := range []struct {
+		sender string
+		want   []int
+	}{
+		{"", []int{0, 1}},
+		{senders[1].Hex(), []int{1}},
+		{"0b", []int{}},
+	} {
+		var m jsonpb.Marshaler
+		payload, err := m.MarshalToString(&pb.MempoolRequest{Address: tc.sender})
+		require.NoError(t, err)
+		resp, err := http.Post(url, "application/json", strings.NewReader(payload))
+		require.NoError(t, err)
+		buf, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var res pb.Mempool
+		assert.NoError(t, jsonpb.UnmarshalString(string(buf), &res))
+		require.Len(t, res.Transactions, len(tc.want), "transactions of %q", tc.sender)
+		for i, idx := range tc.want {
+			tx := ap.pool[idx]
+			assert.Equal(t, pb.PendingTransaction{
+				Id:        tx.Hash().Hex(),
+				Origin:    senders[idx].Hex(),
+				Recipient: address.HexToAddress("0a").Hex(),
+				Nonce:     uint64(idx),
+				Amount:    "100",
+				Price:     "2",
+				GasLimit:  10,
+			}, *res.Transactions[i])
+		}
+	}
+
+	jsonService.StopService()
+	<-jsonStatus
+	grpcService.StopService()
+	<-grpcStatus
+}
+
+func TestJsonApi_Mining(t *testing.T) {
+
+	port1, err := node.GetUnboundedPort()
+	port2, err := node.GetUnboundedPort()
+	assert.NoError(t, err, "Should be able to establish a connection on a port")
+	config.ConfigValues.JSONServerPort = port1
+	config.ConfigValues.GrpcServerPort = port2
+	ap := NewNodeAPIMock()
+	net := NetworkMock{}
+	mining := &MiningMock{mining: true, produced: map[mesh.LayerID]int{7: 1, 3: 2}}
+
+	grpcService := NewGrpcService(&net, ap, ap, ap, ap, ap, mining, ap)
+	jsonService := NewJSONHTTPServer()
+	jsonStatus := make(chan bool, 2)
+	grpcStatus := make(chan bool, 2)
+	grpcService.StartService(grpcStatus)
+	<-grpcStatus
+	jsonService.StartService(jsonStatus)
+	<-jsonStatus
+	time.Sleep(3 * time.Second)
+
+	post := func(path string, req proto.Message) (int, string) {
+		var m jsonpb.Marshaler
+		payload, err := m.MarshalToString(req)
+		require.NoError(t, err)
+		url := fmt.Sprintf("http://127.0.0.1:%d/v1/%s", config.ConfigValues.JSONServerPort, path)
+		resp, err := http.Post(url, "application/json", strings.NewReader(payload))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		buf, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(buf)
+	}
+
+	status, _ := post("stopmining", &pb.SimpleMessage{})
+	assert.Equal(t, http.StatusOK, status)
+	assert.False(t, mining.mining)
+
+	coinbase := address.HexToAddress("0a")
+	status, _ = post("setcoinbase", &pb.SetCoinbaseRequest{Address: coinbase.Hex()})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, coinbase, mining.coinbase)
+	for _, addr := range []string{"", "0xzz", "0x" + strings.Repeat("0a", 21), address.Address{}.Hex()} {
+		status, _ = post("setcoinbase", &pb.SetCoinbaseRequest{Address: addr})
+		assert.Equal(t, http.StatusBadRequest, status, "coinbase %q should be rejected", addr)
+	}
+	assert.Equal(t, coinbase, mining.coinbase)
+
+	status, _ = post("startmining", &pb.SimpleMessage{})
+	assert.Equal(t, http.StatusOK, status)
+	assert.True(t, mining.mining)
+
+	status, body := post("miningstatus", &pb.SimpleMessage{})
+	require.Equal(t, http.StatusOK, status)
+	var res pb.MiningStatus
+	require.NoError(t, jsonpb.UnmarshalString(body, &res))
+	assert.True(t, res.Mining)
+	assert.Equal(t, coinbase.Hex(), res.Coinbase)
+	require.Len(t, res.Layers, 2)
+	assert.Equal(t, pb.LayerBlocks{Layer: 3, Blocks: 2}, *res.Layers[0])
+	assert.Equal(t, pb.LayerBlocks{Layer: 7, Blocks: 1}, *res.Layers[1])
+
+	jsonService.StopService()
+	<-jsonStatus
+	grpcService.StopService()
+	<-grpcStatus
+}
+
+func TestGrpcApi_SetCoinbase(t *testing.T) {
+
+	port1, err := node.GetUnboundedPort()
+	port2, err := node.GetUnboundedPort()
+	assert.NoError(t, err, "Should be able to establish a connection on a port")
+
+	config.ConfigValues.JSONServerPort = port1
+	config.ConfigValues.GrpcServerPort = port2
+
+	ap := NewNodeAPIMock()
+	net := NetworkMock{}
+	mining := &MiningMock{}
+	grpcService := NewGrpcService(&net, ap, ap, ap, ap, ap, mining, ap)
+	grpcStatus := make(chan bool, 2)
+	grpcService.StartService(grpcStatus)
+	<-grpcStatus
+
+	conn, err := grpc.Dial("localhost:"+strconv.Itoa(int(config.ConfigValues.GrpcServerPort)), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	c := pb.NewSpacemeshServiceClient(conn)
+
+	coinbase := address.HexToAddress("0a0b")
+	_, err = c.SetCoinbase(context.Background(), &pb.SetCoinbaseRequest{Address: coinbase.Hex()})
+	require.NoError(t, err)
+	assert.Equal(t, coinbase, mining.coinbase)
+
+	for _, addr := range []string{"", "not hex", "0x" + strings.Repeat("0a", 21), "0x00"} {
+		_, err = c.SetCoinbase(context.Background(), &pb.SetCoinbaseRequest{Address: addr})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "coinbase %q should be rejected", addr)
+	}
+	assert.Equal(t, coinbase, mining.coinbase)
+
+	grpcService.StopService()
+	<-grpcStatus
+}
+
+func TestJsonApi_Evidence(t *testing.T) {
+
+	port1, err := node.GetUnboundedPort()
+	port2, err := node.GetUnboundedPort()
+	assert.NoError(t, err, "Should be able to establish a connection on a port")
+	config.ConfigValues.JSONServerPort = port1
+	config.ConfigValues.GrpcServerPort = port2
+	ap := NewNodeAPIMock()
+	for i := 0; i < 2; i++ {
+		priv, pub, err := crypto.GenerateKeyPair()
+		require.NoError(t, err)
+		e := &mesh.Evidence{Layer: mesh.LayerID(i + 1), MinerID: pub.String()}
+		for j, b := range []*mesh.Block{&e.First, &e.Second} {
+			*b = *mesh.NewBlock(true, []byte{byte(j)}, time.Now(), e.Layer)
+			b.MinerID = e.MinerID
+			b.Id = b.CalcId()
+			b.Sig, err = priv.Sign(b.ContentHash())
+			require.NoError(t, err)
+		}
+		ap.evidence = append(ap.evidence, e)
+	}
+	net := NetworkMock{}
+
+	grpcService := NewGrpcService(&net, ap, ap, ap, ap, ap, &MiningMock{}, ap)
+	jsonService := NewJSONHTTPServer()
+	jsonStatus := make(chan bool, 2)
+	grpcStatus := make(chan bool, 2)
+	grpcService.StartService(grpcStatus)
+	<-grpcStatus
+	jsonService.StartService(jsonStatus)
+	<-jsonStatus
+	time.Sleep(3 * time.Second)
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/v1/evidence", config.ConfigValues.JSONServerPort)
+	for _, tc := range []struct {
+		miner string
+		want  []int
+	}{
+		{"", []int{0, 1}},
+		{ap.evidence[1].MinerID, []int{1}},
+		{"unknown", []int{}},
+	} {
+		var m jsonpb.Marshaler
+		payload, err := m.MarshalToString(&pb.EvidenceRequest{MinerId: tc.miner})
+		require.NoError(t, err)
+		resp, err := http.Post(url, "application/json", strings.NewReader(payload))
+		require.NoError(t, err)
+		buf, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var res pb.EvidenceList
+		assert.NoError(t, jsonpb.UnmarshalString(string(buf), &res))
+		require.Len(t, res.Evidence, len(tc.want), "evidence against %q", tc.miner)
+		for i, idx := range tc.want {
+			e := ap.evidence[idx]
+			got := res.Evidence[i]
+			assert.Equal(t, uint64(e.Layer), got.Layer)
+			assert.Equal(t, e.MinerID, got.MinerId)
+			assert.Equal(t, []uint64{uint64(e.First.ID()), uint64(e.Second.ID())}, got.Blocks)
+
+			data, err := hex.DecodeString(got.Data)
+			require.NoError(t, err)
+			decoded, err := mesh.BytesAsEvidence(bytes.NewReader(data))
+			require.NoError(t, err)
+			assert.NoError(t, decoded.Validate(), "the evidence should be verifiable by the client")
+		}
+	}
+
+	jsonService.StopService()
+	<-jsonStatus
+	grpcService.StopService()
+	<-grpcStatus
+}
+
 func TestSpaceMeshGrpcService_Broadcast(t *testing.T) {
 
 	port1, err := node.GetUnboundedPort()
@@ -412,7 +926,7 @@ func TestSpaceMeshGrpcService_Broadcast(t *testing.T) {
 	ap := NewNodeAPIMock()
 	net := NetworkMock{broadcasted: []byte{0x00}}
 
-	grpcService := NewGrpcService(&net, ap)
+	grpcService := NewGrpcService(&net, ap, ap, ap, ap, ap, &MiningMock{}, ap)
 	jsonService := NewJSONHTTPServer()
 
 	jsonStatus := make(chan bool, 2)
@@ -481,7 +995,7 @@ func TestSpaceMeshGrpcService_BroadcastErrors(t *testing.T) {
 	net := NetworkMock{broadcasted: []byte{0x00}}
 	net.broadCastErr = true
 
-	grpcService := NewGrpcService(&net, ap)
+	grpcService := NewGrpcService(&net, ap, ap, ap, ap, ap, &MiningMock{}, ap)
 	jsonService := NewJSONHTTPServer()
 
 	jsonStatus := make(chan bool, 2)
@@ -12,6 +12,7 @@ type GenesisAccount struct {
 
 type GenesisConfig struct {
 	InitialAccounts map[address.Address]GenesisAccount
+	LayerReward     *big.Int // issued every layer, split between the miners of the contextually valid blocks
 }
 
 func DefaultGenesisConfig() *GenesisConfig {
@@ -19,6 +20,7 @@ func DefaultGenesisConfig() *GenesisConfig {
 	g.InitialAccounts = map[address.Address]GenesisAccount{
 		address.BytesToAddress([]byte{1}): {Balance: big.NewInt(10000), Nonce: 0},
 	}
+	g.LayerReward = big.NewInt(1000)
 
 	return &g
 	//todo: implement reading from file
@@ -2,28 +2,41 @@
 package api
 
 import (
+	"encoding/hex"
 	"fmt"
 	"github.com/spacemeshos/go-spacemesh/address"
 	"github.com/spacemeshos/go-spacemesh/api/config"
 	"github.com/spacemeshos/go-spacemesh/api/pb"
+	"github.com/spacemeshos/go-spacemesh/common"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/mesh"
 	"github.com/spacemeshos/go-spacemesh/miner"
+	"github.com/spacemeshos/go-spacemesh/state"
 	"math/big"
 	"net"
+	"sort"
 	"strconv"
+	"strings"
 
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
 // SpacemeshGrpcService is a grpc server providing the Spacemesh api
 type SpacemeshGrpcService struct {
-	Server   *grpc.Server
-	Port     uint
-	StateApi StateAPI
-	Network  NetworkAPI
+	Server      *grpc.Server
+	Port        uint
+	StateApi    StateAPI
+	TxApi       TxAPI
+	ProofApi    ProofAPI
+	HistoryApi  HistoryAPI
+	PoolApi     PoolAPI
+	MiningApi   MiningAPI
+	EvidenceApi EvidenceAPI
+	Network     NetworkAPI
 }
 
 // Echo returns the response for an echo api request
@@ -31,46 +44,92 @@ func (s SpacemeshGrpcService) Echo(ctx context.Context, in *pb.SimpleMessage) (*
 	return &pb.SimpleMessage{Value: in.Value}, nil
 }
 
-// Echo returns the response for an echo api request
+// stateOf returns the state the account query is answered from, the current state unless the query names a layer
+func (s SpacemeshGrpcService) stateOf(in *pb.AccountId) (StateAPI, error) {
+	if in.Layer == "" {
+		return s.StateApi, nil
+	}
+	layer, err := strconv.ParseUint(in.Layer, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid layer %v", in.Layer)
+	}
+	st, err := s.HistoryApi.StateAt(state.LayerID(layer))
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// GetBalance returns the balance of an account in the current state or in the state of the requested layer
 func (s SpacemeshGrpcService) GetBalance(ctx context.Context, in *pb.AccountId) (*pb.SimpleMessage, error) {
 	addr := address.HexToAddress(in.Address)
+	st, err := s.stateOf(in)
+	if err != nil {
+		return nil, err
+	}
 
-	if s.StateApi.Exist(addr) != true {
+	if st.Exist(addr) != true {
 		return nil, fmt.Errorf("account does not exist")
 	}
 
-	val := s.StateApi.GetBalance(addr)
+	val := st.GetBalance(addr)
 
 	return &pb.SimpleMessage{Value: val.String()}, nil
 }
 
-// Echo returns the response for an echo api request
+// GetNonce returns the nonce of an account in the current state or in the state of the requested layer
 func (s SpacemeshGrpcService) GetNonce(ctx context.Context, in *pb.AccountId) (*pb.SimpleMessage, error) {
 	addr := address.HexToAddress(in.Address)
+	st, err := s.stateOf(in)
+	if err != nil {
+		return nil, err
+	}
 
-	if s.StateApi.Exist(addr) != true {
+	if st.Exist(addr) != true {
 		return nil, fmt.Errorf("account does not exist")
 	}
 
-	val := s.StateApi.GetNonce(addr)
+	val := st.GetNonce(addr)
 	msg := pb.SimpleMessage{Value: strconv.FormatUint(val, 10)}
 	return &msg, nil
 }
 
+// SubmitTransaction broadcasts a signed transaction, the origin is recovered from the signature and has to match srcAddress.
+// it returns the hash of the transaction its receipt can be queried with
 func (s SpacemeshGrpcService) SubmitTransaction(ctx context.Context, in *pb.SignedTransaction) (*pb.SimpleMessage, error) {
 
 	tx := mesh.SerializableTransaction{}
 	addr := address.HexToAddress(in.DstAddress)
 	tx.Recipient = &addr
-	tx.Origin = address.HexToAddress(in.SrcAddress)
 
 	num, _ := strconv.ParseInt(in.Nonce, 10, 64)
 	tx.AccountNonce = uint64(num)
 	amount := big.Int{}
 	amount.SetString(in.Amount, 10)
 	tx.Amount = amount.Bytes()
-	tx.GasLimit = 10
-	tx.Price = big.NewInt(10).Bytes()
+	price, ok := new(big.Int).SetString(in.Price, 10)
+	if !ok || price.Sign() < 0 {
+		return nil, fmt.Errorf("invalid price %v", in.Price)
+	}
+	tx.Price = price.Bytes()
+	gasLimit, err := strconv.ParseUint(in.GasLimit, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gas limit %v", in.GasLimit)
+	}
+	tx.GasLimit = gasLimit
+
+	sig, err := hex.DecodeString(in.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("signature is not hex encoded %v", err)
+	}
+	tx.Signature = sig
+	st, err := mesh.SerializableTransaction2StateTransaction(&tx)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature %v", err)
+	}
+	if st.Origin != address.HexToAddress(in.SrcAddress) {
+		return nil, fmt.Errorf("transaction is signed by %v not by %v", st.Origin.Hex(), in.SrcAddress)
+	}
 
 	val, err := mesh.TransactionAsBytes(&tx)
 	if err != nil {
@@ -80,9 +139,137 @@ func (s SpacemeshGrpcService) SubmitTransaction(ctx context.Context, in *pb.Sign
 	//todo" should this be in a go routine?
 	s.Network.Broadcast(miner.IncomingTxProtocol, val)
 
+	return &pb.SimpleMessage{Value: st.Hash().Hex()}, nil
+}
+
+// GetTransactionReceipt returns the outcome of a transaction that was applied to the state
+func (s SpacemeshGrpcService) GetTransactionReceipt(ctx context.Context, in *pb.TransactionId) (*pb.TransactionReceipt, error) {
+	h, err := hex.DecodeString(strings.TrimPrefix(in.Id, "0x"))
+	if err != nil || len(h) != common.HashLength {
+		return nil, fmt.Errorf("invalid transaction id %v", in.Id)
+	}
+	r, err := s.TxApi.GetTransactionReceipt(common.BytesToHash(h))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.TransactionReceipt{
+		Id:     in.Id,
+		Layer:  uint64(r.Layer),
+		Status: r.Status.String(),
+		Reason: r.Reason,
+		Fee:    r.Fee.String(),
+	}, nil
+}
+
+// GetAccountProof returns the balance and nonce of an account in the state of a layer with a merkle proof
+// against the state root of the layer, it can be checked with state.VerifyAccountProof
+func (s SpacemeshGrpcService) GetAccountProof(ctx context.Context, in *pb.AccountProofRequest) (*pb.AccountProof, error) {
+	p, err := s.ProofApi.GetAccountProof(state.LayerID(in.Layer), address.HexToAddress(in.Address))
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]string, 0, len(p.Nodes))
+	for _, n := range p.Nodes {
+		nodes = append(nodes, hex.EncodeToString(n))
+	}
+	return &pb.AccountProof{
+		Address: p.Address.Hex(),
+		Layer:   uint64(p.Layer),
+		Root:    p.Root.Hex(),
+		Balance: p.Balance.String(),
+		Nonce:   p.Nonce,
+		Proof:   nodes,
+	}, nil
+}
+
+// GetMempool returns the transactions waiting in the pool of the node to be included in a block
+func (s SpacemeshGrpcService) GetMempool(ctx context.Context, in *pb.MempoolRequest) (*pb.Mempool, error) {
+	txs := s.PoolApi.Transactions()
+	res := &pb.Mempool{Transactions: make([]*pb.PendingTransaction, 0, len(txs))}
+	for _, tx := range txs {
+		if in.Address != "" && tx.Origin != address.HexToAddress(in.Address) {
+			continue
+		}
+		res.Transactions = append(res.Transactions, &pb.PendingTransaction{
+			Id:        tx.Hash().Hex(),
+			Origin:    tx.Origin.Hex(),
+			Recipient: tx.Recipient.Hex(),
+			Nonce:     tx.AccountNonce,
+			Amount:    tx.Amount.String(),
+			Price:     tx.Price.String(),
+			GasLimit:  tx.GasLimit,
+		})
+	}
+	return res, nil
+}
+
+// StartMining resumes building blocks in the layers the node is eligible for
+func (s SpacemeshGrpcService) StartMining(ctx context.Context, in *pb.SimpleMessage) (*pb.SimpleMessage, error) {
+	s.MiningApi.StartMining()
+	return &pb.SimpleMessage{Value: "ok"}, nil
+}
+
+// StopMining pauses building blocks, the node keeps syncing and receiving transactions
+func (s SpacemeshGrpcService) StopMining(ctx context.Context, in *pb.SimpleMessage) (*pb.SimpleMessage, error) {
+	s.MiningApi.StopMining()
 	return &pb.SimpleMessage{Value: "ok"}, nil
 }
 
+// SetCoinbase changes the address the rewards of the blocks built by the node are paid to
+func (s SpacemeshGrpcService) SetCoinbase(ctx context.Context, in *pb.SetCoinbaseRequest) (*pb.SimpleMessage, error) {
+	b := common.FromHex(in.Address)
+	if len(b) == 0 || len(b) > common.AddressLength {
+		return nil, status.Errorf(codes.InvalidArgument, "malformed coinbase address %q", in.Address)
+	}
+	coinbase := address.BytesToAddress(b)
+	if coinbase == (address.Address{}) {
+		return nil, status.Error(codes.InvalidArgument, "the coinbase can not be the zero address")
+	}
+	s.MiningApi.SetCoinbase(coinbase)
+	return &pb.SimpleMessage{Value: "ok"}, nil
+}
+
+// GetMiningStatus returns whether the node is mining, its coinbase and the number of blocks it built in recent layers
+func (s SpacemeshGrpcService) GetMiningStatus(ctx context.Context, in *pb.SimpleMessage) (*pb.MiningStatus, error) {
+	produced := s.MiningApi.ProducedBlocks()
+	res := &pb.MiningStatus{
+		Mining:   s.MiningApi.MiningEligible(),
+		Coinbase: s.MiningApi.Coinbase().Hex(),
+		Layers:   make([]*pb.LayerBlocks, 0, len(produced)),
+	}
+	for l, n := range produced {
+		res.Layers = append(res.Layers, &pb.LayerBlocks{Layer: uint64(l), Blocks: uint64(n)})
+	}
+	sort.Slice(res.Layers, func(i, j int) bool { return res.Layers[i].Layer < res.Layers[j].Layer })
+	return res, nil
+}
+
+// GetEvidence returns the evidence of miners that built more than one block in a layer,
+// the blocks of these miners in the layer are excluded from the mesh
+func (s SpacemeshGrpcService) GetEvidence(ctx context.Context, in *pb.EvidenceRequest) (*pb.EvidenceList, error) {
+	evidence, err := s.EvidenceApi.Evidence()
+	if err != nil {
+		return nil, err
+	}
+	res := &pb.EvidenceList{Evidence: make([]*pb.EquivocationEvidence, 0, len(evidence))}
+	for _, e := range evidence {
+		if in.MinerId != "" && e.MinerID != in.MinerId {
+			continue
+		}
+		data, err := mesh.EvidenceAsBytes(e)
+		if err != nil {
+			return nil, err
+		}
+		res.Evidence = append(res.Evidence, &pb.EquivocationEvidence{
+			Layer:   uint64(e.Layer),
+			MinerId: e.MinerID,
+			Blocks:  []uint64{uint64(e.First.ID()), uint64(e.Second.ID())},
+			Data:    hex.EncodeToString(data),
+		})
+	}
+	return res, nil
+}
+
 // P2P API
 
 func (s SpacemeshGrpcService) Broadcast(ctx context.Context, in *pb.BroadcastMessage) (*pb.SimpleMessage, error) {
@@ -103,10 +290,10 @@ func (s SpacemeshGrpcService) StopService() {
 }
 
 // NewGrpcService create a new grpc service using config data.
-func NewGrpcService(net NetworkAPI, state StateAPI) *SpacemeshGrpcService {
+func NewGrpcService(net NetworkAPI, state StateAPI, tx TxAPI, proofs ProofAPI, history HistoryAPI, pool PoolAPI, mining MiningAPI, evidence EvidenceAPI) *SpacemeshGrpcService {
 	port := config.ConfigValues.GrpcServerPort
 	server := grpc.NewServer()
-	return &SpacemeshGrpcService{Server: server, Port: uint(port), StateApi: state, Network: net}
+	return &SpacemeshGrpcService{Server: server, Port: uint(port), StateApi: state, TxApi: tx, ProofApi: proofs, HistoryApi: history, PoolApi: pool, MiningApi: mining, EvidenceApi: evidence, Network: net}
 }
 
 // StartService starts the grpc service.
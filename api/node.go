@@ -3,7 +3,11 @@ package api
 import (
 	"context"
 	"github.com/spacemeshos/go-spacemesh/address"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/mempool"
+	"github.com/spacemeshos/go-spacemesh/mesh"
 	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/spacemeshos/go-spacemesh/state"
 	"math/big"
 )
 
@@ -36,6 +40,35 @@ type StateAPI interface {
 	Exist(address address.Address) bool
 }
 
+type TxAPI interface {
+	GetTransactionReceipt(hash common.Hash) (*state.Receipt, error)
+}
+
+type ProofAPI interface {
+	GetAccountProof(layer state.LayerID, address address.Address) (*state.AccountProof, error)
+}
+
+type HistoryAPI interface {
+	StateAt(layer state.LayerID) (*state.StateDB, error)
+}
+
+type PoolAPI interface {
+	Transactions() []*mempool.Tx
+}
+
+type MiningAPI interface {
+	StartMining()
+	StopMining()
+	MiningEligible() bool
+	SetCoinbase(addr address.Address)
+	Coinbase() address.Address
+	ProducedBlocks() map[mesh.LayerID]int
+}
+
+type EvidenceAPI interface {
+	Evidence() ([]*mesh.Evidence, error)
+}
+
 type NetworkAPI interface {
 	Broadcast(channel string, data []byte) error
 }
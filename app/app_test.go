@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/spacemeshos/go-spacemesh/address"
 	"github.com/spacemeshos/go-spacemesh/api/config"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/hare"
 	"github.com/spacemeshos/go-spacemesh/mesh"
 	"github.com/spacemeshos/go-spacemesh/miner"
@@ -72,13 +74,15 @@ func TestApp(t *testing.T) {
 
 }
 
-func (app *AppTestSuite) initMultipleInstances(t *testing.T, numOfInstances int) {
+func (app *AppTestSuite) initMultipleInstances(t *testing.T, numOfInstances int, genesis *config.GenesisConfig) {
 	net := service.NewSimulator()
 	storeFormat := "../tmp/state_"
 	runningName := 'a'
 	bo := oracle.NewLocalOracle(numOfInstances)
 	for i := 0; i < numOfInstances; i++ {
 		app.apps = append(app.apps, newSpacemeshApp())
+		//every node builds a block in every layer
+		app.apps[i].Config.LayerAvgSize = uint32(numOfInstances)
 		store := storeFormat + string(runningName)
 		n := net.NewNode()
 
@@ -88,7 +92,7 @@ func (app *AppTestSuite) initMultipleInstances(t *testing.T, numOfInstances int)
 
 		err := app.apps[i].initServices(pub.String(), n, store, sgn, bo, bo)
 		assert.NoError(t, err)
-		app.apps[i].setupGenesis(config.DefaultGenesisConfig())
+		app.apps[i].setupGenesis(genesis)
 		app.dbs = append(app.dbs, store)
 		runningName++
 	}
@@ -97,25 +101,26 @@ func (app *AppTestSuite) initMultipleInstances(t *testing.T, numOfInstances int)
 func (app *AppTestSuite) TestMultipleNodes() {
 	//EntryPointCreated <- true
 
-	addr := address.BytesToAddress([]byte{0x01})
+	priv, pub, err := crypto.GenerateKeyPair()
+	assert.NoError(app.T(), err)
+	genesis := config.DefaultGenesisConfig()
+	genesis.InitialAccounts[address.PublicKeyToAddress(pub.Bytes())] = config.GenesisAccount{Balance: big.NewInt(10000), Nonce: 0}
+
 	dst := address.BytesToAddress([]byte{0x02})
-	tx := mesh.SerializableTransaction{}
-	tx.Amount = big.NewInt(10).Bytes()
-	tx.GasLimit = 1
-	tx.Origin = addr
-	tx.Recipient = &dst
-	tx.Price = big.NewInt(1).Bytes()
+	tx := mesh.NewSerializableTransaction(0, dst, big.NewInt(10), big.NewInt(1), 1)
+	assert.NoError(app.T(), tx.Sign(priv))
 
-	txbytes, _ := mesh.TransactionAsBytes(&tx)
+	txbytes, _ := mesh.TransactionAsBytes(tx)
 
-	app.initMultipleInstances(app.T(), 2)
+	app.initMultipleInstances(app.T(), 2, genesis)
 
 	for _, a := range app.apps {
 		a.startServices()
 	}
 
 	app.apps[0].P2P.Broadcast(miner.IncomingTxProtocol, txbytes)
-	timeout := time.After(25 * time.Second)
+	//the layer of the transaction is applied once the tortoise decided it, a couple of layers later
+	timeout := time.After(40 * time.Second)
 
 	for {
 		select {
@@ -143,6 +148,35 @@ func (app *AppTestSuite) TestMultipleNodes() {
 	}
 }
 
+func (app *AppTestSuite) TestSyncTrustedState() {
+	app.initMultipleInstances(app.T(), 2, config.DefaultGenesisConfig())
+	//the simulator announces a node to the nodes created before it
+	synced, trusted := app.apps[0], app.apps[1]
+
+	miner := address.BytesToAddress([]byte{0x03})
+	_, err := trusted.txProcessor.ApplyTransactions(1, nil, []address.Address{miner}, nil)
+	assert.NoError(app.T(), err)
+	root := trusted.state.IntermediateRoot(false)
+
+	assert.Error(app.T(), synced.syncTrustedState(1, "0x1234"))
+	assert.NoError(app.T(), synced.syncTrustedState(1, root.Hex()))
+	layer, err := synced.txProcessor.LatestLayer()
+	assert.NoError(app.T(), err)
+	assert.Equal(app.T(), 1, int(layer))
+	assert.Equal(app.T(), root, synced.state.IntermediateRoot(false))
+	assert.Equal(app.T(), trusted.state.GetBalance(miner), synced.state.GetBalance(miner))
+
+	//the layers that are part of the synced state are not applied again
+	_, err = synced.txProcessor.ApplyTransactions(1, nil, []address.Address{miner}, nil)
+	assert.Error(app.T(), err)
+
+	//a node that already holds a state keeps it
+	assert.NoError(app.T(), trusted.syncTrustedState(5, common.Hash{}.Hex()))
+	layer, err = trusted.txProcessor.LatestLayer()
+	assert.NoError(app.T(), err)
+	assert.Equal(app.T(), 1, int(layer))
+}
+
 func TestAppTestSuite(t *testing.T) {
 	suite.Run(t, new(AppTestSuite))
 }
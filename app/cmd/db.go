@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// DbFlags holds the arguments of the db subcommands
+type DbFlags struct {
+	Repair bool
+}
+
+var DbArgs DbFlags
+
+// DbCmd groups the commands that operate on the local database, the node must not be running
+var DbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Inspect the local node database",
+}
+
+// DbCheckCmd verifies the consistency of the mesh and prints a json report
+var DbCheckCmd = &cobra.Command{
+	Use:          "check",
+	Short:        "Check the consistency of the mesh database",
+	SilenceUsage: true,
+}
+
+func init() {
+	DbCheckCmd.Flags().BoolVar(&DbArgs.Repair, "repair", false, "Drop references to missing or misplaced blocks")
+
+	DbCmd.AddCommand(DbCheckCmd)
+	RootCmd.AddCommand(DbCmd)
+}
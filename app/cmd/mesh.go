@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// MeshFlags holds the arguments of the mesh subcommands
+type MeshFlags struct {
+	File string
+	From uint32
+	To   uint32
+}
+
+var MeshArgs MeshFlags
+
+// MeshCmd groups the commands that operate on the local mesh database, the node must not be running
+var MeshCmd = &cobra.Command{
+	Use:   "mesh",
+	Short: "Export and import mesh layers",
+}
+
+// MeshExportCmd writes a range of layers with their blocks to a file
+var MeshExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export layers [from, to] of the local mesh to a file",
+}
+
+// MeshImportCmd adds the layers of an exported file to the local mesh
+var MeshImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import layers exported from another node into the local mesh",
+}
+
+func init() {
+	MeshCmd.PersistentFlags().StringVarP(&MeshArgs.File, "file", "f", "mesh.dat", "Mesh export file")
+	MeshExportCmd.Flags().Uint32Var(&MeshArgs.From, "from", 0, "First layer to export")
+	MeshExportCmd.Flags().Uint32Var(&MeshArgs.To, "to", 0, "Last layer to export")
+
+	MeshCmd.AddCommand(MeshExportCmd)
+	MeshCmd.AddCommand(MeshImportCmd)
+	RootCmd.AddCommand(MeshCmd)
+}
@@ -37,6 +37,14 @@ func init() {
 		config.GenesisTime, "Time of the genesis layer in 2019-13-02T17:02:00+00:00 format")
 	RootCmd.PersistentFlags().Uint32Var(&config.LayerDurationSec, "layer-duration-sec",
 		config.LayerDurationSec, "Duration between layers in seconds")
+	RootCmd.PersistentFlags().Uint32Var(&config.LayerAvgSize, "layer-average-size",
+		config.LayerAvgSize, "Expected number of blocks in a layer")
+	RootCmd.PersistentFlags().StringVar(&config.Coinbase, "coinbase",
+		config.Coinbase, "Hex address the rewards of the blocks built by the node are paid to")
+	RootCmd.PersistentFlags().Uint32Var(&config.TrustedLayer, "trusted-layer",
+		config.TrustedLayer, "Layer of the trusted state root")
+	RootCmd.PersistentFlags().StringVar(&config.TrustedRoot, "trusted-root",
+		config.TrustedRoot, "Hex state root of the trusted layer, synced from peers on startup when the node has no state")
 	/** ======================== P2P Flags ========================== **/
 	RootCmd.PersistentFlags().IntVar(&config.P2P.SecurityParam, "security-param",
 		config.P2P.SecurityParam, "Consensus protocol k security param")
@@ -96,6 +104,20 @@ func init() {
 	/**========================Consensus Flags ========================== **/
 	//todo: add this here
 
+	/**======================== Mesh Flags ========================== **/
+	RootCmd.PersistentFlags().Uint32Var(&config.MESH.RetainLayers, "retain-layers",
+		config.MESH.RetainLayers, "Number of verified layers to keep blocks for, 0 keeps all layers")
+	RootCmd.PersistentFlags().DurationVar(&config.MESH.RetainAge, "retain-age",
+		config.MESH.RetainAge, "Max age of kept layer blocks, 0 keeps all layers")
+	RootCmd.PersistentFlags().Uint32Var(&config.MESH.ReorgWindow, "reorg-window",
+		config.MESH.ReorgWindow, "Number of verified layers whose blocks are kept regardless of the retention policy")
+
+	/**======================== Mempool Flags ========================== **/
+	RootCmd.PersistentFlags().IntVar(&config.MEMPOOL.MaxTxs, "mempool-max-txs",
+		config.MEMPOOL.MaxTxs, "Number of pending transactions kept in the transaction pool")
+	RootCmd.PersistentFlags().IntVar(&config.MEMPOOL.MaxSenderTxs, "mempool-max-sender-txs",
+		config.MEMPOOL.MaxSenderTxs, "Number of pending transactions of a single sender kept in the transaction pool")
+
 	RootCmd.AddCommand(VersionCmd)
 
 	// Bind Flags to config
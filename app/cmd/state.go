@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// StateFlags holds the arguments of the state subcommands
+type StateFlags struct {
+	File  string
+	Layer uint64
+}
+
+var StateArgs StateFlags
+
+// StateCmd groups the commands that operate on the local account state, the node must not be running
+var StateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Export and import state snapshots",
+}
+
+// StateExportCmd writes the accounts of the state of a layer to a snapshot file
+var StateExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the state of a layer to a snapshot file, the last applied layer by default",
+}
+
+// StateImportCmd writes the accounts of a snapshot file to an empty local database
+var StateImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Bootstrap an empty local database from a state snapshot",
+}
+
+func init() {
+	StateCmd.PersistentFlags().StringVarP(&StateArgs.File, "file", "f", "state.dat", "State snapshot file")
+	StateExportCmd.Flags().Uint64Var(&StateArgs.Layer, "layer", 0, "Layer whose state is exported")
+
+	StateCmd.AddCommand(StateExportCmd)
+	StateCmd.AddCommand(StateImportCmd)
+	RootCmd.AddCommand(StateCmd)
+}
@@ -0,0 +1,30 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spacemeshos/go-spacemesh/app/cmd"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/mesh"
+	"github.com/spf13/cobra"
+)
+
+func (app *SpacemeshApp) checkDb(c *cobra.Command, args []string) error {
+	db, err := database.NewLDBDatabase(dbStorePath, 0, 0)
+	if err != nil {
+		return fmt.Errorf("could not open database, is the node running? %v", err)
+	}
+	defer db.Close()
+
+	report := mesh.Check(database.NewTable(db, "layers/"), database.NewTable(db, "blocks/"), database.NewTable(db, "validity/"), cmd.DbArgs.Repair)
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+
+	if n := report.Unrepaired(); n > 0 {
+		return fmt.Errorf("found %v inconsistencies in the mesh database", n)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/seehuhn/mt19937"
+	"github.com/spacemeshos/go-spacemesh/address"
 	"github.com/spacemeshos/go-spacemesh/api/config"
 	"github.com/spacemeshos/go-spacemesh/common"
 	"github.com/spacemeshos/go-spacemesh/consensus"
@@ -11,6 +12,7 @@ import (
 	"github.com/spacemeshos/go-spacemesh/database"
 	"github.com/spacemeshos/go-spacemesh/hare"
 	hareConfig "github.com/spacemeshos/go-spacemesh/hare/config"
+	"github.com/spacemeshos/go-spacemesh/mempool"
 	"github.com/spacemeshos/go-spacemesh/mesh"
 	"github.com/spacemeshos/go-spacemesh/metrics"
 	"github.com/spacemeshos/go-spacemesh/miner"
@@ -48,8 +50,12 @@ type SpacemeshApp struct {
 	jsonAPIService   *api.JSONHTTPServer
 
 	blockListener    *sync.BlockListener
+	stateSyncer      *sync.StateSyncer
 	db               database.Database
 	state            *state.StateDB
+	txProcessor      *state.TransactionProcessor
+	txPool           *mempool.TxPool
+	txPoolEvents     mesh.EventChannel
 	blockProducer    *miner.BlockBuilder
 	mesh             *mesh.Mesh
 	clock            *timesync.Ticker
@@ -61,6 +67,11 @@ type MiningEnabler interface {
 	MiningEligible() bool
 }
 
+// mining is paused and resumed on the block producer through the api
+var _ MiningEnabler = (*miner.BlockBuilder)(nil)
+
+const dbStorePath = "/tmp/" //todo: move under the data folder
+
 // EntryPointCreated channel is used to announce that the main App instance was created
 // mainly used for testing now.
 var EntryPointCreated = make(chan bool, 1)
@@ -149,6 +160,14 @@ func EnsureCLIFlags(cmd *cobra.Command, appcfg *cfg.Config) {
 			ff = reflect.TypeOf(appcfg.CONSENSUS)
 			elem = reflect.ValueOf(&appcfg.CONSENSUS).Elem()
 			assignFields(ff, elem, name)
+
+			ff = reflect.TypeOf(appcfg.MESH)
+			elem = reflect.ValueOf(&appcfg.MESH).Elem()
+			assignFields(ff, elem, name)
+
+			ff = reflect.TypeOf(appcfg.MEMPOOL)
+			elem = reflect.ValueOf(&appcfg.MEMPOOL).Elem()
+			assignFields(ff, elem, name)
 		}
 	})
 }
@@ -166,6 +185,14 @@ func newSpacemeshApp() *SpacemeshApp {
 	cmd.RootCmd.PreRunE = node.before
 	cmd.RootCmd.Run = node.startSpacemesh
 	cmd.RootCmd.PostRunE = node.cleanup
+	cmd.MeshCmd.PersistentPreRunE = node.loadConfig
+	cmd.MeshExportCmd.RunE = node.exportMesh
+	cmd.MeshImportCmd.RunE = node.importMesh
+	cmd.DbCmd.PersistentPreRunE = node.loadConfig
+	cmd.DbCheckCmd.RunE = node.checkDb
+	cmd.StateCmd.PersistentPreRunE = node.loadConfig
+	cmd.StateExportCmd.RunE = node.exportState
+	cmd.StateImportCmd.RunE = node.importState
 
 	return node
 
@@ -277,13 +304,17 @@ func (app *SpacemeshApp) cleanup(cmd *cobra.Command, args []string) (err error)
 }
 
 func (app *SpacemeshApp) setupGenesis(cfg *config.GenesisConfig) {
-	for id, acc := range cfg.InitialAccounts {
-		app.state.CreateAccount(id)
-		app.state.AddBalance(id, acc.Balance)
-		app.state.SetNonce(id, acc.Nonce)
+	app.txProcessor.SetLayerReward(cfg.LayerReward)
+	if layer, err := app.txProcessor.LatestLayer(); err == nil {
+		log.Info("state was loaded from layer %v, skipping genesis accounts", layer)
+	} else {
+		for id, acc := range cfg.InitialAccounts {
+			app.state.CreateAccount(id)
+			app.state.AddBalance(id, acc.Balance)
+			app.state.SetNonce(id, acc.Nonce)
+		}
+		app.state.Commit(false)
 	}
-
-	app.state.Commit(false)
 	app.mesh.AddLayer(consensus.CreateGenesisLayer())
 }
 
@@ -292,6 +323,30 @@ func (app *SpacemeshApp) setupTestFeatures() {
 	api.ApproveAPIGossipMessages(Ctx, app.P2P)
 }
 
+// initMesh creates the mesh and the state it applies transactions to on top of db,
+// the transaction processor is kept on the app so the genesis can set the layer reward and the api can query receipts and proofs
+func (app *SpacemeshApp) initMesh(db database.Database, lg log.Log) (*mesh.Mesh, *state.StateDB, error) {
+	roots := database.NewTable(db, "stateroots/")
+	root := common.Hash{}
+	if layer, r, err := state.LoadLatestRoot(roots); err == nil {
+		lg.Info("loading state of layer %v root %x", layer, r)
+		root = r
+	}
+	st, err := state.New(root, state.NewDatabase(db))
+	if err != nil {
+		return nil, nil, err
+	}
+	rng := rand.New(mt19937.New())
+	processor := state.NewTransactionProcessor(rng, st, roots, database.NewTable(db, "receipts/"), lg)
+	app.txProcessor = processor
+
+	//trtl := consensus.NewTortoise(50, 100)
+	trtl := consensus.NewAlgorithm(consensus.NewNinjaTortoise(app.Config.LayerAvgSize))
+	//the mesh persists its state across restarts, keep its databases apart from each other and from the state
+	msh := mesh.NewMesh(app.Config.MESH, database.NewTable(db, "layers/"), database.NewTable(db, "blocks/"), database.NewTable(db, "validity/"), trtl, processor, lg) //todo: what to do with the logger?
+	return msh, st, nil
+}
+
 func (app *SpacemeshApp) initServices(instanceName string, swarm server.Service, dbStorepath string, sgn hare.Signing, blockOracle oracle.BlockOracle, hareOracle hare.Rolacle) error {
 
 	//todo: should we add all components to a single struct?
@@ -300,16 +355,10 @@ func (app *SpacemeshApp) initServices(instanceName string, swarm server.Service,
 	if err != nil {
 		return err
 	}
-	st, err := state.New(common.Hash{}, state.NewDatabase(db)) //todo: we probably should load DB with latest hash
+	mesh, st, err := app.initMesh(db, lg)
 	if err != nil {
 		return err
 	}
-	rng := rand.New(mt19937.New())
-	processor := state.NewTransactionProcessor(rng, st, lg)
-
-	//trtl := consensus.NewTortoise(50, 100)
-	trtl := consensus.NewAlgorithm(consensus.NewNinjaTortoise(50))
-	mesh := mesh.NewMesh(db, db, db, trtl, processor, lg) //todo: what to do with the logger?
 
 	coinToss := consensus.WeakCoin{}
 	gTime, err := time.Parse(time.RFC3339, app.Config.GenesisTime)
@@ -319,13 +368,27 @@ func (app *SpacemeshApp) initServices(instanceName string, swarm server.Service,
 	clock := timesync.NewTicker(timesync.RealClock{}, time.Duration(app.Config.LayerDurationSec)*time.Second, gTime)
 
 	blockListener := sync.NewBlockListener(swarm, blockOracle, mesh, 1*time.Second, 1, clock, lg)
+	//serves the trie nodes of the local state to peers that sync the state of a trusted layer
+	stateSyncer := sync.NewStateSync(swarm, db, 1*time.Second, 1, lg)
 
 	ha := hare.New(hareConfig.DefaultConfig(), swarm, sgn, mesh, hareOracle, clock.Subscribe())
 
-	blockProducer := miner.NewBlockBuilder(instanceName, swarm, clock.Subscribe(), coinToss, mesh, ha, blockOracle, lg)
+	//the pool validates the pending transactions against the current state, it reads it through the processor
+	//since layers are applied to it meanwhile
+	txPool := mempool.NewTxPool(app.Config.MEMPOOL, app.txProcessor, lg)
+	coinbase := address.PublicKeyToAddress(sgn.Verifier().Bytes())
+	if app.Config.Coinbase != "" {
+		if len(common.FromHex(app.Config.Coinbase)) == 0 {
+			return fmt.Errorf("invalid coinbase address %v", app.Config.Coinbase)
+		}
+		coinbase = address.HexToAddress(app.Config.Coinbase)
+	}
+	blockProducer := miner.NewBlockBuilder(sgn.Verifier().String(), coinbase, sgn, swarm, clock.Subscribe(), coinToss, mesh, ha, blockOracle, txPool, lg)
 
+	app.txPool = txPool
 	app.blockProducer = &blockProducer
 	app.blockListener = blockListener
+	app.stateSyncer = stateSyncer
 	app.mesh = mesh
 	app.clock = clock
 	app.state = st
@@ -337,6 +400,9 @@ func (app *SpacemeshApp) initServices(instanceName string, swarm server.Service,
 }
 
 func (app *SpacemeshApp) startServices() {
+	//transactions are dropped from the pool once a layer uses their nonce
+	app.txPoolEvents = app.mesh.Subscribe(16, mesh.LayerApplied, mesh.Reorg)
+	go app.txPool.Listen(app.txPoolEvents)
 	app.blockListener.Start()
 	err := app.hare.Start()
 	if err != nil {
@@ -360,6 +426,8 @@ func (app *SpacemeshApp) stopServices() {
 	}
 	app.hare.Close() //todo: need to add this
 	app.blockListener.Close()
+	app.stateSyncer.Close()
+	app.mesh.Unsubscribe(app.txPoolEvents)
 
 	app.db.Close()
 
@@ -395,7 +463,7 @@ func (app *SpacemeshApp) startSpacemesh(cmd *cobra.Command, args []string) {
 
 	apiConf := &app.Config.API
 
-	err = app.initServices("x", swarm, "/tmp/", sgn, bo, hareOracle)
+	err = app.initServices("x", swarm, dbStorePath, sgn, bo, hareOracle)
 	if err != nil {
 		log.Error("cannot start services %v", err.Error())
 		return
@@ -413,8 +481,7 @@ func (app *SpacemeshApp) startSpacemesh(cmd *cobra.Command, args []string) {
 		panic("got error starting services : " + err.Error())
 	}
 
-	app.startServices()
-
+	//p2p is started before the services so the trusted state is synced from the peers before any layer is applied
 	err = app.P2P.Start()
 
 	if err != nil {
@@ -422,6 +489,15 @@ func (app *SpacemeshApp) startSpacemesh(cmd *cobra.Command, args []string) {
 		panic("Error starting p2p services")
 	}
 
+	if app.Config.TrustedRoot != "" {
+		if err := app.syncTrustedState(state.LayerID(app.Config.TrustedLayer), app.Config.TrustedRoot); err != nil {
+			log.Error("cannot sync trusted state %v", err)
+			panic("cannot sync trusted state")
+		}
+	}
+
+	app.startServices()
+
 	// todo: if there's no loaded account - do the new account interactive flow here
 	// todo: if node has no loaded coin-base account then set the node coinbase to first account
 	// todo: if node has a locked coinbase account then prompt for account passphrase to unlock it
@@ -431,7 +507,7 @@ func (app *SpacemeshApp) startSpacemesh(cmd *cobra.Command, args []string) {
 	// start api servers
 	if apiConf.StartGrpcServer || apiConf.StartJSONServer {
 		// start grpc if specified or if json rpc specified
-		app.grpcAPIService = api.NewGrpcService(app.P2P, app.state)
+		app.grpcAPIService = api.NewGrpcService(app.P2P, app.state, app.txProcessor, app.txProcessor, app.txProcessor, app.txPool, app.blockProducer, app.mesh)
 		app.grpcAPIService.StartService(nil)
 	}
 
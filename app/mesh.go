@@ -0,0 +1,81 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/spacemeshos/go-spacemesh/app/cmd"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/mesh"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+// loadConfig parses the config for commands that run without starting the node
+func (app *SpacemeshApp) loadConfig(c *cobra.Command, args []string) error {
+	if err := app.ParseConfig(); err != nil {
+		return err
+	}
+	EnsureCLIFlags(c, app.Config)
+	return nil
+}
+
+func (app *SpacemeshApp) openMesh() (*mesh.Mesh, database.Database, error) {
+	db, err := database.NewLDBDatabase(dbStorePath, 0, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open database, is the node running? %v", err)
+	}
+	msh, _, err := app.initMesh(db, log.New("mesh", "", ""))
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return msh, db, nil
+}
+
+func (app *SpacemeshApp) exportMesh(c *cobra.Command, args []string) error {
+	db, err := database.NewLDBDatabase(dbStorePath, 0, 0)
+	if err != nil {
+		return fmt.Errorf("could not open database, is the node running? %v", err)
+	}
+	defer db.Close()
+	//the export only reads the stored layers, the mesh and the tortoise are not built for it
+	mdb := mesh.NewMeshDB(database.NewTable(db, "layers/"), database.NewTable(db, "blocks/"), database.NewTable(db, "validity/"))
+
+	f, err := os.Create(cmd.MeshArgs.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if err := mdb.Export(w, mesh.LayerID(cmd.MeshArgs.From), mesh.LayerID(cmd.MeshArgs.To)); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("exported layers %v-%v to %v\n", cmd.MeshArgs.From, cmd.MeshArgs.To, cmd.MeshArgs.File)
+	return nil
+}
+
+func (app *SpacemeshApp) importMesh(c *cobra.Command, args []string) error {
+	msh, db, err := app.openMesh()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	f, err := os.Open(cmd.MeshArgs.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	from, to, err := msh.Import(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported layers %v-%v from %v\n", from, to, cmd.MeshArgs.File)
+	return nil
+}
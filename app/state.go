@@ -0,0 +1,99 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/spacemeshos/go-spacemesh/app/cmd"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/state"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+const trustedStateRetry = 5 * time.Second
+
+func (app *SpacemeshApp) exportState(c *cobra.Command, args []string) error {
+	db, err := database.NewLDBDatabase(dbStorePath, 0, 0)
+	if err != nil {
+		return fmt.Errorf("could not open database, is the node running? %v", err)
+	}
+	defer db.Close()
+
+	roots := database.NewTable(db, "stateroots/")
+	layer := state.LayerID(cmd.StateArgs.Layer)
+	if !c.Flags().Changed("layer") {
+		if layer, _, err = state.LoadLatestRoot(roots); err != nil {
+			return fmt.Errorf("no layer was applied to the state yet %v", err)
+		}
+	}
+
+	f, err := os.Create(cmd.StateArgs.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	n, err := state.ExportSnapshot(w, state.NewDatabase(db), roots, layer)
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("exported %v accounts of layer %v to %v\n", n, layer, cmd.StateArgs.File)
+	return nil
+}
+
+func (app *SpacemeshApp) importState(c *cobra.Command, args []string) error {
+	db, err := database.NewLDBDatabase(dbStorePath, 0, 0)
+	if err != nil {
+		return fmt.Errorf("could not open database, is the node running? %v", err)
+	}
+	defer db.Close()
+
+	f, err := os.Open(cmd.StateArgs.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	layer, root, err := state.ImportSnapshot(bufio.NewReader(f), state.NewDatabase(db), database.NewTable(db, "stateroots/"))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported state of layer %v with root %x from %v\n", layer, root, cmd.StateArgs.File)
+	return nil
+}
+
+// syncTrustedState fetches the state with the given root from the peers and records it as the state of layer, the
+// mesh then only applies the layers after it. a node that already holds a state keeps it
+func (app *SpacemeshApp) syncTrustedState(layer state.LayerID, hexRoot string) error {
+	b := common.FromHex(hexRoot)
+	if len(b) != common.HashLength {
+		return fmt.Errorf("invalid trusted root %v", hexRoot)
+	}
+	root := common.BytesToHash(b)
+	if latest, err := app.txProcessor.LatestLayer(); err == nil {
+		log.Info("state was loaded from layer %v, not syncing trusted layer %v", latest, layer)
+		return nil
+	}
+
+	//the sync continues from the nodes it already wrote, e.g. before it ran out of peers
+	for {
+		err := app.stateSyncer.SyncState(root)
+		if err == nil {
+			break
+		}
+		log.Warning("could not sync state %x of trusted layer %v, retrying %v", root, layer, err)
+		select {
+		case <-Ctx.Done():
+			return Ctx.Err()
+		case <-time.After(trustedStateRetry):
+		}
+	}
+	return app.txProcessor.SetSyncedState(layer, root)
+}
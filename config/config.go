@@ -6,6 +6,8 @@ import (
 	consensusConfig "github.com/spacemeshos/go-spacemesh/consensus/config"
 	"github.com/spacemeshos/go-spacemesh/filesystem"
 	"github.com/spacemeshos/go-spacemesh/log"
+	mempoolConfig "github.com/spacemeshos/go-spacemesh/mempool/config"
+	meshConfig "github.com/spacemeshos/go-spacemesh/mesh/config"
 	p2pConfig "github.com/spacemeshos/go-spacemesh/p2p/config"
 	"github.com/spf13/viper"
 	"path/filepath"
@@ -34,6 +36,8 @@ type Config struct {
 	P2P        p2pConfig.Config       `mapstructure:"p2p"`
 	API        apiConfig.Config       `mapstructure:"api"`
 	CONSENSUS  consensusConfig.Config `mapstructure:"consensus"`
+	MESH       meshConfig.Config      `mapstructure:"mesh"`
+	MEMPOOL    mempoolConfig.Config   `mapstructure:"mempool"`
 }
 
 // BaseConfig defines the default configuration options for spacemesh app
@@ -58,6 +62,12 @@ type BaseConfig struct {
 
 	GenesisTime      string `mapstructure:"genesis-time"`
 	LayerDurationSec uint32 `mapstructure:"layer-duration-sec"`
+	LayerAvgSize     uint32 `mapstructure:"layer-average-size"` //expected number of blocks in a layer, the tortoise decides on a block once enough of them voted
+
+	Coinbase string `mapstructure:"coinbase"` //hex address recorded in the blocks of the node, derived from the signing key when empty
+
+	TrustedLayer uint32 `mapstructure:"trusted-layer"` //layer of the trusted root
+	TrustedRoot  string `mapstructure:"trusted-root"`  //hex state root synced from peers on startup when the node has no state yet
 }
 
 // DefaultConfig returns the default configuration for a spacemesh node
@@ -67,6 +77,8 @@ func DefaultConfig() Config {
 		P2P:        p2pConfig.DefaultConfig(),
 		API:        apiConfig.DefaultConfig(),
 		CONSENSUS:  consensusConfig.DefaultConfig(),
+		MESH:       meshConfig.DefaultConfig(),
+		MEMPOOL:    mempoolConfig.DefaultConfig(),
 	}
 }
 
@@ -85,6 +97,10 @@ func defaultBaseConfig() BaseConfig {
 		OracleServerWorldId: 0,
 		GenesisTime:         time.Now().Format(time.RFC3339),
 		LayerDurationSec:    5,
+		LayerAvgSize:        50,
+		Coinbase:            "",
+		TrustedLayer:        0,
+		TrustedRoot:         "",
 	}
 }
 
@@ -7,11 +7,17 @@ import (
 
 type Algorithm struct {
 	Tortoise
-	callback func(mesh.LayerID)
+	callback         func(mesh.LayerID)
+	validityCallback func(mesh.BlockID, bool)
+	next             mesh.LayerID //first layer whose completion was not reported yet
 }
 
 type Tortoise interface {
 	handleIncomingLayer(ll *mesh.Layer)
+	//returns the blocks whose contextual validity was decided or changed since the last call
+	validityChanges() map[mesh.BlockID]bool
+	//returns the latest layer whose blocks the tortoise has an opinion on
+	latestComplete() mesh.LayerID
 }
 
 func NewAlgorithm(trtl Tortoise) *Algorithm {
@@ -22,22 +28,34 @@ func (alg *Algorithm) RegisterLayerCallback(callback func(mesh.LayerID)) {
 	alg.callback = callback
 }
 
+func (alg *Algorithm) RegisterValidityCallback(callback func(mesh.BlockID, bool)) {
+	alg.validityCallback = callback
+}
+
 func (alg *Algorithm) HandleLateBlock(b *mesh.Block) {
 	log.Info("received block with layer Id %v block id: %v ", b.Layer(), b.ID())
 }
 
 func (alg *Algorithm) HandleIncomingLayer(ll *mesh.Layer) {
 	alg.Tortoise.handleIncomingLayer(ll)
-	alg.callback(ll.Index())
+	if alg.validityCallback != nil {
+		for id, valid := range alg.Tortoise.validityChanges() {
+			alg.validityCallback(id, valid)
+		}
+	}
+	//a layer is complete once the tortoise decided on its blocks, which is a few layers after it was received
+	for ; alg.next <= alg.Tortoise.latestComplete(); alg.next++ {
+		alg.callback(alg.next)
+	}
 }
 
 func CreateGenesisLayer() *mesh.Layer {
 	log.Info("Creating genesis")
 	bl := &mesh.Block{
-		Id:         mesh.BlockID(0),
-		LayerIndex: 0,
+		LayerIndex: Genesis,
 		Data:       []byte("genesis"),
 	}
+	bl.Id = bl.CalcId()
 	l := mesh.NewLayer(Genesis)
 	l.AddBlock(bl)
 	return l
@@ -6,7 +6,7 @@ import (
 	"time"
 )
 
-type BlockID uint32
+type BlockID uint64
 type LayerID uint32
 
 var layerCounter LayerID = 0
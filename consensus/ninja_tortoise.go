@@ -80,6 +80,8 @@ type ninjaTortoise struct {
 	tTally             map[votingPattern]map[mesh.BlockID]vec           //for pattern p and block b count votes for b according to p
 	tPattern           map[votingPattern]map[mesh.BlockID]struct{}      //set of blocks that comprise pattern p
 	tPatSupport        map[votingPattern]map[mesh.LayerID]votingPattern //pattern support count
+	tValid             map[mesh.BlockID]bool                            //contextual validity last reported for a block
+	tValidChanged      map[mesh.BlockID]bool                            //contextual validity changes not yet reported
 }
 
 func NewNinjaTortoise(layerSize uint32) *ninjaTortoise {
@@ -100,6 +102,8 @@ func NewNinjaTortoise(layerSize uint32) *ninjaTortoise {
 		tComplete:          map[votingPattern]struct{}{},
 		tEffectiveToBlocks: map[votingPattern][]mesh.BlockID{},
 		tPatSupport:        map[votingPattern]map[mesh.LayerID]votingPattern{},
+		tValid:             map[mesh.BlockID]bool{},
+		tValidChanged:      map[mesh.BlockID]bool{},
 	}
 }
 
@@ -154,7 +158,7 @@ func getId(bids []mesh.BlockID) PatternId {
 	// calc
 	h := fnv.New32()
 	for i := 0; i < len(bids); i++ {
-		h.Write(common.Uint32ToBytes(uint32(bids[i])))
+		h.Write(common.Uint64ToBytes(uint64(bids[i])))
 	}
 	// update
 	sum := h.Sum32()
@@ -370,6 +374,46 @@ func (ni *ninjaTortoise) handleGenesis(genesis *mesh.Layer) {
 	ni.pBase = vp
 	ni.tGood[Genesis] = vp
 	ni.tExplicit[genesis.Blocks()[0].ID()] = make(map[mesh.LayerID]votingPattern, K*ni.avgLayerSize)
+	for _, b := range genesis.Blocks() {
+		ni.setValidity(b.ID(), true)
+	}
+}
+
+func (ni *ninjaTortoise) setValidity(id mesh.BlockID, valid bool) {
+	if v, found := ni.tValid[id]; found && v == valid {
+		return
+	}
+	ni.tValid[id] = valid
+	ni.tValidChanged[id] = valid
+}
+
+//blocks are valid or invalid according to the global opinion of the latest complete pattern,
+//blocks the pattern abstains on stay undecided, the genesis is valid regardless of the votes
+func (ni *ninjaTortoise) updateValidity() {
+	for bid, vote := range ni.tVote[ni.pBase] {
+		if b, found := ni.blocks[bid]; found && b.Layer() == Genesis {
+			continue
+		}
+		if vote == Support {
+			ni.setValidity(bid, true)
+		} else if vote == Against {
+			ni.setValidity(bid, false)
+		}
+	}
+}
+
+//the votes of the latest complete pattern decide the blocks of the layers before it
+func (ni *ninjaTortoise) latestComplete() mesh.LayerID {
+	if ni.pBase.Layer() == Genesis {
+		return Genesis
+	}
+	return ni.pBase.Layer() - 1
+}
+
+func (ni *ninjaTortoise) validityChanges() map[mesh.BlockID]bool {
+	changes := ni.tValidChanged
+	ni.tValidChanged = map[mesh.BlockID]bool{}
+	return changes
 }
 
 //todo send map instead of ni
@@ -481,5 +525,6 @@ func (ni *ninjaTortoise) handleIncomingLayer(newlyr *mesh.Layer) { //i most rece
 			}
 		}
 	}
+	ni.updateValidity()
 	return
 }
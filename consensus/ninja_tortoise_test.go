@@ -137,11 +137,11 @@ func TestNinjaTortoise_Sanity2(t *testing.T) {
 func createMulExplicitLayer(index mesh.LayerID, prev map[mesh.LayerID]*mesh.Layer, patterns map[mesh.LayerID][]int, blocksInLayer int) *mesh.Layer {
 	ts := time.Now()
 	coin := false
-	// just some random Data
-	data := []byte(crypto.UUIDString())
 	l := mesh.NewLayer(index)
 	layerBlocks := make([]mesh.BlockID, 0, blocksInLayer)
 	for i := 0; i < blocksInLayer; i++ {
+		// just some random Data, blocks with equal content share an id
+		data := []byte(crypto.UUIDString())
 		bl := mesh.NewBlock(coin, data, ts, 1)
 		layerBlocks = append(layerBlocks, bl.ID())
 
@@ -166,8 +166,6 @@ func createMulExplicitLayer(index mesh.LayerID, prev map[mesh.LayerID]*mesh.Laye
 func createLayerWithRandVoting(index mesh.LayerID, prev []*mesh.Layer, blocksInLayer int, patternSize int) *mesh.Layer {
 	ts := time.Now()
 	coin := false
-	// just some random Data
-	data := []byte(crypto.UUIDString())
 	l := mesh.NewLayer(index)
 	var patterns [][]int
 	for _, l := range prev {
@@ -177,6 +175,8 @@ func createLayerWithRandVoting(index mesh.LayerID, prev []*mesh.Layer, blocksInL
 	}
 	layerBlocks := make([]mesh.BlockID, 0, blocksInLayer)
 	for i := 0; i < blocksInLayer; i++ {
+		// just some random Data, blocks with equal content share an id
+		data := []byte(crypto.UUIDString())
 		bl := mesh.NewBlock(coin, data, ts, 1)
 		layerBlocks = append(layerBlocks, bl.ID())
 		for idx, pat := range patterns {
@@ -203,3 +203,33 @@ func chooseRandomPattern(blocksInLayer int, patternSize int) []int {
 	}
 	return indexes
 }
+
+func TestNinjaTortoise_ValidityChanges(t *testing.T) {
+	alg := NewNinjaTortoise(uint32(3))
+	l0 := createMulExplicitLayer(0, map[mesh.LayerID]*mesh.Layer{}, nil, 1)
+	alg.handleIncomingLayer(l0)
+	assert.Equal(t, map[mesh.BlockID]bool{l0.Blocks()[0].ID(): true}, alg.validityChanges())
+	assert.Empty(t, alg.validityChanges(), "changes should be reported once")
+
+	l1 := createMulExplicitLayer(1, map[mesh.LayerID]*mesh.Layer{0: l0}, map[mesh.LayerID][]int{0: {0}}, 3)
+	//no block of layer 2 votes for the last block of layer 1
+	l2 := createMulExplicitLayer(2, map[mesh.LayerID]*mesh.Layer{1: l1}, map[mesh.LayerID][]int{1: {0, 1}}, 3)
+	alg.handleIncomingLayer(l1)
+	alg.handleIncomingLayer(l2)
+	valid := alg.validityChanges()
+	prev := l2
+	for i := mesh.LayerID(3); i < 8; i++ {
+		lyr := createMulExplicitLayer(i, map[mesh.LayerID]*mesh.Layer{i - 1: prev}, map[mesh.LayerID][]int{i - 1: {0, 1, 2}}, 3)
+		alg.handleIncomingLayer(lyr)
+		for id, v := range alg.validityChanges() {
+			valid[id] = v
+		}
+		prev = lyr
+	}
+
+	assert.True(t, valid[l1.Blocks()[0].ID()])
+	assert.True(t, valid[l1.Blocks()[1].ID()])
+	v, found := valid[l1.Blocks()[2].ID()]
+	assert.True(t, found, "block that was not voted for should be decided")
+	assert.False(t, v, "block that was not voted for should be invalid")
+}
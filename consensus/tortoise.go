@@ -29,6 +29,7 @@ type tortoise struct {
 	remainingBlockIds  uint32
 	totalBlocks        uint32
 	layerReadyCallback func(layerId mesh.LayerID)
+	validityCallback   func(id mesh.BlockID, valid bool)
 	mu                 sync.Mutex
 }
 
@@ -55,6 +56,10 @@ func (alg *tortoise) RegisterLayerCallback(callback func(mesh.LayerID)) {
 	alg.layerReadyCallback = callback
 }
 
+func (alg *tortoise) RegisterValidityCallback(callback func(mesh.BlockID, bool)) {
+	alg.validityCallback = callback
+}
+
 func (alg *tortoise) GlobalVotingAvg() uint64 {
 	return 100
 }
@@ -225,12 +230,23 @@ func (alg *tortoise) HandleIncomingLayer(ll *mesh.Layer) {
 		return
 	}
 	alg.mu.Lock()
-	if _, exist := alg.layers[l.index-1]; exist {
+	if prev, exist := alg.layers[l.index-1]; exist {
+		alg.reportValidity(prev)
 		alg.layerReadyCallback(mesh.LayerID(l.index - 1))
 	}
 	alg.mu.Unlock()
 }
 
+//a block is contextually valid if the blocks of the following layer voted for it more than against it
+func (alg *tortoise) reportValidity(l *Layer) {
+	if alg.validityCallback == nil {
+		return
+	}
+	for _, b := range l.blocks {
+		alg.validityCallback(mesh.BlockID(b.Id), b.ProVotes > b.ConVotes)
+	}
+}
+
 func (alg *tortoise) HandleLateBlock(b *mesh.Block) {
 	log.Info("received block with layer Id %v block id: %v ", b.Layer(), b.ID())
 }
@@ -1,9 +1,15 @@
 package consensus
 
 import (
+	"errors"
+	"github.com/spacemeshos/go-spacemesh/address"
 	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/database"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/mesh"
+	"github.com/spacemeshos/go-spacemesh/mesh/config"
+	"github.com/spacemeshos/go-spacemesh/state"
+	"github.com/stretchr/testify/assert"
 	"testing"
 	"time"
 )
@@ -25,6 +31,82 @@ func TestAlgorithm_Sanity(t *testing.T) {
 	}
 }
 
+func TestAlgorithm_ContextualValidity(t *testing.T) {
+	layerSize := 5
+
+	alg := NewTortoise(uint32(layerSize), uint32(10))
+	alg.RegisterLayerCallback(func(id mesh.LayerID) {})
+	valid := make(map[mesh.BlockID]bool)
+	alg.RegisterValidityCallback(func(id mesh.BlockID, v bool) {
+		valid[id] = v
+	})
+	l := createGenesisLayer()
+	alg.HandleIncomingLayer(l)
+	l1 := createFullPointingLayer(l, layerSize)
+	alg.HandleIncomingLayer(l1)
+	l2 := createFullPointingLayer(l1, layerSize)
+	//the last block of layer 2 gets no votes
+	l3 := createFullPointingLayer(mesh.NewExistingLayer(l2.Index(), l2.Blocks()[:layerSize-1]), layerSize)
+	alg.HandleIncomingLayer(l2)
+	alg.HandleIncomingLayer(l3)
+
+	for _, b := range append(l.Blocks(), l1.Blocks()...) {
+		assert.True(t, valid[b.ID()], "block %v should be valid", b.ID())
+	}
+	for _, b := range l2.Blocks()[:layerSize-1] {
+		assert.True(t, valid[b.ID()], "block %v should be valid", b.ID())
+	}
+	v, found := valid[l2.Blocks()[layerSize-1].ID()]
+	assert.True(t, found)
+	assert.False(t, v, "block without votes should be invalid")
+}
+
+type stateMock struct{}
+
+func (stateMock) ApplyTransactions(layer state.LayerID, seed []byte, miners []address.Address, txs state.Transactions) (uint32, error) {
+	return 0, nil
+}
+
+func (stateMock) Reset(layer state.LayerID) error {
+	return nil
+}
+
+func (stateMock) LatestLayer() (state.LayerID, error) {
+	return 0, errors.New("no layer was applied")
+}
+
+func TestAlgorithm_RestartAfterPrune(t *testing.T) {
+	layerSize := 5
+	ldb, bdb, cdb := database.NewMemDatabase(), database.NewMemDatabase(), database.NewMemDatabase()
+	msh := mesh.NewMesh(config.Config{RetainLayers: 2}, ldb, bdb, cdb, NewAlgorithm(NewNinjaTortoise(uint32(layerSize))), stateMock{}, log.New("t1", "", ""))
+
+	l := createGenesisLayer()
+	for i := 0; i < 6; i++ {
+		if i > 0 {
+			l = createLayerWithRandVoting(l.Index()+1, []*mesh.Layer{l}, layerSize, layerSize)
+		}
+		assert.NoError(t, msh.AddLayer(l))
+		time.Sleep(100 * time.Millisecond)
+		msh.ValidateLayer(l)
+	}
+	_, err := msh.GetBlock(l.Blocks()[0].BlockVotes[0])
+	assert.NoError(t, err)
+	ids, err := msh.GetLayerBlockIDs(1)
+	assert.NoError(t, err)
+	_, err = msh.GetBlock(ids[0])
+	assert.Error(t, err, "blocks of layer 1 should be pruned")
+
+	//the tortoise is rebuilt from all the verified layers, including the pruned ones
+	restarted := mesh.NewMesh(config.Config{RetainLayers: 2}, ldb, bdb, cdb, NewAlgorithm(NewNinjaTortoise(uint32(layerSize))), stateMock{}, log.New("t1", "", ""))
+	//the tortoise decides a layer once the two layers after it were received
+	assert.Equal(t, uint32(3), restarted.VerifiedLayer())
+	next := createLayerWithRandVoting(l.Index()+1, []*mesh.Layer{l}, layerSize, layerSize)
+	assert.NoError(t, restarted.AddLayer(next))
+	time.Sleep(100 * time.Millisecond)
+	assert.NotPanics(t, func() { restarted.ValidateLayer(next) })
+	assert.Equal(t, uint32(4), restarted.VerifiedLayer())
+}
+
 func createGenesisLayer() *mesh.Layer {
 	log.Info("Creating genesis")
 	ts := time.Now()
@@ -42,10 +124,10 @@ func createGenesisLayer() *mesh.Layer {
 func createFullPointingLayer(prev *mesh.Layer, blocksInLayer int) *mesh.Layer {
 	ts := time.Now()
 	coin := false
-	// just some random Data
-	data := []byte(crypto.UUIDString())
 	l := mesh.NewLayer(prev.Index() + 1)
 	for i := 0; i < blocksInLayer; i++ {
+		// just some random Data, blocks with equal content share an id
+		data := []byte(crypto.UUIDString())
 		bl := mesh.NewBlock(coin, data, ts, 1)
 
 		for _, prevBloc := range prev.Blocks() {
@@ -193,3 +193,18 @@ func (p *publicKeyImpl) Verify(data []byte, sig []byte) (bool, error) {
 func (p *publicKeyImpl) Encrypt(in []byte) ([]byte, error) {
 	return btcec.Encrypt(p.k, in)
 }
+
+// SignRecoverable signs a 32 byte hash with the private key, the public key can be recovered from the
+// hash and the returned 65 bytes signature using RecoverPublicKey.
+func SignRecoverable(p PrivateKey, hash []byte) ([]byte, error) {
+	return btcec.SignCompact(btcec.S256(), p.InternalKey(), hash, true)
+}
+
+// RecoverPublicKey returns the public key of the private key that produced sig over hash.
+func RecoverPublicKey(hash []byte, sig []byte) (PublicKey, error) {
+	k, _, err := btcec.RecoverCompact(btcec.S256(), sig, hash)
+	if err != nil {
+		return nil, err
+	}
+	return &publicKeyImpl{k}, nil
+}
@@ -101,6 +101,27 @@ func TestCryptoApi(t *testing.T) {
 
 }
 
+func TestRecoverPublicKey(t *testing.T) {
+	priv, pub, err := GenerateKeyPair()
+	assert.NoError(t, err)
+
+	hash := Sha256([]byte("hello world"))
+	sig, err := SignRecoverable(priv, hash)
+	assert.NoError(t, err)
+
+	recovered, err := RecoverPublicKey(hash, sig)
+	assert.NoError(t, err)
+	assert.Equal(t, pub.Bytes(), recovered.Bytes())
+
+	recovered, err = RecoverPublicKey(Sha256([]byte("other message")), sig)
+	if err == nil {
+		assert.NotEqual(t, pub.Bytes(), recovered.Bytes())
+	}
+
+	_, err = RecoverPublicKey(hash, sig[1:])
+	assert.Error(t, err)
+}
+
 func BenchmarkVerify(b *testing.B) {
 	b.StopTimer()
 
@@ -126,7 +126,7 @@ func (h *Hare) collectOutput(output TerminationOutput) error {
 	blocks := make([]mesh.BlockID, len(set.values))
 	i := 0
 	for _, v := range set.values {
-		blocks[i] = mesh.BlockID(common.BytesToUint32(v.Bytes()))
+		blocks[i] = mesh.BlockID(common.BytesToUint64(v.Bytes()))
 		i++
 	}
 	h.mu.Lock()
@@ -134,7 +134,7 @@ func TestHare_GetResult(t *testing.T) {
 
 	require.NoError(t, err)
 
-	require.True(t, uint32(res[0]) == common.BytesToUint32(set.values[0].Bytes()))
+	require.True(t, uint64(res[0]) == common.BytesToUint64(set.values[0].Bytes()))
 
 }
 
@@ -197,7 +197,7 @@ func TestHare_collectOutput(t *testing.T) {
 	h.collectOutput(mockOutput{common.Uint32ToBytes(mockid), set})
 	output, ok := h.outputs[mesh.LayerID(mockid)]
 	require.True(t, ok)
-	require.Equal(t, output[0], mesh.BlockID(common.BytesToUint32(set.values[0].Bytes())))
+	require.Equal(t, output[0], mesh.BlockID(common.BytesToUint64(set.values[0].Bytes())))
 
 	mockid = uint32(2)
 
@@ -227,7 +227,7 @@ func TestHare_collectOutput2(t *testing.T) {
 	h.collectOutput(mockOutput{common.Uint32ToBytes(mockid), set})
 	output, ok := h.outputs[mesh.LayerID(mockid)]
 	require.True(t, ok)
-	require.Equal(t, output[0], mesh.BlockID(common.BytesToUint32(set.values[0].Bytes())))
+	require.Equal(t, output[0], mesh.BlockID(common.BytesToUint64(set.values[0].Bytes())))
 
 	h.lastLayer = 3
 	newmockid := uint32(1)
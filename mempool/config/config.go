@@ -0,0 +1,14 @@
+package config
+
+// Config holds the size limits of the transaction pool
+type Config struct {
+	MaxTxs       int `mapstructure:"max-txs"`        // number of pending transactions kept, the pool evicts beyond it
+	MaxSenderTxs int `mapstructure:"max-sender-txs"` // number of pending transactions of a single sender
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxTxs:       4096,
+		MaxSenderTxs: 64,
+	}
+}
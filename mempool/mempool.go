@@ -0,0 +1,265 @@
+// Package mempool keeps the transactions received from the network and the api until they are applied to the state
+package mempool
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spacemeshos/go-spacemesh/address"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/mempool/config"
+	"github.com/spacemeshos/go-spacemesh/mesh"
+	"github.com/spacemeshos/go-spacemesh/state"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StateReader is the state the transactions are validated against, usually the current state of the node.
+// it is read while layers are applied to it, so it has to be safe for concurrent use
+type StateReader interface {
+	Exist(addr address.Address) bool
+	GetBalance(addr address.Address) *big.Int
+	GetNonce(addr address.Address) uint64
+}
+
+var (
+	ErrKnown      = errors.New("transaction is already in the pool")
+	ErrOrigin     = errors.New("origin account doesnt exist")
+	ErrNonceLow   = errors.New("nonce was already used")
+	ErrNonceTaken = errors.New("another transaction of the sender with the same nonce is in the pool")
+	ErrFunds      = errors.New("insufficient funds")
+	ErrSenderFull = errors.New("too many pending transactions of the sender")
+	ErrPoolFull   = errors.New("pool is full")
+)
+
+// Tx is a transaction waiting in the pool, its origin was recovered from the signature
+type Tx struct {
+	*state.Transaction
+	Raw   mesh.SerializableTransaction
+	Size  int //size of the serialized transaction
+	Added time.Time
+	seq   uint64 //order of arrival
+}
+
+// cost is what the sender pays for the transaction, it has to hold more than that for it to be applied
+func (tx *Tx) cost() *big.Int {
+	return new(big.Int).Add(tx.Amount, tx.Fee())
+}
+
+// TxPool holds the pending transactions of every sender ordered by nonce. a transaction is only accepted if the
+// sender can afford it together with its pending transactions with lower nonces, the transactions stay in the
+// pool until their nonce is used by the state
+type TxPool struct {
+	log.Log
+	mu      sync.RWMutex
+	config  config.Config
+	state   StateReader
+	all     map[common.Hash]*Tx
+	senders map[address.Address][]*Tx
+	seq     uint64
+	policy  SelectionPolicy
+}
+
+func NewTxPool(conf config.Config, st StateReader, logger log.Log) *TxPool {
+	return &TxPool{
+		Log:     logger,
+		config:  conf,
+		state:   st,
+		all:     make(map[common.Hash]*Tx),
+		senders: make(map[address.Address][]*Tx),
+		policy:  FeePolicy{},
+	}
+}
+
+// SetPolicy replaces the policy the transactions of a block are selected by, the pool selects by FeePolicy by default
+func (p *TxPool) SetPolicy(policy SelectionPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.policy = policy
+}
+
+// Add validates the transaction against the state and the pending transactions of its sender and adds it to the pool,
+// when the pool is full the transaction with the highest nonce of the sender with the most transactions is evicted
+func (p *TxPool) Add(raw *mesh.SerializableTransaction) error {
+	t, err := mesh.SerializableTransaction2StateTransaction(raw)
+	if err != nil {
+		return fmt.Errorf("invalid signature %v", err)
+	}
+	b, err := mesh.TransactionAsBytes(raw)
+	if err != nil {
+		return err
+	}
+	tx := &Tx{Transaction: t, Raw: *raw, Size: len(b), Added: time.Now()}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.all[tx.Hash()]; ok {
+		return ErrKnown
+	}
+	if !p.state.Exist(tx.Origin) {
+		return ErrOrigin
+	}
+	if tx.AccountNonce < p.state.GetNonce(tx.Origin) {
+		return ErrNonceLow
+	}
+	pending := p.senders[tx.Origin]
+	i := sort.Search(len(pending), func(i int) bool { return pending[i].AccountNonce >= tx.AccountNonce })
+	if i < len(pending) && pending[i].AccountNonce == tx.AccountNonce {
+		return ErrNonceTaken
+	}
+	if len(pending) >= p.config.MaxSenderTxs {
+		return ErrSenderFull
+	}
+	cost := tx.cost()
+	for _, prev := range pending[:i] {
+		cost.Add(cost, prev.cost())
+	}
+	if p.state.GetBalance(tx.Origin).Cmp(cost) <= 0 {
+		return ErrFunds
+	}
+
+	p.seq++
+	tx.seq = p.seq
+	pending = append(pending, nil)
+	copy(pending[i+1:], pending[i:])
+	pending[i] = tx
+	p.senders[tx.Origin] = pending
+	p.all[tx.Hash()] = tx
+	if len(p.all) > p.config.MaxTxs && p.evict() == tx {
+		return ErrPoolFull
+	}
+	return nil
+}
+
+// evict removes the transaction with the highest nonce of the sender with the most pending transactions, so the
+// remaining transactions of the sender can still be applied in order. ties are broken by the latest arrival
+func (p *TxPool) evict() *Tx {
+	var victim *Tx
+	most := 0
+	for _, pending := range p.senders {
+		last := pending[len(pending)-1]
+		if len(pending) > most || (len(pending) == most && last.seq > victim.seq) {
+			victim, most = last, len(pending)
+		}
+	}
+	if victim != nil {
+		p.Debug("evicted transaction %x of %x from the pool", victim.Hash(), victim.Origin)
+		p.remove(victim)
+	}
+	return victim
+}
+
+func (p *TxPool) remove(tx *Tx) {
+	delete(p.all, tx.Hash())
+	pending := p.senders[tx.Origin]
+	for i, t := range pending {
+		if t == tx {
+			pending = append(pending[:i], pending[i+1:]...)
+			break
+		}
+	}
+	if len(pending) == 0 {
+		delete(p.senders, tx.Origin)
+		return
+	}
+	p.senders[tx.Origin] = pending
+}
+
+// ready returns the transactions of every sender that can be applied on top of the current state, these are the
+// pending transactions whose nonces follow the nonce of the sender without a gap
+func (p *TxPool) ready() [][]*Tx {
+	ready := make([][]*Tx, 0, len(p.senders))
+	for addr, pending := range p.senders {
+		nonce := p.state.GetNonce(addr)
+		n := 0
+		for ; n < len(pending) && pending[n].AccountNonce == nonce+uint64(n); n++ {
+		}
+		if n > 0 {
+			ready = append(ready, pending[:n])
+		}
+	}
+	return ready
+}
+
+// Select returns the transactions the policy of the pool picks within the budget out of the transactions that can
+// be applied on top of the current state. the selected transactions stay in the pool until the state uses their nonce
+func (p *TxPool) Select(budget Budget) []mesh.SerializableTransaction {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	txs := p.policy.Select(p.ready(), budget)
+	res := make([]mesh.SerializableTransaction, 0, len(txs))
+	for _, tx := range txs {
+		res = append(res, tx.Raw)
+	}
+	return res
+}
+
+// Revalidate drops the transactions whose nonce was used by the state, e.g. once they were applied in a layer, and
+// the transactions their sender can no longer afford. it returns the number of dropped transactions
+func (p *TxPool) Revalidate() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	dropped := 0
+	for addr, pending := range p.senders {
+		nonce := p.state.GetNonce(addr)
+		balance := p.state.GetBalance(addr)
+		cost := new(big.Int)
+		keep := make([]*Tx, 0, len(pending))
+		for _, tx := range pending {
+			if tx.AccountNonce >= nonce {
+				//the cost adds up, once the sender cannot afford a transaction it cannot afford the ones after it
+				if cost.Add(cost, tx.cost()); balance.Cmp(cost) > 0 {
+					keep = append(keep, tx)
+					continue
+				}
+			}
+			delete(p.all, tx.Hash())
+			dropped++
+		}
+		if len(keep) == 0 {
+			delete(p.senders, addr)
+			continue
+		}
+		p.senders[addr] = keep
+	}
+	return dropped
+}
+
+// Listen revalidates the pool on every event received until the channel is closed,
+// it should receive the events of the layers applied to the state
+func (p *TxPool) Listen(events mesh.EventChannel) {
+	for ev := range events {
+		if n := p.Revalidate(); n > 0 {
+			p.Info("dropped %v transactions from the pool after %v of layer %v", n, ev.Type, ev.Layer)
+		}
+	}
+}
+
+// Get returns the pending transaction with the given hash
+func (p *TxPool) Get(hash common.Hash) (*Tx, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	tx, ok := p.all[hash]
+	return tx, ok
+}
+
+// Transactions returns the pending transactions in the order they arrived in
+func (p *TxPool) Transactions() []*Tx {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	res := make([]*Tx, 0, len(p.all))
+	for _, tx := range p.all {
+		res = append(res, tx)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].seq < res[j].seq })
+	return res
+}
+
+// Len returns the number of pending transactions
+func (p *TxPool) Len() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.all)
+}
@@ -0,0 +1,227 @@
+package mempool
+
+import (
+	"github.com/seehuhn/mt19937"
+	"github.com/spacemeshos/go-spacemesh/address"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/mempool/config"
+	"github.com/spacemeshos/go-spacemesh/mesh"
+	"github.com/spacemeshos/go-spacemesh/state"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+type account struct {
+	balance int64
+	nonce   uint64
+}
+
+type stateMock map[address.Address]*account
+
+func (s stateMock) Exist(addr address.Address) bool {
+	_, ok := s[addr]
+	return ok
+}
+
+func (s stateMock) GetBalance(addr address.Address) *big.Int {
+	if a, ok := s[addr]; ok {
+		return big.NewInt(a.balance)
+	}
+	return new(big.Int)
+}
+
+func (s stateMock) GetNonce(addr address.Address) uint64 {
+	if a, ok := s[addr]; ok {
+		return a.nonce
+	}
+	return 0
+}
+
+func TestTxPool_ConcurrentWithProcessor(t *testing.T) {
+	st, err := state.New(common.Hash{}, state.NewDatabase(database.NewMemDatabase()))
+	assert.NoError(t, err)
+	processor := state.NewTransactionProcessor(rand.New(mt19937.New()), st, database.NewMemDatabase(), database.NewMemDatabase(), log.New("processor", "", ""))
+	processor.SetLayerReward(big.NewInt(10))
+	s := newSender(t, stateMock{}, 0, 0)
+	st.CreateAccount(s.addr)
+	st.AddBalance(s.addr, big.NewInt(1000))
+	_, err = st.Commit(false)
+	assert.NoError(t, err)
+	pool := NewTxPool(config.DefaultConfig(), processor, log.New("mempool", "", ""))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		//the sender is rewarded as a miner while the pool reads its balance
+		for l := state.LayerID(1); l <= 50; l++ {
+			_, err := processor.ApplyTransactions(l, nil, []address.Address{s.addr}, nil)
+			assert.NoError(t, err)
+		}
+	}()
+	for i := uint64(0); i < 50; i++ {
+		assert.NoError(t, pool.Add(s.tx(t, i, 1)))
+	}
+	assert.Equal(t, 0, pool.Revalidate())
+	<-done
+	assert.Equal(t, 50, pool.Len())
+}
+
+type sender struct {
+	key  crypto.PrivateKey
+	addr address.Address
+}
+
+func newSender(t *testing.T, st stateMock, balance int64, nonce uint64) sender {
+	priv, pub, err := crypto.GenerateKeyPair()
+	assert.NoError(t, err)
+	s := sender{key: priv, addr: address.PublicKeyToAddress(pub.Bytes())}
+	st[s.addr] = &account{balance: balance, nonce: nonce}
+	return s
+}
+
+// the transactions cost their amount plus a fee of 1
+func (s sender) tx(t *testing.T, nonce uint64, amount int64) *mesh.SerializableTransaction {
+	tx := mesh.NewSerializableTransaction(nonce, address.BytesToAddress([]byte{0x01}), big.NewInt(amount), big.NewInt(1), 1)
+	assert.NoError(t, tx.Sign(s.key))
+	return tx
+}
+
+func hashOf(t *testing.T, tx *mesh.SerializableTransaction) common.Hash {
+	st, err := mesh.SerializableTransaction2StateTransaction(tx)
+	assert.NoError(t, err)
+	return st.Hash()
+}
+
+func nonces(txs []mesh.SerializableTransaction) []uint64 {
+	res := make([]uint64, 0, len(txs))
+	for _, tx := range txs {
+		res = append(res, tx.AccountNonce)
+	}
+	return res
+}
+
+func TestTxPool_Add(t *testing.T) {
+	st := stateMock{}
+	pool := NewTxPool(config.Config{MaxTxs: 10, MaxSenderTxs: 3}, st, log.New("mempool", "", ""))
+	alice := newSender(t, st, 100, 2)
+
+	unsigned := mesh.NewSerializableTransaction(2, address.BytesToAddress([]byte{0x01}), big.NewInt(1), big.NewInt(1), 1)
+	assert.Error(t, pool.Add(unsigned))
+
+	priv, _, err := crypto.GenerateKeyPair()
+	assert.NoError(t, err)
+	assert.Equal(t, ErrOrigin, pool.Add(sender{key: priv}.tx(t, 0, 1)))
+	assert.Equal(t, ErrNonceLow, pool.Add(alice.tx(t, 1, 1)))
+
+	first := alice.tx(t, 2, 40)
+	assert.NoError(t, pool.Add(first))
+	assert.Equal(t, ErrKnown, pool.Add(first))
+	assert.Equal(t, ErrNonceTaken, pool.Add(alice.tx(t, 2, 10)))
+	assert.Equal(t, ErrFunds, pool.Add(alice.tx(t, 3, 59)), "the sender has to afford its pending transactions as well")
+	assert.NoError(t, pool.Add(alice.tx(t, 3, 50)))
+	assert.NoError(t, pool.Add(alice.tx(t, 5, 0)), "transactions with a nonce gap are kept")
+	assert.Equal(t, ErrSenderFull, pool.Add(alice.tx(t, 6, 0)))
+
+	assert.Equal(t, 3, pool.Len())
+	tx, ok := pool.Get(hashOf(t, first))
+	assert.True(t, ok)
+	assert.Equal(t, alice.addr, tx.Origin)
+	assert.Equal(t, []uint64{2, 3}, nonces(pool.Select(Budget{MaxTxs: 10})), "nonce 5 should wait for nonce 4")
+}
+
+func TestTxPool_Select(t *testing.T) {
+	st := stateMock{}
+	pool := NewTxPool(config.DefaultConfig(), st, log.New("mempool", "", ""))
+	alice := newSender(t, st, 100, 0)
+	bob := newSender(t, st, 100, 4)
+
+	assert.NoError(t, pool.Add(alice.tx(t, 1, 1)))
+	assert.NoError(t, pool.Add(bob.tx(t, 4, 1)))
+	assert.NoError(t, pool.Add(alice.tx(t, 0, 1)))
+	assert.NoError(t, pool.Add(bob.tx(t, 6, 1)))
+	assert.NoError(t, pool.Add(bob.tx(t, 5, 1)))
+	assert.NoError(t, pool.Add(alice.tx(t, 3, 1)))
+
+	//alice's transaction with nonce 1 arrived first but can only follow nonce 0, nonce 3 waits for nonce 2
+	selected := pool.Select(Budget{MaxTxs: 10})
+	assert.Equal(t, []uint64{4, 0, 1, 5, 6}, nonces(selected))
+	assert.Equal(t, []uint64{4, 0, 1}, nonces(pool.Select(Budget{MaxTxs: 3})))
+	assert.Equal(t, 6, pool.Len(), "selected transactions stay in the pool")
+
+	arrived := make([]uint64, 0, 6)
+	for _, tx := range pool.Transactions() {
+		arrived = append(arrived, tx.AccountNonce)
+	}
+	assert.Equal(t, []uint64{1, 4, 0, 6, 5, 3}, arrived)
+}
+
+func TestTxPool_Evict(t *testing.T) {
+	st := stateMock{}
+	pool := NewTxPool(config.Config{MaxTxs: 4, MaxSenderTxs: 10}, st, log.New("mempool", "", ""))
+	alice := newSender(t, st, 100, 0)
+	bob := newSender(t, st, 100, 0)
+
+	var last *mesh.SerializableTransaction
+	for i := uint64(0); i < 3; i++ {
+		last = alice.tx(t, i, 1)
+		assert.NoError(t, pool.Add(last))
+	}
+	assert.NoError(t, pool.Add(bob.tx(t, 0, 1)))
+	_, ok := pool.Get(hashOf(t, last))
+	assert.True(t, ok)
+
+	assert.NoError(t, pool.Add(bob.tx(t, 1, 1)))
+	assert.Equal(t, 4, pool.Len())
+	_, ok = pool.Get(hashOf(t, last))
+	assert.False(t, ok, "the last transaction of the largest sender should be evicted")
+
+	assert.Equal(t, ErrPoolFull, pool.Add(alice.tx(t, 2, 1)), "a transaction that is evicted right away is rejected")
+	assert.Equal(t, 4, pool.Len())
+}
+
+func TestTxPool_Revalidate(t *testing.T) {
+	st := stateMock{}
+	pool := NewTxPool(config.DefaultConfig(), st, log.New("mempool", "", ""))
+	alice := newSender(t, st, 100, 0)
+	bob := newSender(t, st, 100, 0)
+
+	for i := uint64(0); i < 3; i++ {
+		assert.NoError(t, pool.Add(alice.tx(t, i, 10)))
+		assert.NoError(t, pool.Add(bob.tx(t, i, 10)))
+	}
+	assert.Equal(t, 0, pool.Revalidate())
+
+	//a layer applied the first two transactions of alice and bob spent most of his balance
+	st[alice.addr] = &account{balance: 78, nonce: 2}
+	st[bob.addr] = &account{balance: 15, nonce: 0}
+	assert.Equal(t, 4, pool.Revalidate())
+	assert.Equal(t, []uint64{0, 2}, nonces(pool.Select(Budget{MaxTxs: 10})))
+
+	delete(st, bob.addr)
+	assert.Equal(t, 1, pool.Revalidate())
+	assert.Equal(t, 1, pool.Len())
+}
+
+func TestTxPool_Listen(t *testing.T) {
+	st := stateMock{}
+	pool := NewTxPool(config.DefaultConfig(), st, log.New("mempool", "", ""))
+	alice := newSender(t, st, 100, 0)
+	assert.NoError(t, pool.Add(alice.tx(t, 0, 10)))
+
+	events := make(mesh.EventChannel, 1)
+	done := make(chan struct{})
+	go func() {
+		pool.Listen(events)
+		close(done)
+	}()
+	st[alice.addr].nonce = 1
+	events <- mesh.Event{Type: mesh.LayerApplied, Layer: 1}
+	close(events)
+	<-done
+	assert.Equal(t, 0, pool.Len())
+}
@@ -0,0 +1,84 @@
+package mempool
+
+import "container/heap"
+
+// Budget limits the transactions selected for a block, a zero limit is not enforced
+type Budget struct {
+	MaxTxs   int
+	MaxGas   uint64 //sum of the gas limits of the transactions
+	MaxBytes int    //sum of the serialized sizes of the transactions
+}
+
+// SelectionPolicy picks the transactions of a block. ready holds the transactions of every sender that can be applied
+// on top of the current state ordered by nonce, a policy must keep that order for the transactions it picks of a sender
+type SelectionPolicy interface {
+	Select(ready [][]*Tx, budget Budget) []*Tx
+}
+
+// FeePolicy picks the transactions with the highest gas price first, transactions with the same price are picked
+// in the order they arrived in. a transaction that does not fit in the budget is skipped with the rest of the
+// transactions of its sender, smaller transactions of other senders may still fit
+type FeePolicy struct{}
+
+func (FeePolicy) Select(ready [][]*Tx, budget Budget) []*Tx {
+	return selectGreedy(ready, budget, func(a, b *Tx) bool {
+		if c := a.Price.Cmp(b.Price); c != 0 {
+			return c > 0
+		}
+		return a.seq < b.seq
+	})
+}
+
+// ArrivalPolicy picks the transactions in the order they arrived in regardless of their price
+type ArrivalPolicy struct{}
+
+func (ArrivalPolicy) Select(ready [][]*Tx, budget Budget) []*Tx {
+	return selectGreedy(ready, budget, func(a, b *Tx) bool {
+		return a.seq < b.seq
+	})
+}
+
+// senderQueue is the next transaction of every sender, the transactions of a sender become candidates in nonce order
+type senderQueue struct {
+	heads [][]*Tx
+	less  func(a, b *Tx) bool
+}
+
+func (q senderQueue) Len() int            { return len(q.heads) }
+func (q senderQueue) Less(i, j int) bool  { return q.less(q.heads[i][0], q.heads[j][0]) }
+func (q senderQueue) Swap(i, j int)       { q.heads[i], q.heads[j] = q.heads[j], q.heads[i] }
+func (q *senderQueue) Push(x interface{}) { q.heads = append(q.heads, x.([]*Tx)) }
+func (q *senderQueue) Pop() interface{} {
+	last := q.heads[len(q.heads)-1]
+	q.heads = q.heads[:len(q.heads)-1]
+	return last
+}
+
+// selectGreedy repeatedly takes the best next transaction of all senders by less until the budget is used up
+func selectGreedy(ready [][]*Tx, budget Budget, less func(a, b *Tx) bool) []*Tx {
+	q := &senderQueue{heads: make([][]*Tx, 0, len(ready)), less: less}
+	for _, txs := range ready {
+		if len(txs) > 0 {
+			q.heads = append(q.heads, txs)
+		}
+	}
+	heap.Init(q)
+
+	res := make([]*Tx, 0)
+	var gas uint64
+	size := 0
+	for q.Len() > 0 && (budget.MaxTxs == 0 || len(res) < budget.MaxTxs) {
+		txs := heap.Pop(q).([]*Tx)
+		tx := txs[0]
+		if (budget.MaxGas > 0 && gas+tx.GasLimit > budget.MaxGas) || (budget.MaxBytes > 0 && size+tx.Size > budget.MaxBytes) {
+			continue
+		}
+		res = append(res, tx)
+		gas += tx.GasLimit
+		size += tx.Size
+		if len(txs) > 1 {
+			heap.Push(q, txs[1:])
+		}
+	}
+	return res
+}
@@ -0,0 +1,75 @@
+package mempool
+
+import (
+	"github.com/spacemeshos/go-spacemesh/address"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/mempool/config"
+	"github.com/spacemeshos/go-spacemesh/mesh"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func (s sender) pricedTx(t *testing.T, nonce uint64, price int64, gas uint64) *mesh.SerializableTransaction {
+	tx := mesh.NewSerializableTransaction(nonce, address.BytesToAddress([]byte{0x01}), big.NewInt(1), big.NewInt(price), gas)
+	assert.NoError(t, tx.Sign(s.key))
+	return tx
+}
+
+func prices(txs []mesh.SerializableTransaction) []int64 {
+	res := make([]int64, 0, len(txs))
+	for _, tx := range txs {
+		res = append(res, new(big.Int).SetBytes(tx.Price).Int64())
+	}
+	return res
+}
+
+func TestFeePolicy_Select(t *testing.T) {
+	st := stateMock{}
+	pool := NewTxPool(config.DefaultConfig(), st, log.New("mempool", "", ""))
+	alice := newSender(t, st, 1000, 0)
+	bob := newSender(t, st, 1000, 0)
+
+	assert.NoError(t, pool.Add(alice.pricedTx(t, 0, 1, 1)))
+	assert.NoError(t, pool.Add(alice.pricedTx(t, 1, 5, 1)))
+	assert.NoError(t, pool.Add(bob.pricedTx(t, 0, 3, 1)))
+	assert.NoError(t, pool.Add(bob.pricedTx(t, 1, 2, 1)))
+
+	//the transaction of alice paying 5 has to wait for her transaction paying 1
+	assert.Equal(t, []int64{3, 2, 1, 5}, prices(pool.Select(Budget{})))
+	assert.Equal(t, []int64{3, 2}, prices(pool.Select(Budget{MaxTxs: 2})))
+}
+
+func TestFeePolicy_SelectBudget(t *testing.T) {
+	st := stateMock{}
+	pool := NewTxPool(config.DefaultConfig(), st, log.New("mempool", "", ""))
+	alice := newSender(t, st, 1000, 0)
+	bob := newSender(t, st, 1000, 0)
+
+	assert.NoError(t, pool.Add(alice.pricedTx(t, 0, 5, 10)))
+	assert.NoError(t, pool.Add(alice.pricedTx(t, 1, 4, 1)))
+	assert.NoError(t, pool.Add(bob.pricedTx(t, 0, 2, 2)))
+	assert.NoError(t, pool.Add(bob.pricedTx(t, 1, 1, 2)))
+
+	//the first transaction of alice does not fit so her second one cannot be applied either
+	assert.Equal(t, []int64{2, 1}, prices(pool.Select(Budget{MaxGas: 5})))
+	assert.Equal(t, []int64{5, 4}, prices(pool.Select(Budget{MaxGas: 11})))
+
+	size := pool.Transactions()[0].Size
+	assert.Equal(t, []int64{5, 4}, prices(pool.Select(Budget{MaxBytes: 2*size + 1})))
+}
+
+func TestTxPool_SetPolicy(t *testing.T) {
+	st := stateMock{}
+	pool := NewTxPool(config.DefaultConfig(), st, log.New("mempool", "", ""))
+	alice := newSender(t, st, 1000, 0)
+	bob := newSender(t, st, 1000, 0)
+
+	assert.NoError(t, pool.Add(alice.pricedTx(t, 0, 1, 1)))
+	assert.NoError(t, pool.Add(bob.pricedTx(t, 0, 3, 1)))
+	assert.NoError(t, pool.Add(alice.pricedTx(t, 1, 2, 1)))
+	assert.Equal(t, []int64{3, 1, 2}, prices(pool.Select(Budget{})))
+
+	pool.SetPolicy(ArrivalPolicy{})
+	assert.Equal(t, []int64{1, 3, 2}, prices(pool.Select(Budget{})))
+}
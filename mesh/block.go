@@ -4,20 +4,38 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/davecgh/go-xdr/xdr2"
-	"github.com/google/uuid"
 	"github.com/spacemeshos/go-spacemesh/address"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/state"
 	"io"
 	"math/big"
+	"sort"
 	"time"
 )
 
-type BlockID uint32
+type BlockID uint64
 type LayerID uint32
 
 type Block struct {
 	Id         BlockID
 	LayerIndex LayerID
 	MinerID    string
+	Coinbase   address.Address //the account the rewards of the block are paid to
+	Data       []byte
+	Coin       bool
+	Timestamp  int64
+	Txs        []SerializableTransaction
+	BlockVotes []BlockID
+	ViewEdges  []BlockID
+	Sig        []byte //signature of the miner over the content hash
+}
+
+// blockContent holds the fields of a block that its id is derived from
+type blockContent struct {
+	LayerIndex LayerID
+	MinerID    string
+	Coinbase   address.Address
 	Data       []byte
 	Coin       bool
 	Timestamp  int64
@@ -31,14 +49,13 @@ type SerializableTransaction struct {
 	Price        []byte
 	GasLimit     uint64
 	Recipient    *address.Address
-	Origin       address.Address //todo: remove this, should be calculated from sig.
 	Amount       []byte
 	Payload      []byte
+	Signature    []byte //the origin of the transaction is recovered from the signature
 }
 
 func NewBlock(coin bool, data []byte, ts time.Time, layerId LayerID) *Block {
 	b := Block{
-		Id:         BlockID(uuid.New().ID()),
 		LayerIndex: layerId,
 		BlockVotes: make([]BlockID, 0, 10),
 		ViewEdges:  make([]BlockID, 0, 10),
@@ -46,21 +63,45 @@ func NewBlock(coin bool, data []byte, ts time.Time, layerId LayerID) *Block {
 		Data:       data,
 		Coin:       coin,
 	}
+	b.Id = b.CalcId()
 	return &b
 }
 
-func NewSerializableTransaction(nonce uint64, origin, recepient address.Address, amount, price *big.Int, gasLimit uint64) *SerializableTransaction {
+// NewSerializableTransaction creates an unsigned transaction, it has to be signed with Sign by the sending account
+func NewSerializableTransaction(nonce uint64, recepient address.Address, amount, price *big.Int, gasLimit uint64) *SerializableTransaction {
 	return &SerializableTransaction{
 		AccountNonce: nonce,
 		Price:        price.Bytes(),
 		GasLimit:     gasLimit,
 		Recipient:    &recepient,
-		Origin:       origin, //todo: remove this, should be calculated from sig.
 		Amount:       amount.Bytes(),
 		Payload:      nil,
 	}
 }
 
+// Sign signs the transaction body with the key of the sending account
+func (t *SerializableTransaction) Sign(key crypto.PrivateKey) error {
+	tx := t.unsigned()
+	if err := tx.Sign(key); err != nil {
+		return err
+	}
+	t.Signature = tx.Signature
+	return nil
+}
+
+// Origin recovers the address of the sending account from the signature
+func (t *SerializableTransaction) Origin() (address.Address, error) {
+	tx := t.unsigned()
+	tx.Signature = t.Signature
+	return tx.Sender()
+}
+
+func (t *SerializableTransaction) unsigned() *state.Transaction {
+	tx := state.NewTransaction(t.AccountNonce, *t.Recipient, new(big.Int).SetBytes(t.Amount), t.GasLimit, new(big.Int).SetBytes(t.Price))
+	tx.Payload = t.Payload
+	return tx
+}
+
 func (b Block) ID() BlockID {
 	return b.Id
 }
@@ -69,6 +110,58 @@ func (b Block) Layer() LayerID {
 	return b.LayerIndex
 }
 
+// ContentBytes returns the canonical encoding of the block contents, the block id is not part of it
+func (b Block) ContentBytes() ([]byte, error) {
+	c := blockContent{
+		LayerIndex: b.LayerIndex,
+		MinerID:    b.MinerID,
+		Coinbase:   b.Coinbase,
+		Data:       b.Data,
+		Coin:       b.Coin,
+		Timestamp:  b.Timestamp,
+		Txs:        b.Txs,
+		BlockVotes: b.BlockVotes,
+		ViewEdges:  b.ViewEdges,
+	}
+	var w bytes.Buffer
+	if _, err := xdr.Marshal(&w, &c); err != nil {
+		return nil, fmt.Errorf("error marshalling block content %v", err)
+	}
+	return w.Bytes(), nil
+}
+
+// ContentHash returns the hash of the block contents, the block id and signature are derived from it
+func (b Block) ContentHash() []byte {
+	c, err := b.ContentBytes()
+	if err != nil {
+		//the content consists of fixed size and slice fields only so this can not happen
+		panic(err)
+	}
+	return crypto.Sha256(c)
+}
+
+// CalcId derives the block id from the hash of the block contents
+func (b Block) CalcId() BlockID {
+	return BlockID(common.BytesToUint64(b.ContentHash()))
+}
+
+// ValidId reports whether the block id matches the block contents
+func (b Block) ValidId() bool {
+	return b.Id == b.CalcId()
+}
+
+// ValidSignature reports whether the block was signed by the key of the miner it names
+func (b Block) ValidSignature() bool {
+	pub, err := crypto.NewPublicKeyFromString(b.MinerID)
+	if err != nil {
+		return false
+	}
+	valid, err := pub.Verify(b.ContentHash(), b.Sig)
+	return err == nil && valid
+}
+
+// AddVote, AddView and AddTransaction change the block content without updating the id, the id is derived once
+// the block is complete with CalcId
 func (b *Block) AddVote(id BlockID) {
 	//todo: do this in a sorted manner
 	b.BlockVotes = append(b.BlockVotes, id)
@@ -96,8 +189,22 @@ func (l *Layer) Blocks() []*Block {
 	return l.blocks
 }
 
+// Hash returns a hash over the sorted ids of the layer blocks, layers holding the same blocks have the same hash
 func (l *Layer) Hash() []byte {
-	return []byte("some hash representing the layer")
+	ids := make([]BlockID, 0, len(l.blocks))
+	for _, b := range l.blocks {
+		ids = append(ids, b.ID())
+	}
+	return calcLayerHash(ids)
+}
+
+func calcLayerHash(ids []BlockID) []byte {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	buf := make([]byte, 0, len(ids)*8)
+	for _, id := range ids {
+		buf = append(buf, id.ToBytes()...)
+	}
+	return crypto.Sha256(buf)
 }
 
 func (l *Layer) AddBlock(block *Block) {
@@ -0,0 +1,125 @@
+package mesh
+
+import (
+	"bytes"
+	"github.com/spacemeshos/go-spacemesh/address"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestBlock_IdDerivedFromContent(t *testing.T) {
+	ts := time.Now()
+	block1 := NewBlock(true, []byte("data"), ts, 1)
+	block2 := NewBlock(true, []byte("data"), ts, 1)
+	assert.Equal(t, block1.ID(), block2.ID(), "blocks with equal content should have equal ids")
+	assert.True(t, block1.ValidId())
+
+	block3 := NewBlock(true, []byte("other data"), ts, 1)
+	assert.NotEqual(t, block1.ID(), block3.ID())
+
+	//the id is derived once the block is complete, not on every change of its content
+	id := block1.ID()
+	block1.AddVote(block3.ID())
+	assert.Equal(t, id, block1.ID())
+	assert.False(t, block1.ValidId(), "the votes should be part of the block content")
+	block1.Id = block1.CalcId()
+	assert.NotEqual(t, id, block1.ID(), "adding a vote should change the block id")
+	assert.True(t, block1.ValidId())
+
+	id = block1.ID()
+	block1.AddView(block3.ID())
+	assert.False(t, block1.ValidId(), "the view edges should be part of the block content")
+	block1.Id = block1.CalcId()
+	assert.NotEqual(t, id, block1.ID(), "adding a view edge should change the block id")
+
+	id = block1.ID()
+	block1.AddTransaction(NewSerializableTransaction(0, address.BytesToAddress([]byte{0x02}), big.NewInt(10), big.NewInt(1), 10))
+	assert.False(t, block1.ValidId(), "the transactions should be part of the block content")
+	block1.Id = block1.CalcId()
+	assert.NotEqual(t, id, block1.ID(), "adding a transaction should change the block id")
+	assert.True(t, block1.ValidId())
+
+	block1.Coinbase = address.BytesToAddress([]byte{0x05})
+	assert.False(t, block1.ValidId(), "the coinbase should be part of the block content")
+	block1.Id = block1.CalcId()
+
+	block1.Data = []byte("tampered data")
+	assert.False(t, block1.ValidId())
+}
+
+func TestBlock_IdSurvivesSerialization(t *testing.T) {
+	block := NewBlock(false, []byte("data"), time.Now(), 5)
+	block.AddVote(1)
+	block.AddView(2)
+	block.Id = block.CalcId()
+
+	b, err := BlockAsBytes(*block)
+	assert.NoError(t, err)
+	res, err := BytesAsBlock(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, block.ID(), res.ID())
+	assert.True(t, res.ValidId())
+}
+
+func TestBlock_ValidSignature(t *testing.T) {
+	priv, pub, err := crypto.GenerateKeyPair()
+	assert.NoError(t, err)
+
+	block := NewBlock(false, []byte("data"), time.Now(), 5)
+	block.MinerID = pub.String()
+	block.Id = block.CalcId()
+	assert.False(t, block.ValidSignature(), "unsigned block")
+
+	block.Sig, err = priv.Sign(block.ContentHash())
+	assert.NoError(t, err)
+	assert.True(t, block.ValidSignature())
+
+	b, err := BlockAsBytes(*block)
+	assert.NoError(t, err)
+	res, err := BytesAsBlock(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.True(t, res.ValidSignature(), "signature should survive serialization")
+
+	_, other, err := crypto.GenerateKeyPair()
+	assert.NoError(t, err)
+	res.MinerID = other.String()
+	assert.False(t, res.ValidSignature(), "signature of another miner")
+
+	block.Data = []byte("tampered data")
+	assert.False(t, block.ValidSignature(), "tampered content")
+
+	block.MinerID = "not a key"
+	assert.False(t, block.ValidSignature())
+}
+
+func TestSerializableTransaction_Origin(t *testing.T) {
+	priv, pub, err := crypto.GenerateKeyPair()
+	assert.NoError(t, err)
+	tx := NewSerializableTransaction(3, address.BytesToAddress([]byte{0x02}), big.NewInt(10), big.NewInt(1), 10)
+	tx.Payload = []byte("payload")
+
+	_, err = tx.Origin()
+	assert.Error(t, err, "unsigned transaction has no origin")
+
+	assert.NoError(t, tx.Sign(priv))
+	b, err := TransactionAsBytes(tx)
+	assert.NoError(t, err)
+	decoded, err := BytesAsTransaction(bytes.NewReader(b))
+	assert.NoError(t, err)
+	origin, err := decoded.Origin()
+	assert.NoError(t, err)
+	assert.Equal(t, address.PublicKeyToAddress(pub.Bytes()), origin)
+
+	st, err := SerializableTransaction2StateTransaction(decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, origin, st.Origin)
+
+	decoded.Amount = big.NewInt(100).Bytes()
+	origin, err = decoded.Origin()
+	if err == nil {
+		assert.NotEqual(t, address.PublicKeyToAddress(pub.Bytes()), origin, "changing the body should change the recovered origin")
+	}
+}
@@ -0,0 +1,176 @@
+package mesh
+
+import (
+	"fmt"
+	"github.com/spacemeshos/go-spacemesh/database"
+)
+
+// kinds of problems found by Check
+const (
+	IssueCorruptedLayer = "corrupted_layer"    // the block id list of the layer can not be decoded
+	IssueMissingBlock   = "missing_block"      // the layer lists a block that is not in the blocks database
+	IssueCorruptedBlock = "corrupted_block"    // the stored block can not be decoded
+	IssueWrongLayer     = "wrong_layer"        // the block is listed in a layer other than its LayerIndex
+	IssueInvalidId      = "invalid_id"         // the block id does not match its content
+	IssueDanglingView   = "dangling_view_edge" // a view edge references an unknown block
+	IssueDanglingVote   = "dangling_vote"      // a vote references an unknown block
+	IssueDanglingOrphan = "dangling_orphan"    // the orphan set references an unknown block
+	IssueVerifiedLayer  = "verified_layer"     // the verified layer pointer is ahead of the latest known layer
+)
+
+type CheckIssue struct {
+	Kind     string  `json:"kind"`
+	Layer    LayerID `json:"layer"`
+	Block    BlockID `json:"block,omitempty"`
+	Ref      BlockID `json:"ref,omitempty"`
+	Detail   string  `json:"detail,omitempty"`
+	Repaired bool    `json:"repaired"`
+}
+
+type CheckReport struct {
+	VerifiedLayer uint32       `json:"verifiedLayer"`
+	LatestLayer   uint32       `json:"latestLayer"`
+	LastSeenLayer uint32       `json:"lastSeenLayer"`
+	PrunedBefore  uint32       `json:"prunedBefore"`
+	Layers        int          `json:"layers"`
+	Blocks        int          `json:"blocks"`
+	Issues        []CheckIssue `json:"issues"`
+}
+
+// Unrepaired returns the number of issues that are still present in the database
+func (r *CheckReport) Unrepaired() int {
+	n := 0
+	for _, i := range r.Issues {
+		if !i.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// Check walks the mesh databases of a node that is not running and reports inconsistencies.
+// with repair set, layer entries and orphans that reference missing or misplaced blocks are dropped and
+// the verified layer pointer is moved back. view edges and votes are only reported, they are part of
+// the block content and can not be changed without changing the block id
+func Check(layers, blocks, validity database.DB, repair bool) *CheckReport {
+	m := NewMeshDB(layers, blocks, validity)
+	r := &CheckReport{Issues: make([]CheckIssue, 0)}
+	r.VerifiedLayer, _ = m.getLayerPointer(verifiedLayerKey)
+	r.LatestLayer, _ = m.getLayerPointer(latestLayerKey)
+	r.LastSeenLayer, _ = m.getLayerPointer(lastSeenLayerKey)
+	r.PrunedBefore, _ = m.getLayerPointer(pruneFromKey)
+
+	top := r.LatestLayer
+	if r.LastSeenLayer > top {
+		top = r.LastSeenLayer
+	}
+	if r.VerifiedLayer > top {
+		top = r.VerifiedLayer
+	}
+
+	known := make(map[BlockID]struct{})
+	stored := make([]*Block, 0)
+	for l := LayerID(0); l <= LayerID(top); l++ {
+		b, err := m.layers.Get(l.ToBytes())
+		if err != nil {
+			continue //no blocks were received for the layer
+		}
+		r.Layers++
+		ids, err := bytesToBlockIds(b)
+		if err != nil {
+			r.Issues = append(r.Issues, CheckIssue{Kind: IssueCorruptedLayer, Layer: l, Detail: err.Error()})
+			continue
+		}
+
+		first := len(r.Issues)
+		drop := make([]BlockID, 0)
+		for id := range ids {
+			if l < LayerID(r.PrunedBefore) {
+				known[id] = struct{}{} //the bodies of pruned layers were deleted on purpose
+				continue
+			}
+			blk, err := m.getBlock(id)
+			if err != nil {
+				kind := IssueMissingBlock
+				if _, e := m.blocks.Get(id.ToBytes()); e == nil {
+					kind = IssueCorruptedBlock
+				}
+				r.Issues = append(r.Issues, CheckIssue{Kind: kind, Layer: l, Block: id, Repaired: repair})
+				drop = append(drop, id)
+				continue
+			}
+			known[id] = struct{}{}
+			r.Blocks++
+			if blk.LayerIndex != l {
+				r.Issues = append(r.Issues, CheckIssue{Kind: IssueWrongLayer, Layer: l, Block: id,
+					Detail: fmt.Sprintf("block belongs to layer %v", blk.LayerIndex), Repaired: repair})
+				drop = append(drop, id)
+				continue
+			}
+			if !blk.ValidId() {
+				r.Issues = append(r.Issues, CheckIssue{Kind: IssueInvalidId, Layer: l, Block: id})
+			}
+			stored = append(stored, blk)
+		}
+
+		if repair && len(drop) > 0 {
+			for _, id := range drop {
+				delete(ids, id)
+			}
+			r.repairFailed(first, m.writeLayerIds(l, ids))
+		}
+	}
+
+	for _, blk := range stored {
+		for _, ref := range blk.ViewEdges {
+			if _, ok := known[ref]; !ok {
+				r.Issues = append(r.Issues, CheckIssue{Kind: IssueDanglingView, Layer: blk.LayerIndex, Block: blk.ID(), Ref: ref})
+			}
+		}
+		for _, ref := range blk.BlockVotes {
+			if _, ok := known[ref]; !ok {
+				r.Issues = append(r.Issues, CheckIssue{Kind: IssueDanglingVote, Layer: blk.LayerIndex, Block: blk.ID(), Ref: ref})
+			}
+		}
+	}
+
+	if err := m.loadOrphans(); err != nil {
+		r.Issues = append(r.Issues, CheckIssue{Kind: IssueDanglingOrphan, Detail: err.Error()})
+	} else {
+		first := len(r.Issues)
+		changed := make(map[LayerID]struct{})
+		for id, l := range m.orphans.blocks {
+			if _, ok := known[id]; !ok {
+				r.Issues = append(r.Issues, CheckIssue{Kind: IssueDanglingOrphan, Layer: l, Block: id, Repaired: repair})
+				m.orphans.remove(id)
+				changed[l] = struct{}{}
+			}
+		}
+		if repair && len(changed) > 0 {
+			r.repairFailed(first, m.writeOrphanLayers(changed, false))
+		}
+	}
+
+	if r.VerifiedLayer > r.LatestLayer {
+		r.Issues = append(r.Issues, CheckIssue{Kind: IssueVerifiedLayer, Layer: LayerID(r.VerifiedLayer),
+			Detail: fmt.Sprintf("latest layer is %v", r.LatestLayer), Repaired: repair})
+		if repair {
+			r.repairFailed(len(r.Issues)-1, m.setLayerPointer(verifiedLayerKey, r.LatestLayer))
+		}
+	}
+	return r
+}
+
+// repairFailed marks the repaired issues starting at index first as not repaired when err is set
+func (r *CheckReport) repairFailed(first int, err error) {
+	if err == nil {
+		return
+	}
+	for i := first; i < len(r.Issues); i++ {
+		if !r.Issues[i].Repaired {
+			continue
+		}
+		r.Issues[i].Repaired = false
+		r.Issues[i].Detail = "repair failed " + err.Error()
+	}
+}
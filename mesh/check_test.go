@@ -0,0 +1,80 @@
+package mesh
+
+import (
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/mesh/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func issueKinds(r *CheckReport) []string {
+	kinds := make([]string, 0, len(r.Issues))
+	for _, i := range r.Issues {
+		kinds = append(kinds, i.Kind)
+	}
+	return kinds
+}
+
+func TestCheck_Consistent(t *testing.T) {
+	ldb, bdb, cdb := database.NewMemDatabase(), database.NewMemDatabase(), database.NewMemDatabase()
+	layers := NewMesh(config.DefaultConfig(), ldb, bdb, cdb, &MeshValidatorMock{}, &MockState{}, log.New("t17", "", ""))
+	block1 := NewBlock(true, []byte("data1"), time.Now(), 0)
+	block2 := NewBlock(true, []byte("data2"), time.Now(), 1)
+	block2.AddView(block1.ID())
+	block2.AddVote(block1.ID())
+	block2.Id = block2.CalcId()
+	assert.NoError(t, layers.AddBlock(block1))
+	assert.NoError(t, layers.AddBlock(block2))
+	time.Sleep(100 * time.Millisecond)
+
+	r := Check(ldb, bdb, cdb, false)
+	assert.Empty(t, r.Issues)
+	assert.Equal(t, 2, r.Layers)
+	assert.Equal(t, 2, r.Blocks)
+}
+
+func TestCheck_Repair(t *testing.T) {
+	ldb, bdb, cdb := database.NewMemDatabase(), database.NewMemDatabase(), database.NewMemDatabase()
+	layers := NewMesh(config.DefaultConfig(), ldb, bdb, cdb, &MeshValidatorMock{}, &MockState{}, log.New("t18", "", ""))
+	block1 := NewBlock(true, []byte("data1"), time.Now(), 1)
+	block2 := NewBlock(true, []byte("data2"), time.Now(), 1)
+	block3 := NewBlock(true, []byte("data3"), time.Now(), 2)
+	block3.AddView(block2.ID())
+	block3.AddVote(BlockID(123))
+	block3.Id = block3.CalcId()
+	assert.NoError(t, layers.AddBlock(block1))
+	assert.NoError(t, layers.AddBlock(block2))
+	assert.NoError(t, layers.AddBlock(block3))
+	time.Sleep(100 * time.Millisecond)
+
+	//corrupt the database
+	assert.NoError(t, bdb.Delete(block2.ID().ToBytes()))
+	assert.NoError(t, layers.writeLayerIds(3, map[BlockID]bool{block1.ID(): true}))
+	assert.NoError(t, layers.setLayerPointer(verifiedLayerKey, 7))
+	assert.NoError(t, layers.addOrphan(NewExistingBlock(BlockID(999), 2, nil)))
+
+	r := Check(ldb, bdb, cdb, false)
+	assert.ElementsMatch(t, []string{IssueMissingBlock, IssueWrongLayer, IssueDanglingView, IssueDanglingVote, IssueDanglingOrphan, IssueVerifiedLayer}, issueKinds(r),
+		"the view edge to the missing block should be reported before the block is dropped")
+	assert.Equal(t, 6, r.Unrepaired())
+
+	r = Check(ldb, bdb, cdb, true)
+	assert.Equal(t, 2, r.Unrepaired(), "dangling view edges and votes can not be repaired")
+
+	r = Check(ldb, bdb, cdb, false)
+	assert.ElementsMatch(t, []string{IssueDanglingView, IssueDanglingVote}, issueKinds(r))
+	ids, err := layers.GetLayerBlockIDs(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []BlockID{block1.ID()}, ids)
+	hash, err := layers.GetLayerHash(1)
+	assert.NoError(t, err)
+	assert.Equal(t, NewExistingLayer(1, []*Block{block1}).Hash(), hash)
+	restarted := NewMeshDB(ldb, bdb, cdb)
+	assert.NoError(t, restarted.loadOrphans())
+	assert.NotContains(t, restarted.orphans.all(), BlockID(999))
+	verified, err := layers.getLayerPointer(verifiedLayerKey)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), verified)
+}
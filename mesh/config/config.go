@@ -0,0 +1,19 @@
+package config
+
+import "time"
+
+// Config holds the retention policy of the mesh, verified layers that fall outside of it
+// have their block bodies deleted while their block ids and hash are kept
+type Config struct {
+	RetainLayers uint32        `mapstructure:"retain-layers"` // number of verified layers to keep, 0 keeps all
+	RetainAge    time.Duration `mapstructure:"retain-age"`    // max age of the blocks of a layer, 0 keeps all
+	ReorgWindow  uint32        `mapstructure:"reorg-window"`  // number of verified layers kept regardless of the policy, the tortoise may still revise them
+}
+
+func DefaultConfig() Config {
+	return Config{
+		RetainLayers: 0,
+		RetainAge:    0,
+		ReorgWindow:  100, //the voting window of the tortoise
+	}
+}
@@ -7,9 +7,28 @@ import (
 	"github.com/spacemeshos/go-spacemesh/common"
 )
 
-func (b BlockID) ToBytes() []byte { return common.Uint32ToBytes(uint32(b)) }
+func (b BlockID) ToBytes() []byte { return common.Uint64ToBytes(uint64(b)) }
 func (l LayerID) ToBytes() []byte { return common.Uint32ToBytes(uint32(l)) }
 
+// layerHashKey is the layers database key under which the hash of a layer is stored
+func layerHashKey(l LayerID) []byte { return append([]byte("h"), l.ToBytes()...) }
+
+// orphanLayerKey is the layers database key under which the orphan blocks of a layer are stored
+func orphanLayerKey(l LayerID) []byte { return append([]byte("o"), l.ToBytes()...) }
+
+// prunedLayerKey is the layers database key under which the votes of the blocks of a pruned layer are stored
+func prunedLayerKey(l LayerID) []byte { return append([]byte("p"), l.ToBytes()...) }
+
+// minerBlockKey is the layers database key under which the id of the first block of a miner in a layer is stored
+func minerBlockKey(k minerLayer) []byte {
+	return append(append([]byte("m"), k.Layer.ToBytes()...), k.MinerID...)
+}
+
+// evidenceKey is the layers database key under which the evidence against a miner in a layer is stored
+func evidenceKey(k minerLayer) []byte {
+	return append(append([]byte("e"), k.Layer.ToBytes()...), k.MinerID...)
+}
+
 func blockIdsAsBytes(ids map[BlockID]bool) ([]byte, error) {
 	var w bytes.Buffer
 	if _, err := xdr.Marshal(&w, &ids); err != nil {
@@ -25,3 +44,51 @@ func bytesToBlockIds(blockIds []byte) (map[BlockID]bool, error) {
 	}
 	return ids, nil
 }
+
+func blockIdListAsBytes(ids []BlockID) ([]byte, error) {
+	var w bytes.Buffer
+	if _, err := xdr.Marshal(&w, &ids); err != nil {
+		return nil, errors.New("error marshalling block ids")
+	}
+	return w.Bytes(), nil
+}
+
+func bytesToBlockIdList(b []byte) ([]BlockID, error) {
+	var ids []BlockID
+	if _, err := xdr.Unmarshal(bytes.NewReader(b), &ids); err != nil {
+		return nil, errors.New("error unmarshalling block ids")
+	}
+	return ids, nil
+}
+
+func layerIdListAsBytes(ids []LayerID) ([]byte, error) {
+	var w bytes.Buffer
+	if _, err := xdr.Marshal(&w, &ids); err != nil {
+		return nil, errors.New("error marshalling layer ids")
+	}
+	return w.Bytes(), nil
+}
+
+func bytesToLayerIdList(b []byte) ([]LayerID, error) {
+	var ids []LayerID
+	if _, err := xdr.Unmarshal(bytes.NewReader(b), &ids); err != nil {
+		return nil, errors.New("error unmarshalling layer ids")
+	}
+	return ids, nil
+}
+
+func evidenceListAsBytes(keys []minerLayer) ([]byte, error) {
+	var w bytes.Buffer
+	if _, err := xdr.Marshal(&w, &keys); err != nil {
+		return nil, errors.New("error marshalling evidence list")
+	}
+	return w.Bytes(), nil
+}
+
+func bytesToEvidenceList(b []byte) ([]minerLayer, error) {
+	var keys []minerLayer
+	if _, err := xdr.Unmarshal(bytes.NewReader(b), &keys); err != nil {
+		return nil, errors.New("error unmarshalling evidence list")
+	}
+	return keys, nil
+}
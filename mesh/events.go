@@ -0,0 +1,126 @@
+package mesh
+
+import (
+	"github.com/spacemeshos/go-spacemesh/log"
+	"sync"
+	"sync/atomic"
+)
+
+type EventType uint8
+
+const (
+	BlockAdded    EventType = iota // a block was added to the mesh
+	LayerReceived                  // a layer was added to the mesh
+	LayerVerified                  // the tortoise finished handling the layer
+	LayerApplied                   // the transactions of the layer were applied to the state
+	Reorg                          // the state was reverted and the layers were applied again after their validity changed
+	Equivocation                   // a block revealed that its miner built another block in the layer
+)
+
+func (t EventType) String() string {
+	switch t {
+	case BlockAdded:
+		return "BlockAdded"
+	case LayerReceived:
+		return "LayerReceived"
+	case LayerVerified:
+		return "LayerVerified"
+	case LayerApplied:
+		return "LayerApplied"
+	case Reorg:
+		return "Reorg"
+	case Equivocation:
+		return "Equivocation"
+	}
+	return "Unknown"
+}
+
+// Event describes a change in the mesh, Block is only set for BlockAdded and Equivocation events.
+// a Reorg event holds the first reverted layer in Layer and all the layers that were applied again in Layers
+type Event struct {
+	Type   EventType
+	Layer  LayerID
+	Block  BlockID
+	Layers []LayerID
+}
+
+type EventChannel chan Event
+
+type subscriber struct {
+	types   map[EventType]struct{} // empty means all types
+	dropped uint64
+}
+
+func (s *subscriber) wants(t EventType) bool {
+	if len(s.types) == 0 {
+		return true
+	}
+	_, ok := s.types[t]
+	return ok
+}
+
+// eventBus delivers mesh events to its subscribers. publishing never blocks the mesh,
+// an event that does not fit in the buffer of a subscriber is dropped for that subscriber and counted
+type eventBus struct {
+	log.Log
+	mu   sync.RWMutex
+	subs map[EventChannel]*subscriber
+}
+
+func newEventBus(logger log.Log) *eventBus {
+	return &eventBus{
+		Log:  logger,
+		subs: make(map[EventChannel]*subscriber),
+	}
+}
+
+// Subscribe returns a channel buffered with bufferSize that receives the events of the given types,
+// all events are received when no type is given
+func (eb *eventBus) Subscribe(bufferSize int, types ...EventType) EventChannel {
+	s := &subscriber{types: make(map[EventType]struct{}, len(types))}
+	for _, t := range types {
+		s.types[t] = struct{}{}
+	}
+	ch := make(EventChannel, bufferSize)
+	eb.mu.Lock()
+	eb.subs[ch] = s
+	eb.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe stops the delivery of events to the channel and closes it
+func (eb *eventBus) Unsubscribe(ch EventChannel) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	if _, ok := eb.subs[ch]; !ok {
+		return
+	}
+	delete(eb.subs, ch)
+	close(ch)
+}
+
+// DroppedEvents returns the number of events the subscriber missed because its buffer was full
+func (eb *eventBus) DroppedEvents(ch EventChannel) uint64 {
+	eb.mu.RLock()
+	defer eb.mu.RUnlock()
+	if s, ok := eb.subs[ch]; ok {
+		return atomic.LoadUint64(&s.dropped)
+	}
+	return 0
+}
+
+func (eb *eventBus) publish(ev Event) {
+	eb.mu.RLock()
+	defer eb.mu.RUnlock()
+	for ch, s := range eb.subs {
+		if !s.wants(ev.Type) {
+			continue
+		}
+		select {
+		case ch <- ev:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+			eb.Warning("subscriber is too slow, dropped %v event of layer %v", ev.Type, ev.Layer)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package mesh
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func readEvent(t *testing.T, ch EventChannel) Event {
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(time.Second):
+		assert.Fail(t, "timed out waiting for event")
+	}
+	return Event{}
+}
+
+func TestMesh_Subscribe(t *testing.T) {
+	layers := getMesh("t1")
+	defer layers.Close()
+
+	all := layers.Subscribe(10)
+	blocks := layers.Subscribe(10, BlockAdded)
+
+	block := NewBlock(true, []byte("data1"), time.Now(), 1)
+	assert.NoError(t, layers.AddBlock(block))
+	assert.Equal(t, Event{Type: BlockAdded, Layer: 1, Block: block.ID()}, readEvent(t, all))
+	assert.Equal(t, Event{Type: BlockAdded, Layer: 1, Block: block.ID()}, readEvent(t, blocks))
+
+	synced := NewBlock(true, []byte("data2"), time.Now(), 0)
+	assert.NoError(t, layers.AddLayer(NewExistingLayer(0, []*Block{synced})))
+	assert.Equal(t, Event{Type: BlockAdded, Layer: 0, Block: synced.ID()}, readEvent(t, all))
+	assert.Equal(t, Event{Type: BlockAdded, Layer: 0, Block: synced.ID()}, readEvent(t, blocks), "blocks of synced layers should be published")
+	assert.Equal(t, Event{Type: LayerReceived, Layer: 0}, readEvent(t, all))
+
+	layers.LayerCompleteCallback(0)
+	assert.Equal(t, Event{Type: LayerVerified, Layer: 0}, readEvent(t, all))
+	assert.Equal(t, Event{Type: LayerApplied, Layer: 0}, readEvent(t, all))
+
+	assert.Equal(t, 0, len(blocks), "subscriber should only receive the requested types")
+}
+
+func TestMesh_SlowSubscriber(t *testing.T) {
+	layers := getMesh("t2")
+	defer layers.Close()
+
+	slow := layers.Subscribe(1)
+	fast := layers.Subscribe(5)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, layers.AddBlock(NewBlock(true, []byte{byte(i)}, time.Now(), 1)))
+	}
+
+	assert.Equal(t, uint64(2), layers.DroppedEvents(slow))
+	assert.Equal(t, uint64(0), layers.DroppedEvents(fast))
+	assert.Equal(t, 1, len(slow))
+	assert.Equal(t, 3, len(fast))
+}
+
+func TestMesh_Unsubscribe(t *testing.T) {
+	layers := getMesh("t3")
+	defer layers.Close()
+
+	ch := layers.Subscribe(1)
+	layers.Unsubscribe(ch)
+	layers.Unsubscribe(ch)
+	_, ok := <-ch
+	assert.False(t, ok, "channel should be closed")
+
+	assert.NoError(t, layers.AddBlock(NewBlock(true, []byte("data"), time.Now(), 1)))
+}
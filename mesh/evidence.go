@@ -0,0 +1,245 @@
+package mesh
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/davecgh/go-xdr/xdr2"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Evidence proves that a miner built more than one block in a layer, both blocks are signed by the miner
+type Evidence struct {
+	Layer   LayerID
+	MinerID string
+	First   Block
+	Second  Block
+}
+
+// Validate checks that the blocks of the evidence are different blocks of the miner in the layer
+func (e *Evidence) Validate() error {
+	if e.First.ID() == e.Second.ID() {
+		return fmt.Errorf("evidence holds block %v twice", e.First.ID())
+	}
+	for _, b := range []Block{e.First, e.Second} {
+		if b.Layer() != e.Layer || b.MinerID != e.MinerID {
+			return fmt.Errorf("block %v was not built in layer %v by %v", b.ID(), e.Layer, e.MinerID)
+		}
+		if !b.ValidId() || !b.ValidSignature() {
+			return fmt.Errorf("block %v is not signed by %v", b.ID(), e.MinerID)
+		}
+	}
+	return nil
+}
+
+func EvidenceAsBytes(e *Evidence) ([]byte, error) {
+	var w bytes.Buffer
+	if _, err := xdr.Marshal(&w, e); err != nil {
+		return nil, fmt.Errorf("error marshalling evidence %v", err)
+	}
+	return w.Bytes(), nil
+}
+
+func BytesAsEvidence(buf io.Reader) (*Evidence, error) {
+	e := &Evidence{}
+	if _, err := xdr.Unmarshal(buf, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// minerLayer identifies the evidence of a miner in a layer, a miner has at most one evidence per layer
+type minerLayer struct {
+	Layer   LayerID
+	MinerID string
+}
+
+// equivocations tracks the miners that built more than one block in a layer. their blocks are excluded from
+// the orphans so they are neither viewed by new blocks nor given as input to the hare
+type equivocations struct {
+	sync.RWMutex
+	evidence []minerLayer //in the order it was recorded in
+	recorded map[minerLayer]struct{}
+	excluded map[BlockID]minerLayer
+}
+
+func newEquivocations() *equivocations {
+	return &equivocations{
+		evidence: make([]minerLayer, 0),
+		recorded: make(map[minerLayer]struct{}),
+		excluded: make(map[BlockID]minerLayer),
+	}
+}
+
+// has returns whether evidence against the miner in the layer was recorded, callers must hold the lock
+func (eq *equivocations) has(key minerLayer) bool {
+	_, ok := eq.recorded[key]
+	return ok
+}
+
+// record adds the evidence and excludes its blocks, callers must hold the write lock
+func (eq *equivocations) record(key minerLayer, e *Evidence) {
+	eq.evidence = append(eq.evidence, key)
+	eq.recorded[key] = struct{}{}
+	eq.excluded[e.First.ID()] = key
+	eq.excluded[e.Second.ID()] = key
+}
+
+// checkEquivocation records the id of the first block of every miner in a layer and returns the evidence against the miner
+// once another block of the miner in the layer is added, the blocks of the miner in the layer are excluded from then on.
+// blocks without a miner, e.g. the genesis block, are not checked
+func (m *meshDB) checkEquivocation(block *Block) (*Evidence, error) {
+	if block.MinerID == "" {
+		return nil, nil
+	}
+	key := minerLayer{Layer: block.Layer(), MinerID: block.MinerID}
+	m.equivocations.Lock()
+	defer m.equivocations.Unlock()
+	if m.equivocations.has(key) {
+		m.equivocations.excluded[block.ID()] = key
+		return nil, nil
+	}
+
+	b, err := m.layers.Get(minerBlockKey(key))
+	if err != nil {
+		return nil, m.layers.Put(minerBlockKey(key), block.ID().ToBytes())
+	}
+	id := BlockID(common.BytesToUint64(b))
+	if id == block.ID() {
+		return nil, nil
+	}
+	first, err := m.getBlock(id)
+	if err != nil {
+		return nil, fmt.Errorf("could not load block %v of %v in layer %v %v", id, key.MinerID, key.Layer, err)
+	}
+	e := &Evidence{Layer: key.Layer, MinerID: key.MinerID, First: *first, Second: *block}
+	if err := e.Validate(); err != nil {
+		//a block that is not signed by the miner must not get the blocks of the miner excluded
+		return nil, err
+	}
+	return e, m.addEvidenceLocked(key, e)
+}
+
+// addEvidence records evidence that was not found locally, it returns false if evidence against the miner in
+// the layer was already recorded
+func (m *meshDB) addEvidence(e *Evidence) (bool, error) {
+	key := minerLayer{Layer: e.Layer, MinerID: e.MinerID}
+	m.equivocations.Lock()
+	defer m.equivocations.Unlock()
+	if m.equivocations.has(key) {
+		return false, nil
+	}
+	return true, m.addEvidenceLocked(key, e)
+}
+
+// addEvidenceLocked persists the evidence and removes its blocks from the orphans, callers must hold the lock
+func (m *meshDB) addEvidenceLocked(key minerLayer, e *Evidence) error {
+	m.equivocations.record(key, e)
+	b, err := EvidenceAsBytes(e)
+	if err != nil {
+		return err
+	}
+	if err := m.layers.Put(evidenceKey(key), b); err != nil {
+		return err
+	}
+	list, err := evidenceListAsBytes(m.equivocations.evidence)
+	if err != nil {
+		return err
+	}
+	if err := m.layers.Put(evidenceListKey, list); err != nil {
+		return err
+	}
+	return m.removeOrphans(e.First.ID(), e.Second.ID())
+}
+
+// removeOrphans removes the blocks from the orphans and persists the orphans of the layers that changed
+func (m *meshDB) removeOrphans(ids ...BlockID) error {
+	m.orphans.Lock()
+	defer m.orphans.Unlock()
+	changed := make(map[LayerID]struct{})
+	for _, id := range ids {
+		if l, ok := m.orphans.remove(id); ok {
+			changed[l] = struct{}{}
+		}
+	}
+	return m.writeOrphanLayers(changed, false)
+}
+
+func (m *meshDB) loadEvidence() error {
+	b, err := m.layers.Get(evidenceListKey)
+	if err != nil {
+		return nil //no evidence was recorded
+	}
+	keys, err := bytesToEvidenceList(b)
+	if err != nil {
+		return err
+	}
+	m.equivocations.Lock()
+	defer m.equivocations.Unlock()
+	for _, key := range keys {
+		e, err := m.getEvidence(key)
+		if err != nil {
+			return err
+		}
+		m.equivocations.record(key, e)
+	}
+	return nil
+}
+
+func (m *meshDB) getEvidence(key minerLayer) (*Evidence, error) {
+	b, err := m.layers.Get(evidenceKey(key))
+	if err != nil {
+		return nil, fmt.Errorf("missing evidence against %v in layer %v", key.MinerID, key.Layer)
+	}
+	return BytesAsEvidence(bytes.NewReader(b))
+}
+
+// AddEvidence records evidence received from a peer and excludes its blocks, no event is published for it since
+// the evidence is propagated by the gossip it was received from. it returns false if the evidence was known
+func (m *Mesh) AddEvidence(e *Evidence) (bool, error) {
+	if err := e.Validate(); err != nil {
+		return false, err
+	}
+	added, err := m.addEvidence(e)
+	if added {
+		m.Warning("miner %v built blocks %v and %v in layer %v", e.MinerID, e.First.ID(), e.Second.ID(), e.Layer)
+	}
+	return added, err
+}
+
+// EvidenceOf returns the evidence the block is excluded by
+func (m *Mesh) EvidenceOf(id BlockID) (*Evidence, error) {
+	m.equivocations.RLock()
+	defer m.equivocations.RUnlock()
+	key, ok := m.equivocations.excluded[id]
+	if !ok {
+		return nil, fmt.Errorf("block %v is not excluded", id)
+	}
+	return m.getEvidence(key)
+}
+
+// Evidence returns all the recorded evidence ordered by layer
+func (m *Mesh) Evidence() ([]*Evidence, error) {
+	m.equivocations.RLock()
+	defer m.equivocations.RUnlock()
+	res := make([]*Evidence, 0, len(m.equivocations.evidence))
+	for _, key := range m.equivocations.evidence {
+		e, err := m.getEvidence(key)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Layer < res[j].Layer })
+	return res, nil
+}
+
+// IsExcluded returns whether the block was built by a miner that built another block in the same layer
+func (m *Mesh) IsExcluded(id BlockID) bool {
+	m.equivocations.RLock()
+	defer m.equivocations.RUnlock()
+	_, ok := m.equivocations.excluded[id]
+	return ok
+}
@@ -0,0 +1,184 @@
+package mesh
+
+import (
+	"bytes"
+	"github.com/spacemeshos/go-spacemesh/address"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/mesh/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type testMiner struct {
+	priv     crypto.PrivateKey
+	id       string
+	coinbase address.Address
+}
+
+func newTestMiner(t *testing.T) testMiner {
+	priv, pub, err := crypto.GenerateKeyPair()
+	require.NoError(t, err)
+	return testMiner{priv: priv, id: pub.String(), coinbase: address.PublicKeyToAddress(pub.Bytes())}
+}
+
+func (m testMiner) block(t *testing.T, data string, layer LayerID) *Block {
+	b := NewBlock(true, []byte(data), time.Now(), layer)
+	b.MinerID = m.id
+	b.Coinbase = m.coinbase
+	b.Id = b.CalcId()
+	sig, err := m.priv.Sign(b.ContentHash())
+	require.NoError(t, err)
+	b.Sig = sig
+	return b
+}
+
+func TestMesh_Equivocation(t *testing.T) {
+	bdb := database.NewMemDatabase()
+	ldb := database.NewMemDatabase()
+	cdb := database.NewMemDatabase()
+	layers := NewMesh(config.DefaultConfig(), ldb, bdb, cdb, &MeshValidatorMock{}, &MockState{}, log.New("t15", "", ""))
+	events := layers.Subscribe(4, Equivocation)
+	alice, bob := newTestMiner(t), newTestMiner(t)
+
+	a1, a2, a3 := alice.block(t, "a1", 1), alice.block(t, "a2", 1), alice.block(t, "a3", 1)
+	b1 := bob.block(t, "b1", 1)
+	assert.NoError(t, layers.AddBlock(a1))
+	assert.NoError(t, layers.AddBlock(b1))
+	assert.ElementsMatch(t, []BlockID{a1.ID(), b1.ID()}, layers.GetOrphanBlocksByLayerId(1))
+
+	assert.NoError(t, layers.AddBlock(a2))
+	assert.Equal(t, Event{Type: Equivocation, Layer: 1, Block: a2.ID()}, <-events)
+	assert.Equal(t, []BlockID{b1.ID()}, layers.GetOrphanBlocksByLayerId(1), "both blocks of alice should be excluded")
+	assert.Equal(t, []BlockID{b1.ID()}, layers.GetOrphanBlocksExcept(2))
+	e, err := layers.EvidenceOf(a1.ID())
+	require.NoError(t, err)
+	assert.Equal(t, []BlockID{a1.ID(), a2.ID()}, []BlockID{e.First.ID(), e.Second.ID()})
+	assert.NoError(t, e.Validate())
+
+	assert.NoError(t, layers.AddBlock(a3))
+	assert.True(t, layers.IsExcluded(a3.ID()), "later blocks of alice in the layer should be excluded as well")
+	assert.Equal(t, []BlockID{b1.ID()}, layers.GetOrphanBlocksByLayerId(1))
+
+	//a block that is not signed by bob must not get his blocks excluded
+	forged := bob.block(t, "b2", 1)
+	forged.Data = []byte("forged")
+	forged.Id = forged.CalcId()
+	assert.NoError(t, layers.AddBlock(forged))
+	assert.False(t, layers.IsExcluded(b1.ID()))
+	assert.Len(t, events, 0)
+
+	all, err := layers.Evidence()
+	require.NoError(t, err)
+	assert.Equal(t, []*Evidence{e}, all)
+
+	restarted := NewMesh(config.DefaultConfig(), ldb, bdb, cdb, &MeshValidatorMock{}, &MockState{}, log.New("t15", "", ""))
+	assert.True(t, restarted.IsExcluded(a1.ID()))
+	assert.True(t, restarted.IsExcluded(a2.ID()))
+	restartedAll, err := restarted.Evidence()
+	require.NoError(t, err)
+	assert.Equal(t, all, restartedAll)
+}
+
+// slowDB delays writes, a block that is checked before its body was written is not found
+type slowDB struct {
+	database.DB
+}
+
+func (db slowDB) Put(key, value []byte) error {
+	time.Sleep(10 * time.Millisecond)
+	return db.DB.Put(key, value)
+}
+
+func TestMesh_EquivocationBackToBack(t *testing.T) {
+	layers := NewMesh(config.DefaultConfig(), database.NewMemDatabase(), slowDB{database.NewMemDatabase()}, database.NewMemDatabase(), &MeshValidatorMock{}, &MockState{}, log.New("t27", "", ""))
+	defer layers.Close()
+	events := layers.Subscribe(8, Equivocation)
+	alice := newTestMiner(t)
+
+	for l := LayerID(1); l <= 4; l++ {
+		first, second := alice.block(t, "first", l), alice.block(t, "second", l)
+		assert.NoError(t, layers.AddBlock(first))
+		//the conflicting block is gossiped as well or arrives with the synced layer
+		if l > 2 {
+			assert.NoError(t, layers.AddBlock(second))
+		} else {
+			assert.NoError(t, layers.AddLayer(NewExistingLayer(l, []*Block{second})))
+		}
+		select {
+		case ev := <-events:
+			assert.Equal(t, Event{Type: Equivocation, Layer: l, Block: second.ID()}, ev)
+		default:
+			t.Fatalf("the equivocation in layer %v was not detected", l)
+		}
+		assert.True(t, layers.IsExcluded(first.ID()))
+	}
+}
+
+func TestMesh_EquivocationInLayer(t *testing.T) {
+	st := &recordingState{}
+	layers := NewMesh(config.DefaultConfig(), database.NewMemDatabase(), database.NewMemDatabase(), database.NewMemDatabase(), &MeshValidatorMock{}, st, log.New("t24", "", ""))
+	defer layers.Close()
+	events := layers.Subscribe(4, Equivocation)
+	alice, bob := newTestMiner(t), newTestMiner(t)
+
+	a1, a2, b1 := alice.block(t, "a1", 1), alice.block(t, "a2", 1), bob.block(t, "b1", 1)
+	assert.NoError(t, layers.AddLayer(NewExistingLayer(1, []*Block{a1, b1, a2})))
+	assert.Equal(t, Event{Type: Equivocation, Layer: 1, Block: a2.ID()}, <-events)
+	assert.True(t, layers.IsExcluded(a1.ID()))
+	assert.True(t, layers.IsExcluded(a2.ID()))
+	assert.False(t, layers.IsExcluded(b1.ID()))
+	first, err := layers.layers.Get(minerBlockKey(minerLayer{Layer: 1, MinerID: bob.id}))
+	assert.NoError(t, err)
+	assert.Equal(t, b1.ID().ToBytes(), first, "only the id of the first block of a miner should be stored")
+
+	//the tortoise may still consider the excluded blocks valid
+	for _, b := range []*Block{a1, a2, b1} {
+		layers.ContextualValidityCallback(b.ID(), true)
+	}
+	layers.LayerCompleteCallback(1)
+	assert.Equal(t, []address.Address{bob.coinbase}, st.miners, "the miner of excluded blocks should not be rewarded")
+	assert.Equal(t, calcLayerHash([]BlockID{b1.ID()}), st.seed)
+
+	assert.NoError(t, layers.pruneLayer(1))
+	for _, id := range []string{alice.id, bob.id} {
+		_, err := layers.layers.Get(minerBlockKey(minerLayer{Layer: 1, MinerID: id}))
+		assert.Error(t, err, "the first blocks of the miners should be deleted with the layer")
+	}
+}
+
+func TestMesh_AddEvidence(t *testing.T) {
+	layers := getMesh("t16")
+	defer layers.Close()
+	alice := newTestMiner(t)
+	a1, a2 := alice.block(t, "a1", 1), alice.block(t, "a2", 1)
+	assert.NoError(t, layers.AddBlock(a1))
+
+	_, err := layers.AddEvidence(&Evidence{Layer: 1, MinerID: alice.id, First: *a1, Second: *a1})
+	assert.Error(t, err)
+	_, err = layers.AddEvidence(&Evidence{Layer: 2, MinerID: alice.id, First: *a1, Second: *a2})
+	assert.Error(t, err)
+
+	e := &Evidence{Layer: 1, MinerID: alice.id, First: *a1, Second: *a2}
+	added, err := layers.AddEvidence(e)
+	assert.NoError(t, err)
+	assert.True(t, added)
+	assert.Empty(t, layers.GetOrphanBlocks())
+
+	added, err = layers.AddEvidence(&Evidence{Layer: 1, MinerID: alice.id, First: *a2, Second: *a1})
+	assert.NoError(t, err)
+	assert.False(t, added, "evidence against alice in the layer is known")
+
+	assert.NoError(t, layers.AddBlock(a2))
+	assert.Empty(t, layers.GetOrphanBlocks(), "blocks of the evidence should not become orphans")
+
+	b, err := EvidenceAsBytes(e)
+	require.NoError(t, err)
+	decoded, err := BytesAsEvidence(bytes.NewReader(b))
+	require.NoError(t, err)
+	assert.NoError(t, decoded.Validate())
+	assert.Equal(t, []BlockID{a1.ID(), a2.ID()}, []BlockID{decoded.First.ID(), decoded.Second.ID()})
+}
@@ -0,0 +1,92 @@
+package mesh
+
+import (
+	"fmt"
+	"github.com/davecgh/go-xdr/xdr2"
+	"io"
+)
+
+const exportMagic = "spacemesh-mesh"
+const exportVersion = 1
+
+// exportHeader starts a mesh export file, it is followed by the layers From..To in order
+type exportHeader struct {
+	Magic   string
+	Version uint32
+	From    LayerID
+	To      LayerID
+}
+
+type exportedLayer struct {
+	Index  LayerID
+	Blocks []Block
+}
+
+// Export writes the layers [from, to] with all their blocks to w, it only reads the databases so it can run on the
+// databases of a node that is not running without building the mesh
+func (m *meshDB) Export(w io.Writer, from, to LayerID) error {
+	if from > to {
+		return fmt.Errorf("invalid layer range %v-%v", from, to)
+	}
+	hdr := exportHeader{Magic: exportMagic, Version: exportVersion, From: from, To: to}
+	if _, err := xdr.Marshal(w, &hdr); err != nil {
+		return fmt.Errorf("could not write export header %v", err)
+	}
+	for i := from; i <= to; i++ {
+		l, err := m.getLayer(i)
+		if err != nil {
+			return fmt.Errorf("could not export layer %v %v", i, err)
+		}
+		el := exportedLayer{Index: i, Blocks: make([]Block, 0, len(l.Blocks()))}
+		for _, b := range l.Blocks() {
+			el.Blocks = append(el.Blocks, *b)
+		}
+		if _, err := xdr.Marshal(w, &el); err != nil {
+			return fmt.Errorf("could not write layer %v %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Import reads layers written by Export, adds them to the mesh and hands them to the tortoise.
+// the layers must continue the layers already in the mesh
+func (m *Mesh) Import(r io.Reader) (from, to LayerID, err error) {
+	var hdr exportHeader
+	if _, err := xdr.Unmarshal(r, &hdr); err != nil {
+		return 0, 0, fmt.Errorf("could not read export header %v", err)
+	}
+	if hdr.Magic != exportMagic {
+		return 0, 0, fmt.Errorf("not a mesh export file")
+	}
+	if hdr.Version != exportVersion {
+		return 0, 0, fmt.Errorf("unsupported export version %v", hdr.Version)
+	}
+	if hdr.From > hdr.To {
+		return 0, 0, fmt.Errorf("invalid layer range %v-%v", hdr.From, hdr.To)
+	}
+
+	for i := hdr.From; i <= hdr.To; i++ {
+		var el exportedLayer
+		if _, err := xdr.Unmarshal(r, &el); err != nil {
+			return 0, 0, fmt.Errorf("could not read layer %v %v", i, err)
+		}
+		if el.Index != i {
+			return 0, 0, fmt.Errorf("expected layer %v got layer %v", i, el.Index)
+		}
+		blocks := make([]*Block, 0, len(el.Blocks))
+		for j := range el.Blocks {
+			b := &el.Blocks[j]
+			if b.LayerIndex != i || !b.ValidId() || !b.ValidSignature() {
+				return 0, 0, fmt.Errorf("invalid block %v in layer %v", b.ID(), i)
+			}
+			blocks = append(blocks, b)
+		}
+		l := NewExistingLayer(i, blocks)
+		if err := m.AddLayer(l); err != nil {
+			return 0, 0, fmt.Errorf("could not add layer %v %v", i, err)
+		}
+		m.ValidateLayer(l)
+	}
+	m.Info("imported layers %v-%v", hdr.From, hdr.To)
+	return hdr.From, hdr.To, nil
+}
@@ -0,0 +1,89 @@
+package mesh
+
+import (
+	"bytes"
+	"github.com/davecgh/go-xdr/xdr2"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/mesh/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMesh_ExportImport(t *testing.T) {
+	layers := getMesh("t14")
+	miner := newTestMiner(t)
+	blocks := []*Block{
+		miner.block(t, "data1", 0),
+		miner.block(t, "data2", 1),
+		miner.block(t, "data3", 1),
+		miner.block(t, "data4", 2),
+	}
+	assert.NoError(t, layers.AddLayer(NewExistingLayer(0, blocks[:1])))
+	assert.NoError(t, layers.AddLayer(NewExistingLayer(1, blocks[1:3])))
+	assert.NoError(t, layers.AddLayer(NewExistingLayer(2, blocks[3:])))
+
+	var buf bytes.Buffer
+	assert.NoError(t, layers.Export(&buf, 0, 2))
+	assert.Error(t, layers.Export(&bytes.Buffer{}, 2, 3), "layer 3 does not exist")
+
+	trtl := &replayValidatorMock{}
+	imported := NewMesh(config.DefaultConfig(), database.NewMemDatabase(), database.NewMemDatabase(), database.NewMemDatabase(), trtl, &MockState{}, log.New("t15", "", ""))
+	from, to, err := imported.Import(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, LayerID(0), from)
+	assert.Equal(t, LayerID(2), to)
+	assert.Equal(t, []LayerID{0, 1, 2}, trtl.layers, "imported layers should be handed to the tortoise")
+
+	for i := LayerID(0); i <= 2; i++ {
+		h1, err := layers.GetLayerHash(i)
+		assert.NoError(t, err)
+		h2, err := imported.GetLayerHash(i)
+		assert.NoError(t, err)
+		assert.Equal(t, h1, h2)
+	}
+	for _, b := range blocks {
+		res, err := imported.GetBlock(b.ID())
+		assert.NoError(t, err, "the imported blocks should be written before the import returns")
+		assert.Equal(t, b.ID(), res.ID())
+	}
+}
+
+func TestMesh_ImportInvalid(t *testing.T) {
+	layers := getMesh("t16")
+
+	_, _, err := layers.Import(bytes.NewReader([]byte("garbage")))
+	assert.Error(t, err)
+
+	var buf bytes.Buffer
+	_, err = xdr.Marshal(&buf, &exportHeader{Magic: exportMagic, Version: exportVersion + 1})
+	assert.NoError(t, err)
+	_, _, err = layers.Import(&buf)
+	assert.Error(t, err, "unsupported version")
+
+	buf.Reset()
+	block := NewBlock(true, []byte("data"), time.Now(), 0)
+	block.Data = []byte("tampered data")
+	_, err = xdr.Marshal(&buf, &exportHeader{Magic: exportMagic, Version: exportVersion, From: 0, To: 0})
+	assert.NoError(t, err)
+	_, err = xdr.Marshal(&buf, &exportedLayer{Index: 0, Blocks: []Block{*block}})
+	assert.NoError(t, err)
+	_, _, err = layers.Import(&buf)
+	assert.Error(t, err, "block content does not match its id")
+
+	buf.Reset()
+	block = NewBlock(true, []byte("data"), time.Now(), 0)
+	block.MinerID = newTestMiner(t).id
+	block.Id = block.CalcId()
+	block.Sig, err = newTestMiner(t).priv.Sign(block.ContentHash())
+	assert.NoError(t, err)
+	_, err = xdr.Marshal(&buf, &exportHeader{Magic: exportMagic, Version: exportVersion, From: 0, To: 0})
+	assert.NoError(t, err)
+	_, err = xdr.Marshal(&buf, &exportedLayer{Index: 0, Blocks: []Block{*block}})
+	assert.NoError(t, err)
+	_, _, err = layers.Import(&buf)
+	assert.Error(t, err, "block is not signed by its miner")
+	_, err = layers.GetBlock(block.ID())
+	assert.Error(t, err, "the block should not be imported")
+}
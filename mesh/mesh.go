@@ -2,13 +2,16 @@ package mesh
 
 import (
 	"errors"
+	"fmt"
+	"github.com/spacemeshos/go-spacemesh/address"
 	"github.com/spacemeshos/go-spacemesh/database"
 	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/mesh/config"
 	"github.com/spacemeshos/go-spacemesh/state"
-	"math/big"
 	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 const layerSize = 200
@@ -17,6 +20,16 @@ const cachedLayers = 50
 var TRUE = []byte{1}
 var FALSE = []byte{0}
 
+// keys of the mesh state that is persisted in the layers database
+var (
+	verifiedLayerKey = []byte("verified")
+	latestLayerKey   = []byte("latest")
+	lastSeenLayerKey = []byte("lastSeen")
+	orphanLayersKey  = []byte("orphanLayers")
+	pruneFromKey     = []byte("pruneFrom")
+	evidenceListKey  = []byte("evidence")
+)
+
 /*type Mesh interface {
 	AddLayer(layer *Layer) error
 	GetVerifiedLayer(i LayerID) (*Layer, error)
@@ -34,51 +47,162 @@ type MeshValidator interface {
 	HandleIncomingLayer(layer *Layer)
 	HandleLateBlock(bl *Block)
 	RegisterLayerCallback(func(layerId LayerID))
+	RegisterValidityCallback(func(id BlockID, valid bool))
 }
 
 type StateUpdater interface {
-	ApplyTransactions(layer state.LayerID, transactions state.Transactions) (uint32, error)
+	ApplyTransactions(layer state.LayerID, seed []byte, miners []address.Address, transactions state.Transactions) (uint32, error)
+	Reset(layer state.LayerID) error
+	LatestLayer() (state.LayerID, error)
 }
 
 type Mesh struct {
 	log.Log
 	*meshDB
+	*eventBus
 	verifiedLayer uint32
 	latestLayer   uint32
 	lastSeenLayer uint32
+	pruneFrom     uint32 //first layer whose blocks were not pruned, accessed atomically
+	config        config.Config
 	lMutex        sync.RWMutex
 	lkMutex       sync.RWMutex
 	lcMutex       sync.RWMutex
 	tortoise      MeshValidator
 	state         StateUpdater
-	orphMutex     sync.RWMutex
+	rMutex        sync.Mutex
+	reorgPending  bool
+	reorgFrom     LayerID //first applied layer in which the validity of a block changed
+	booting       uint32  //set while the verified layers are replayed to the tortoise, accessed atomically
 }
 
-func NewMesh(layers, blocks, validity database.DB, mesh MeshValidator, state StateUpdater, logger log.Log) *Mesh {
-	//todo add boot from disk
+func NewMesh(conf config.Config, layers, blocks, validity database.DB, mesh MeshValidator, state StateUpdater, logger log.Log) *Mesh {
 	ll := &Mesh{
 		Log:      logger,
+		config:   conf,
 		tortoise: mesh,
 		state:    state,
 		meshDB:   NewMeshDB(layers, blocks, validity),
+		eventBus: newEventBus(logger),
 	}
+	mesh.RegisterValidityCallback(ll.ContextualValidityCallback)
+	ll.boot()
 	mesh.RegisterLayerCallback(ll.LayerCompleteCallback)
 	return ll
 }
 
-func SerializableTransaction2StateTransaction(tx *SerializableTransaction) *state.Transaction {
-	price := big.Int{}
-	price.SetBytes(tx.Price)
+// boot loads the layer pointers and orphan blocks persisted by a previous run and replays the received layers
+// so the tortoise rebuilds its in memory view, pruned layers are replayed from the votes kept for them
+func (m *Mesh) boot() {
+	if err := m.loadOrphans(); err != nil {
+		m.Error("could not load orphan blocks %v", err)
+	}
+	if err := m.loadEvidence(); err != nil {
+		m.Error("could not load equivocation evidence %v", err)
+	}
 
-	amount := big.Int{}
-	amount.SetBytes(tx.Amount)
+	verified, err := m.getLayerPointer(verifiedLayerKey)
+	if err != nil {
+		m.Info("no persisted mesh found, starting from genesis")
+		return
+	}
+	latest, err := m.getLayerPointer(latestLayerKey)
+	if err != nil {
+		m.Error("could not load latest layer %v", err)
+	}
+	lastSeen, err := m.getLayerPointer(lastSeenLayerKey)
+	if err != nil {
+		m.Error("could not load last seen layer %v", err)
+	}
+	pruneFrom, err := m.getLayerPointer(pruneFromKey)
+	if err == nil {
+		atomic.StoreUint32(&m.pruneFrom, pruneFrom)
+	}
+
+	m.latestLayer = latest
+	m.lastSeenLayer = lastSeen
+	atomic.StoreUint32(&m.verifiedLayer, verified)
+
+	//the layers received after the verified layer are replayed as well, the tortoise completes them again.
+	//transactions of the verified layers were applied before the restart
+	m.tortoise.RegisterLayerCallback(func(l LayerID) {
+		if l > LayerID(verified) {
+			m.LayerCompleteCallback(l)
+		}
+	})
+	atomic.StoreUint32(&m.booting, 1)
+	defer atomic.StoreUint32(&m.booting, 0)
+	for i := LayerID(0); i <= LayerID(lastSeen); i++ {
+		l, err := m.getLayer(i)
+		if i < m.prunedBefore() {
+			l, err = m.getPrunedLayer(i)
+		}
+		if err != nil {
+			m.Error("could not load layer %v, stopped replay %v", i, err)
+			break
+		}
+		m.tortoise.HandleIncomingLayer(l)
+	}
+	m.Info("loaded mesh from disk, verified layer %v latest layer %v last seen layer %v", m.VerifiedLayer(), m.latestLayer, m.lastSeenLayer)
+}
 
-	return state.NewTransaction(tx.AccountNonce, tx.Origin, *tx.Recipient, &amount, tx.GasLimit, &price)
+// SerializableTransaction2StateTransaction converts tx to a state transaction whose origin is recovered from the signature
+func SerializableTransaction2StateTransaction(tx *SerializableTransaction) (*state.Transaction, error) {
+	t := tx.unsigned()
+	t.Signature = tx.Signature
+	if err := t.VerifySignature(); err != nil {
+		return nil, err
+	}
+	return t, nil
 }
 
+// IsContexuallyValid returns true only for blocks the tortoise decided are valid, blocks it has no opinion on
+// are not valid
 func (m *Mesh) IsContexuallyValid(b BlockID) bool {
-	//todo implement
-	return true
+	valid, err := m.getContextualValidity(b)
+	if err != nil {
+		return false
+	}
+	return valid
+}
+
+// ContextualValidityCallback records the tortoise decision on the validity of a block, a decision that changes the
+// validity of a block in a layer that was already applied schedules a reorg from that layer. a block that was
+// undecided when its layer was applied was not applied, deciding it is invalid changes nothing
+func (m *Mesh) ContextualValidityCallback(id BlockID, valid bool) {
+	prev := m.IsContexuallyValid(id)
+	if err := m.setContextualValidity(id, valid); err != nil {
+		m.Log.Error("could not persist contextual validity of block %v %v", id, err)
+	}
+	if prev == valid || atomic.LoadUint32(&m.booting) == 1 {
+		//the replay goes through the decisions made before the restart, it ends at the validity the state was applied with
+		return
+	}
+	applied, err := m.getLayerPointer(verifiedLayerKey)
+	if err != nil {
+		return //no layer was applied yet
+	}
+	b, err := m.getBlock(id)
+	if err != nil {
+		//the layers of the reorg window are not pruned, the decision came too late to revert the state
+		m.Log.Error("could not load block %v whose validity changed, it is not reorganized %v", id, err)
+		return
+	}
+	layer := b.Layer()
+	if layer > LayerID(applied) {
+		return
+	}
+	if layer == 0 {
+		m.Log.Error("validity of genesis block %v changed to %v, the genesis state is never reverted", id, valid)
+		return
+	}
+	m.Log.Warning("validity of block %v in applied layer %v changed to %v", id, layer, valid)
+	m.rMutex.Lock()
+	if !m.reorgPending || layer < m.reorgFrom {
+		m.reorgFrom = layer
+		m.reorgPending = true
+	}
+	m.rMutex.Unlock()
 }
 
 func (m *Mesh) VerifiedLayer() uint32 {
@@ -101,6 +225,9 @@ func (m *Mesh) SetLatestLayer(idx uint32) {
 	if idx > m.latestLayer {
 		m.Debug("set latest known layer to ", idx)
 		m.latestLayer = idx
+		if err := m.setLayerPointer(latestLayerKey, idx); err != nil {
+			m.Error("could not persist latest layer %v", err)
+		}
 	}
 }
 
@@ -118,8 +245,18 @@ func (m *Mesh) AddLayer(layer *Layer) error {
 		return errors.New("can't add layer missing previous layers")
 	}
 	atomic.StoreUint32(&m.lastSeenLayer, uint32(layer.Index()))
-	m.addLayer(layer)
+	if err := m.setLayerPointer(lastSeenLayerKey, uint32(layer.Index())); err != nil {
+		m.Error("could not persist last seen layer %v", err)
+	}
+	if err := m.addLayer(layer); err != nil {
+		return err
+	}
 	m.SetLatestLayer(uint32(layer.Index()))
+	for _, b := range layer.Blocks() {
+		m.handleEquivocation(b)
+		m.publish(Event{Type: BlockAdded, Layer: layer.Index(), Block: b.ID()})
+	}
+	m.publish(Event{Type: LayerReceived, Layer: layer.Index()})
 	return nil
 }
 
@@ -127,34 +264,175 @@ func (m *Mesh) ValidateLayer(layer *Layer) {
 	m.tortoise.HandleIncomingLayer(layer)
 }
 
+// LayerCompleteCallback applies the layers the tortoise completed up to layerId. the verified layer only advances
+// past a layer once it was applied, a layer that could not be applied is retried when the next layer completes
 func (m *Mesh) LayerCompleteCallback(layerId LayerID) {
 	m.Log.Info("layer %v is complete", layerId)
+	applied := LayerID(m.VerifiedLayer())
+
+	//the tortoise reports validity changes before the layer that caused them completes
+	if err := m.reorg(applied); err != nil {
+		m.Log.Error("reorg failed, layer %v is not applied %v", layerId, err)
+		return
+	}
+	next := layerId
+	if _, err := m.getLayerPointer(verifiedLayerKey); err == nil {
+		next = applied + 1
+	}
+	for l := next; l <= layerId; l++ {
+		if latest, err := m.state.LatestLayer(); err == nil && state.LayerID(l) <= latest {
+			//e.g. the state was imported from a snapshot of a later layer
+			m.Log.Info("layer %v is already part of the state of layer %v", l, latest)
+			m.setVerifiedLayer(l)
+		} else if err := m.applyLayer(l); err != nil {
+			m.Log.Error("could not apply layer %v %v", l, err)
+			return
+		} else {
+			m.setVerifiedLayer(l)
+			m.publish(Event{Type: LayerApplied, Layer: l})
+		}
+		m.prune(l)
+	}
+}
+
+func (m *Mesh) setVerifiedLayer(l LayerID) {
+	atomic.StoreUint32(&m.verifiedLayer, uint32(l))
+	if err := m.setLayerPointer(verifiedLayerKey, uint32(l)); err != nil {
+		m.Log.Error("could not persist verified layer %v", err)
+	}
+	m.publish(Event{Type: LayerVerified, Layer: l})
+}
+
+// reorg reverts the state to the layer before the first applied layer in which the validity of a block changed and
+// applies the layers up to applied again with their current validity. the reorg stays pending until it succeeds,
+// so no further layer is applied on top of a state that does not match the validity of the blocks
+func (m *Mesh) reorg(applied LayerID) error {
+	m.rMutex.Lock()
+	from, pending := m.reorgFrom, m.reorgPending
+	m.rMutex.Unlock()
+	if !pending {
+		return nil
+	}
+	if from < m.prunedBefore() {
+		return fmt.Errorf("cannot reorg from layer %v, its blocks or the state before it are not available", from)
+	}
+
+	m.Log.Warning("reorg of layers %v to %v", from, applied)
+	if err := m.state.Reset(state.LayerID(from - 1)); err != nil {
+		return err
+	}
+	layers := make([]LayerID, 0, applied-from+1)
+	for l := from; l <= applied; l++ {
+		if err := m.applyLayer(l); err != nil {
+			return fmt.Errorf("could not apply layer %v again %v", l, err)
+		}
+		layers = append(layers, l)
+	}
+	m.rMutex.Lock()
+	if m.reorgFrom >= from {
+		m.reorgPending = false
+	}
+	m.rMutex.Unlock()
+	m.publish(Event{Type: Reorg, Layer: from, Layers: layers})
+	return nil
+}
+
+// applyLayer applies the transactions of the contextually valid blocks of the layer and rewards their miners
+func (m *Mesh) applyLayer(layerId LayerID) error {
 	l, err := m.getLayer(layerId)
-	atomic.StoreUint32(&m.verifiedLayer, uint32(layerId))
 	if err != nil {
-		m.Log.Error("layer %v not found", layerId)
-		return
-		//panic("wtf - there was a race?")
+		return fmt.Errorf("layer not found %v", err)
 	}
 
 	txs := make([]*state.Transaction, 0, len(l.blocks))
+	miners := make([]address.Address, 0, len(l.blocks))
+	valid := make([]BlockID, 0, len(l.blocks))
 
 	sort.Slice(l.blocks, func(i, j int) bool {
 		return l.blocks[i].Id < l.blocks[j].Id
 	}) //todo: blocks are now sorted... what does it mean? does the ordering degrade security?
 
 	for _, b := range l.blocks {
+		if !m.IsContexuallyValid(b.ID()) {
+			m.Log.Info("skipping transactions of contextually invalid block %v", b.ID())
+			continue
+		}
+		if m.IsExcluded(b.ID()) {
+			m.Log.Info("skipping transactions of block %v whose miner built another block in the layer", b.ID())
+			continue
+		}
+		//the genesis block and blocks of miners that did not set a coinbase are not rewarded
+		if b.Coinbase != (address.Address{}) {
+			miners = append(miners, b.Coinbase)
+		}
+		valid = append(valid, b.ID())
 		for _, tx := range b.Txs {
 			//todo: think about these conversions.. are they needed?
-			txs = append(txs, SerializableTransaction2StateTransaction(&tx))
+			t, err := SerializableTransaction2StateTransaction(&tx)
+			if err != nil {
+				m.Log.Warning("skipping transaction with invalid signature in block %v %v", b.ID(), err)
+				continue
+			}
+			txs = append(txs, t)
 		}
 	}
 	m.Log.Info("received %v txs in layer %v num of blocks: %v", len(txs), layerId, len(l.blocks))
-	x, err := m.state.ApplyTransactions(state.LayerID(layerId), txs)
+	//the valid blocks are agreed on by all nodes, their hash seeds the order the transactions are applied in
+	x, err := m.state.ApplyTransactions(state.LayerID(layerId), calcLayerHash(valid), miners, txs)
 	if err != nil {
-		m.Log.Error("cannot apply transactions %v", err)
+		return fmt.Errorf("cannot apply transactions %v", err)
 	}
 	m.Log.Info("applied %v transactions", x)
+	return nil
+}
+
+// prune deletes the blocks of the verified layers that fall outside of the retention policy,
+// a layer is pruned once it exceeds either the layer count or the age limit
+func (m *Mesh) prune(verified LayerID) {
+	if m.config.RetainLayers == 0 && m.config.RetainAge == 0 {
+		return
+	}
+	//the tortoise may still change the validity of the blocks in the reorg window, a reorg needs their bodies
+	for l := m.prunedBefore(); l < verified && verified-l >= LayerID(m.config.ReorgWindow) && m.shouldPrune(l, verified); l++ {
+		if err := m.pruneLayer(l); err != nil {
+			m.Error("could not prune layer %v %v", l, err)
+			return
+		}
+		atomic.StoreUint32(&m.pruneFrom, uint32(l+1))
+		if err := m.setLayerPointer(pruneFromKey, uint32(l+1)); err != nil {
+			m.Error("could not persist pruned layer %v", err)
+		}
+		m.Debug("pruned blocks of layer %v", l)
+	}
+}
+
+// prunedBefore returns the first layer whose blocks were not pruned
+func (m *Mesh) prunedBefore() LayerID {
+	return LayerID(atomic.LoadUint32(&m.pruneFrom))
+}
+
+func (m *Mesh) shouldPrune(l, verified LayerID) bool {
+	if m.config.RetainLayers > 0 && verified-l >= LayerID(m.config.RetainLayers) {
+		return true
+	}
+	if m.config.RetainAge == 0 {
+		return false
+	}
+	ids, err := m.getLayerBlockIds(l)
+	if err != nil {
+		return true //no blocks were received for the layer
+	}
+	blocks, err := m.getLayerBlocks(ids)
+	if err != nil {
+		return false
+	}
+	var newest int64
+	for _, b := range blocks {
+		if b.Timestamp > newest {
+			newest = b.Timestamp
+		}
+	}
+	return time.Since(time.Unix(0, newest)) > m.config.RetainAge
 
 }
 
@@ -174,6 +452,24 @@ func (m *Mesh) GetLayer(i LayerID) (*Layer, error) {
 	return m.getLayer(i)
 }
 
+// GetLayerBlockIDs returns the ids of the blocks known for the layer, these are kept for pruned layers as well
+func (m *Mesh) GetLayerBlockIDs(i LayerID) ([]BlockID, error) {
+	ids, err := m.getLayerBlockIds(i)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]BlockID, 0, len(ids))
+	for id := range ids {
+		res = append(res, id)
+	}
+	return res, nil
+}
+
+// GetLayerHash returns the persisted hash of the blocks known for the layer
+func (m *Mesh) GetLayerHash(i LayerID) ([]byte, error) {
+	return m.getLayerHash(i)
+}
+
 func (m *Mesh) AddBlock(block *Block) error {
 	log.Debug("add block ", block.ID())
 	if err := m.addBlock(block); err != nil {
@@ -181,78 +477,43 @@ func (m *Mesh) AddBlock(block *Block) error {
 		return err
 	}
 	m.SetLatestLayer(uint32(block.Layer()))
+	m.handleEquivocation(block)
 	//new block add to orphans
 	m.handleOrphanBlocks(block)
+	m.publish(Event{Type: BlockAdded, Layer: block.Layer(), Block: block.ID()})
 	//m.tortoise.HandleLateBlock(block) //why this? todo should be thread safe?
 	return nil
 }
 
-//todo better thread safety
-func (m *Mesh) handleOrphanBlocks(block *Block) {
-	m.orphMutex.Lock()
-	defer m.orphMutex.Unlock()
-	if _, ok := m.orphanBlocks[block.LayerIndex]; !ok {
-		m.orphanBlocks[block.LayerIndex] = make(map[BlockID]struct{})
-	}
-	m.orphanBlocks[block.LayerIndex][block.Id] = struct{}{}
-	atomic.AddInt32(&m.orphanBlockCount, 1)
-	for _, b := range block.ViewEdges {
-		for _, layermap := range m.orphanBlocks {
-			if _, has := layermap[b]; has {
-				m.Log.Debug("delete block ", b, "from orphans")
-				delete(layermap, b)
-				atomic.AddInt32(&m.orphanBlockCount, -1)
-				break
-			}
-		}
+// handleEquivocation checks whether the miner of the block built another block in its layer and publishes the
+// evidence found
+func (m *Mesh) handleEquivocation(block *Block) {
+	e, err := m.checkEquivocation(block)
+	if err != nil {
+		m.Error("could not check block %v for equivocation %v", block.ID(), err)
+	}
+	if e != nil {
+		m.Warning("miner %v built blocks %v and %v in layer %v", e.MinerID, e.First.ID(), e.Second.ID(), e.Layer)
+		m.publish(Event{Type: Equivocation, Layer: block.Layer(), Block: block.ID()})
+	}
+}
 
+func (m *Mesh) handleOrphanBlocks(block *Block) {
+	if err := m.addOrphan(block); err != nil {
+		m.Log.Error("could not persist orphan blocks %v", err)
 	}
 }
 
 func (m *Mesh) GetOrphanBlocksByLayerId(layerId LayerID) []BlockID {
-	m.orphMutex.RLock()
-	defer m.orphMutex.RUnlock()
-	if keys, has := m.orphanBlocks[layerId]; has {
-		ids := make([]BlockID, 0, len(keys))
-
-		for bid := range keys {
-			ids = append(ids, bid)
-		}
-		return ids
-
-	}
-	return make([]BlockID, 0, 0)
+	return m.orphans.layerBlocks(layerId)
 }
 
-//todo better thread safety
 func (m *Mesh) GetOrphanBlocks() []BlockID {
-	m.orphMutex.RLock()
-	defer m.orphMutex.RUnlock()
-	keys := make([]BlockID, 0, m.orphanBlockCount)
-	for _, val := range m.orphanBlocks {
-		for bid := range val {
-			keys = append(keys, bid)
-		}
-	}
-
-	return keys
+	return m.orphans.all()
 }
 
-//todo better thread safety
 func (m *Mesh) GetOrphanBlocksExcept(l LayerID) []BlockID {
-	m.orphMutex.RLock()
-	defer m.orphMutex.RUnlock()
-	keys := make([]BlockID, 0, m.orphanBlockCount)
-	for key, val := range m.orphanBlocks {
-		if key == l {
-			continue
-		}
-		for bid := range val {
-			keys = append(keys, bid)
-		}
-	}
-
-	return keys
+	return m.orphans.except(l)
 }
 
 func (m *Mesh) GetBlock(id BlockID) (*Block, error) {
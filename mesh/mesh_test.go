@@ -2,26 +2,41 @@ package mesh
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"github.com/spacemeshos/go-spacemesh/address"
+	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/database"
 	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/mesh/config"
 	"github.com/spacemeshos/go-spacemesh/state"
 	"github.com/stretchr/testify/assert"
+	"math/big"
 	"testing"
 	"time"
 )
 
 type MeshValidatorMock struct{}
 
-func (m *MeshValidatorMock) HandleIncomingLayer(layer *Layer)       {}
-func (m *MeshValidatorMock) HandleLateBlock(bl *Block)              {}
-func (m *MeshValidatorMock) RegisterLayerCallback(func(id LayerID)) {}
+func (m *MeshValidatorMock) HandleIncomingLayer(layer *Layer)                      {}
+func (m *MeshValidatorMock) HandleLateBlock(bl *Block)                             {}
+func (m *MeshValidatorMock) RegisterLayerCallback(func(id LayerID))                {}
+func (m *MeshValidatorMock) RegisterValidityCallback(func(id BlockID, valid bool)) {}
 
 type MockState struct{}
 
-func (MockState) ApplyTransactions(layer state.LayerID, txs state.Transactions) (uint32, error) {
+func (MockState) ApplyTransactions(layer state.LayerID, seed []byte, miners []address.Address, txs state.Transactions) (uint32, error) {
 	return 0, nil
 }
 
+func (MockState) Reset(layer state.LayerID) error {
+	return nil
+}
+
+func (MockState) LatestLayer() (state.LayerID, error) {
+	return 0, errors.New("no layer was applied")
+}
+
 func getMesh(id string) *Mesh {
 
 	//time := time.Now()
@@ -29,7 +44,7 @@ func getMesh(id string) *Mesh {
 	ldb := database.NewMemDatabase()
 	cdb := database.NewMemDatabase()
 
-	layers := NewMesh(ldb, bdb, cdb, &MeshValidatorMock{}, &MockState{}, log.New(id, "", ""))
+	layers := NewMesh(config.DefaultConfig(), ldb, bdb, cdb, &MeshValidatorMock{}, &MockState{}, log.New(id, "", ""))
 	return layers
 }
 
@@ -164,3 +179,481 @@ func TestLayers_OrphanBlocks(t *testing.T) {
 	assert.True(t, len(layers.GetOrphanBlocks()) == 1, "wrong layer")
 
 }
+
+func TestLayers_LayerHash(t *testing.T) {
+	layers := getMesh("t7")
+	defer layers.Close()
+	block1 := NewBlock(true, []byte("data1"), time.Now(), 1)
+	block2 := NewBlock(true, []byte("data2"), time.Now(), 1)
+	block3 := NewBlock(true, []byte("data3"), time.Now(), 1)
+
+	l := NewExistingLayer(1, []*Block{block1, block2})
+	err := layers.AddLayer(l)
+	assert.NoError(t, err)
+	h, err := layers.GetLayerHash(1)
+	assert.NoError(t, err)
+	assert.Equal(t, l.Hash(), h)
+	assert.Equal(t, NewExistingLayer(1, []*Block{block2, block1}).Hash(), h, "layer hash should not depend on block order")
+
+	layers.AddBlock(block3)
+	expected := NewExistingLayer(1, []*Block{block1, block2, block3}).Hash()
+	assert.NotEqual(t, h, expected)
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case <-timeout:
+			t.Fatal("layer hash was not updated with the new block")
+		default:
+		}
+		if h, err = layers.GetLayerHash(1); err == nil && bytes.Equal(h, expected) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type replayValidatorMock struct {
+	layers []LayerID
+	blocks []*Block
+}
+
+func (m *replayValidatorMock) HandleIncomingLayer(layer *Layer) {
+	m.layers = append(m.layers, layer.Index())
+	m.blocks = append(m.blocks, layer.Blocks()...)
+}
+func (m *replayValidatorMock) HandleLateBlock(bl *Block)                             {}
+func (m *replayValidatorMock) RegisterLayerCallback(func(id LayerID))                {}
+func (m *replayValidatorMock) RegisterValidityCallback(func(id BlockID, valid bool)) {}
+
+// validityReplayMock changes the validity of the replayed blocks the way the tortoise does while it goes through
+// the layers again
+type validityReplayMock struct {
+	replayValidatorMock
+	validity func(id BlockID, valid bool)
+}
+
+func (m *validityReplayMock) HandleIncomingLayer(layer *Layer) {
+	m.replayValidatorMock.HandleIncomingLayer(layer)
+	for _, b := range layer.Blocks() {
+		m.validity(b.ID(), false)
+	}
+}
+func (m *validityReplayMock) RegisterValidityCallback(f func(id BlockID, valid bool)) { m.validity = f }
+
+func TestLayers_BootFromDisk(t *testing.T) {
+	bdb := database.NewMemDatabase()
+	ldb := database.NewMemDatabase()
+	cdb := database.NewMemDatabase()
+	layers := NewMesh(config.DefaultConfig(), ldb, bdb, cdb, &MeshValidatorMock{}, &MockState{}, log.New("t8", "", ""))
+
+	block1 := NewBlock(true, []byte("data1"), time.Now(), 0)
+	block2 := NewBlock(true, []byte("data2"), time.Now(), 1)
+	block3 := NewBlock(true, []byte("data3"), time.Now(), 2)
+	block4 := NewBlock(true, []byte("data4"), time.Now(), 2)
+	block5 := NewBlock(true, []byte("data5"), time.Now(), 3)
+	block5.AddView(block3.ID())
+	assert.NoError(t, layers.AddLayer(NewExistingLayer(0, []*Block{block1})))
+	layers.LayerCompleteCallback(0)
+	assert.NoError(t, layers.AddLayer(NewExistingLayer(1, []*Block{block2})))
+	layers.LayerCompleteCallback(1)
+	assert.NoError(t, layers.AddBlock(block3))
+	assert.NoError(t, layers.AddBlock(block4))
+	assert.NoError(t, layers.AddBlock(block5))
+	layers.SetLatestLayer(5)
+
+	trtl := &replayValidatorMock{}
+	restarted := NewMesh(config.DefaultConfig(), ldb, bdb, cdb, trtl, &MockState{}, log.New("t8", "", ""))
+	assert.Equal(t, layers.VerifiedLayer(), restarted.VerifiedLayer())
+	assert.Equal(t, layers.LatestLayer(), restarted.LatestLayer())
+	assert.Equal(t, layers.LatestReceivedLayer(), restarted.LatestReceivedLayer())
+	assert.ElementsMatch(t, layers.GetOrphanBlocks(), restarted.GetOrphanBlocks())
+	assert.ElementsMatch(t, []BlockID{block4.ID(), block5.ID()}, restarted.GetOrphanBlocks())
+	assert.Equal(t, []LayerID{0, 1}, trtl.layers, "verified layers should be replayed to the tortoise")
+}
+
+func TestLayers_BootNoReorg(t *testing.T) {
+	bdb := database.NewMemDatabase()
+	ldb := database.NewMemDatabase()
+	cdb := database.NewMemDatabase()
+	st := &recordingState{}
+	layers := NewMesh(config.DefaultConfig(), ldb, bdb, cdb, &MeshValidatorMock{}, st, log.New("t23", "", ""))
+	for i := 0; i <= 3; i++ {
+		b := NewBlock(true, []byte{byte(i)}, time.Now(), LayerID(i))
+		assert.NoError(t, layers.AddLayer(NewExistingLayer(LayerID(i), []*Block{b})))
+		layers.ContextualValidityCallback(b.ID(), true)
+	}
+	layers.LayerCompleteCallback(0)
+	layers.LayerCompleteCallback(1)
+	layers.LayerCompleteCallback(2)
+
+	trtl := &validityReplayMock{}
+	restarted := NewMesh(config.DefaultConfig(), ldb, bdb, cdb, trtl, st, log.New("t23", "", ""))
+	assert.Equal(t, []LayerID{0, 1, 2, 3}, trtl.layers, "the received layers should be replayed")
+	restarted.LayerCompleteCallback(3)
+	assert.Empty(t, st.resets, "validity changes while the layers are replayed should not cause a reorg")
+	assert.Equal(t, []state.LayerID{0, 1, 2, 3}, st.applied)
+
+	//decisions made after the replay are handled as before
+	b, err := restarted.GetLayer(1)
+	assert.NoError(t, err)
+	restarted.ContextualValidityCallback(b.Blocks()[0].ID(), true)
+	assert.True(t, restarted.reorgPending)
+}
+
+func TestLayers_BootEmpty(t *testing.T) {
+	trtl := &replayValidatorMock{}
+	layers := NewMesh(config.DefaultConfig(), database.NewMemDatabase(), database.NewMemDatabase(), database.NewMemDatabase(), trtl, &MockState{}, log.New("t9", "", ""))
+	assert.Equal(t, uint32(0), layers.VerifiedLayer())
+	assert.Equal(t, uint32(0), layers.LatestLayer())
+	assert.Empty(t, layers.GetOrphanBlocks())
+	assert.Empty(t, trtl.layers)
+}
+
+type recordingState struct {
+	txs      state.Transactions
+	miners   []address.Address
+	seed     []byte
+	applied  []state.LayerID
+	resets   []state.LayerID
+	latest   *state.LayerID
+	resetErr error
+}
+
+func (s *recordingState) ApplyTransactions(layer state.LayerID, seed []byte, miners []address.Address, txs state.Transactions) (uint32, error) {
+	if latest, err := s.LatestLayer(); err == nil && layer <= latest {
+		return 0, fmt.Errorf("layer %v was applied", layer)
+	}
+	s.txs = append(s.txs, txs...)
+	s.miners = append(s.miners, miners...)
+	s.seed = seed
+	s.applied = append(s.applied, layer)
+	s.latest = &layer
+	return 0, nil
+}
+
+func (s *recordingState) Reset(layer state.LayerID) error {
+	if s.resetErr != nil {
+		return s.resetErr
+	}
+	s.resets = append(s.resets, layer)
+	s.latest = &layer
+	return nil
+}
+
+// LatestLayer returns the layer the state was last applied or reverted to
+func (s *recordingState) LatestLayer() (state.LayerID, error) {
+	if s.latest == nil {
+		return 0, errors.New("no layer was applied")
+	}
+	return *s.latest, nil
+}
+
+func TestLayers_ContextualValidity(t *testing.T) {
+	st := &recordingState{}
+	layers := NewMesh(config.DefaultConfig(), database.NewMemDatabase(), database.NewMemDatabase(), database.NewMemDatabase(), &MeshValidatorMock{}, st, log.New("t10", "", ""))
+	defer layers.Close()
+
+	origins := make([]address.Address, 0, 3)
+	signedTx := func(dst byte) *SerializableTransaction {
+		priv, pub, err := crypto.GenerateKeyPair()
+		assert.NoError(t, err)
+		tx := NewSerializableTransaction(0, address.BytesToAddress([]byte{dst}), big.NewInt(10), big.NewInt(1), 10)
+		assert.NoError(t, tx.Sign(priv))
+		origins = append(origins, address.PublicKeyToAddress(pub.Bytes()))
+		return tx
+	}
+	block1 := NewBlock(true, []byte("data1"), time.Now(), 1)
+	block1.Coinbase = address.BytesToAddress([]byte{0x11})
+	block1.AddTransaction(signedTx(0x02))
+	block2 := NewBlock(true, []byte("data2"), time.Now(), 1)
+	block2.Coinbase = address.BytesToAddress([]byte{0x12})
+	block2.AddTransaction(signedTx(0x04))
+	block3 := NewBlock(true, []byte("data3"), time.Now(), 1)
+	block3.Coinbase = address.BytesToAddress([]byte{0x13})
+	block3.AddTransaction(signedTx(0x06))
+	block3.AddTransaction(NewSerializableTransaction(0, address.BytesToAddress([]byte{0x08}), big.NewInt(10), big.NewInt(1), 10))
+
+	_, err := layers.GetContextualValidity(block1.ID())
+	assert.Error(t, err, "undecided block should have no contextual validity")
+
+	layers.ContextualValidityCallback(block1.ID(), true)
+	layers.ContextualValidityCallback(block2.ID(), false)
+	v, err := layers.GetContextualValidity(block1.ID())
+	assert.NoError(t, err)
+	assert.True(t, v)
+	v, err = layers.GetContextualValidity(block2.ID())
+	assert.NoError(t, err)
+	assert.False(t, v)
+
+	assert.NoError(t, layers.AddLayer(NewExistingLayer(1, []*Block{block1, block2, block3})))
+	layers.LayerCompleteCallback(1)
+
+	applied := make([]address.Address, 0, len(st.txs))
+	for _, tx := range st.txs {
+		applied = append(applied, tx.Origin)
+	}
+	assert.ElementsMatch(t, []address.Address{origins[0]}, applied, "transactions of invalid and undecided blocks and unsigned transactions should not be applied")
+	assert.ElementsMatch(t, []address.Address{block1.Coinbase}, st.miners, "only miners of valid blocks should be rewarded")
+	assert.Equal(t, calcLayerHash([]BlockID{block1.ID()}), st.seed, "the ordering seed should be derived from the valid blocks only")
+}
+
+func TestLayers_ZeroCoinbaseIsNotRewarded(t *testing.T) {
+	st := &recordingState{}
+	layers := NewMesh(config.DefaultConfig(), database.NewMemDatabase(), database.NewMemDatabase(), database.NewMemDatabase(), &MeshValidatorMock{}, st, log.New("t24", "", ""))
+	defer layers.Close()
+
+	genesis := &Block{LayerIndex: 0, Data: []byte("genesis")}
+	genesis.Id = genesis.CalcId()
+	noCoinbase := NewBlock(true, []byte("data1"), time.Now(), 1)
+	rewarded := NewBlock(true, []byte("data2"), time.Now(), 1)
+	rewarded.Coinbase = address.BytesToAddress([]byte{0x11})
+	rewarded.Id = rewarded.CalcId()
+
+	assert.NoError(t, layers.AddLayer(NewExistingLayer(0, []*Block{genesis})))
+	assert.NoError(t, layers.AddLayer(NewExistingLayer(1, []*Block{noCoinbase, rewarded})))
+	for _, b := range []*Block{genesis, noCoinbase, rewarded} {
+		layers.ContextualValidityCallback(b.ID(), true)
+	}
+
+	layers.LayerCompleteCallback(0)
+	assert.Equal(t, []state.LayerID{0}, st.applied)
+	assert.Empty(t, st.miners, "the genesis block has no miner to reward")
+
+	layers.LayerCompleteCallback(1)
+	assert.Equal(t, []state.LayerID{0, 1}, st.applied)
+	assert.Equal(t, []address.Address{rewarded.Coinbase}, st.miners, "blocks without a coinbase should not be rewarded")
+	assert.Equal(t, calcLayerHash([]BlockID{noCoinbase.ID(), rewarded.ID()}), st.seed, "blocks without a coinbase are still valid")
+}
+
+func TestLayers_SkipAppliedLayers(t *testing.T) {
+	imported := state.LayerID(3)
+	st := &recordingState{latest: &imported}
+	layers := NewMesh(config.DefaultConfig(), database.NewMemDatabase(), database.NewMemDatabase(), database.NewMemDatabase(), &MeshValidatorMock{}, st, log.New("t22", "", ""))
+	defer layers.Close()
+	applied := layers.Subscribe(10, LayerApplied)
+
+	for i := 1; i <= 5; i++ {
+		assert.NoError(t, layers.AddLayer(NewExistingLayer(LayerID(i), []*Block{NewBlock(true, []byte{byte(i)}, time.Now(), LayerID(i))})))
+		layers.LayerCompleteCallback(LayerID(i))
+	}
+	assert.Equal(t, []state.LayerID{4, 5}, st.applied, "layers that are part of the imported state should not be applied")
+	assert.Equal(t, uint32(5), layers.VerifiedLayer())
+	assert.Equal(t, Event{Type: LayerApplied, Layer: 4}, <-applied)
+	assert.Equal(t, Event{Type: LayerApplied, Layer: 5}, <-applied)
+}
+
+func TestLayers_Reorg(t *testing.T) {
+	st := &recordingState{}
+	layers := NewMesh(config.DefaultConfig(), database.NewMemDatabase(), database.NewMemDatabase(), database.NewMemDatabase(), &MeshValidatorMock{}, st, log.New("t20", "", ""))
+	defer layers.Close()
+	reorgs := layers.Subscribe(10, Reorg)
+
+	blocks := make([]*Block, 0, 5)
+	for i := 1; i <= 5; i++ {
+		b := NewBlock(true, []byte{byte(i)}, time.Now(), LayerID(i))
+		b.Coinbase = address.BytesToAddress([]byte{byte(i)})
+		blocks = append(blocks, b)
+		assert.NoError(t, layers.AddLayer(NewExistingLayer(LayerID(i), []*Block{b})))
+		layers.ContextualValidityCallback(b.ID(), true)
+	}
+	layers.LayerCompleteCallback(1)
+	layers.LayerCompleteCallback(2)
+	assert.Empty(t, st.resets)
+
+	//only decisions that change the validity of a block in an applied layer cause a reorg
+	layers.ContextualValidityCallback(blocks[1].ID(), true)
+	layers.ContextualValidityCallback(blocks[3].ID(), false)
+	layers.LayerCompleteCallback(3)
+	assert.Empty(t, st.resets)
+	assert.Equal(t, []state.LayerID{1, 2, 3}, st.applied)
+
+	layers.ContextualValidityCallback(blocks[1].ID(), false)
+	layers.ContextualValidityCallback(blocks[0].ID(), false)
+	assert.Empty(t, st.resets, "the reorg should wait for the layer that changed the validity to complete")
+
+	st.miners = nil
+	layers.LayerCompleteCallback(4)
+	assert.Equal(t, []state.LayerID{0}, st.resets, "the state should be reverted to the layer before the first changed layer")
+	assert.Equal(t, []state.LayerID{1, 2, 3, 1, 2, 3, 4}, st.applied)
+	assert.Equal(t, []address.Address{blocks[2].Coinbase}, st.miners, "miners of blocks that became invalid should not be rewarded")
+	select {
+	case ev := <-reorgs:
+		assert.Equal(t, Event{Type: Reorg, Layer: 1, Layers: []LayerID{1, 2, 3}}, ev)
+	case <-time.After(time.Second):
+		t.Fatal("no reorg event")
+	}
+
+	layers.LayerCompleteCallback(5)
+	assert.Len(t, st.resets, 1, "a reorg should only be done once")
+}
+
+func TestLayers_PruneByLayerCount(t *testing.T) {
+	bdb := database.NewMemDatabase()
+	ldb := database.NewMemDatabase()
+	cdb := database.NewMemDatabase()
+	layers := NewMesh(config.Config{RetainLayers: 2}, ldb, bdb, cdb, &MeshValidatorMock{}, &MockState{}, log.New("t11", "", ""))
+
+	blocks := make([]*Block, 0, 4)
+	for i := 0; i < 4; i++ {
+		b := NewBlock(true, []byte{byte(i)}, time.Now(), LayerID(i))
+		if i > 0 {
+			b.AddVote(blocks[i-1].ID())
+			b.AddView(blocks[i-1].ID())
+		}
+		blocks = append(blocks, b)
+		assert.NoError(t, layers.AddLayer(NewExistingLayer(LayerID(i), []*Block{b})))
+	}
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		layers.LayerCompleteCallback(LayerID(i))
+	}
+
+	for i, b := range blocks {
+		_, err := layers.GetBlock(b.ID())
+		if i < 2 {
+			assert.Error(t, err, "block of layer %v should be pruned", i)
+		} else {
+			assert.NoError(t, err, "block of layer %v should be kept", i)
+		}
+		ids, err := layers.GetLayerBlockIDs(LayerID(i))
+		assert.NoError(t, err)
+		assert.Equal(t, []BlockID{b.ID()}, ids)
+		hash, err := layers.GetLayerHash(LayerID(i))
+		assert.NoError(t, err)
+		assert.Equal(t, NewExistingLayer(LayerID(i), []*Block{b}).Hash(), hash)
+	}
+
+	trtl := &replayValidatorMock{}
+	restarted := NewMesh(config.Config{RetainLayers: 2}, ldb, bdb, cdb, trtl, &MockState{}, log.New("t11", "", ""))
+	assert.Equal(t, []LayerID{0, 1, 2, 3}, trtl.layers, "pruned layers should be replayed as well")
+	assert.Equal(t, uint32(3), restarted.VerifiedLayer())
+	for i, b := range trtl.blocks {
+		assert.Equal(t, blocks[i].ID(), b.ID())
+		assert.ElementsMatch(t, blocks[i].BlockVotes, b.BlockVotes, "the votes of block %v should be replayed", i)
+		assert.ElementsMatch(t, blocks[i].ViewEdges, b.ViewEdges)
+	}
+	assert.Nil(t, trtl.blocks[0].Data, "the data of pruned blocks should not be kept")
+
+	//pruning a layer again, e.g. after a failed attempt, keeps the votes of the blocks deleted before
+	assert.NoError(t, restarted.pruneLayer(1))
+	l, err := restarted.getPrunedLayer(1)
+	assert.NoError(t, err)
+	assert.Len(t, l.Blocks(), 1)
+	assert.ElementsMatch(t, blocks[1].BlockVotes, l.Blocks()[0].BlockVotes)
+}
+
+func TestLayers_ReorgOfPrunedLayer(t *testing.T) {
+	st := &recordingState{}
+	layers := NewMesh(config.Config{RetainLayers: 1}, database.NewMemDatabase(), database.NewMemDatabase(), database.NewMemDatabase(), &MeshValidatorMock{}, st, log.New("t21", "", ""))
+	defer layers.Close()
+
+	blocks := make([]*Block, 0, 4)
+	for i := 0; i < 4; i++ {
+		b := NewBlock(true, []byte{byte(i)}, time.Now(), LayerID(i))
+		blocks = append(blocks, b)
+		assert.NoError(t, layers.AddLayer(NewExistingLayer(LayerID(i), []*Block{b})))
+		layers.ContextualValidityCallback(b.ID(), true)
+	}
+	time.Sleep(100 * time.Millisecond)
+	layers.LayerCompleteCallback(1)
+	layers.LayerCompleteCallback(2)
+	assert.Equal(t, LayerID(2), layers.prunedBefore())
+
+	//the window is left empty, a decision on a pruned block can not be reorganized
+	layers.ContextualValidityCallback(blocks[1].ID(), false)
+	layers.LayerCompleteCallback(3)
+	assert.Empty(t, st.resets)
+	assert.Equal(t, []state.LayerID{1, 2, 3}, st.applied, "the following layers should still be applied")
+}
+
+func TestLayers_ReorgWindowIsNotPruned(t *testing.T) {
+	st := &recordingState{}
+	layers := NewMesh(config.Config{RetainLayers: 1, ReorgWindow: 2}, database.NewMemDatabase(), database.NewMemDatabase(), database.NewMemDatabase(), &MeshValidatorMock{}, st, log.New("t22", "", ""))
+	defer layers.Close()
+
+	blocks := make([]*Block, 0, 5)
+	for i := 0; i < 5; i++ {
+		b := NewBlock(true, []byte{byte(i)}, time.Now(), LayerID(i))
+		blocks = append(blocks, b)
+		assert.NoError(t, layers.AddLayer(NewExistingLayer(LayerID(i), []*Block{b})))
+		layers.ContextualValidityCallback(b.ID(), true)
+	}
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		layers.LayerCompleteCallback(LayerID(i))
+	}
+	assert.Equal(t, LayerID(2), layers.prunedBefore(), "the layers of the reorg window should be kept")
+
+	layers.ContextualValidityCallback(blocks[2].ID(), false)
+	layers.LayerCompleteCallback(4)
+	assert.Equal(t, []state.LayerID{1}, st.resets)
+	assert.Equal(t, []state.LayerID{0, 1, 2, 3, 2, 3, 4}, st.applied)
+}
+
+func TestLayers_FailedReorgIsRetried(t *testing.T) {
+	st := &recordingState{}
+	layers := NewMesh(config.DefaultConfig(), database.NewMemDatabase(), database.NewMemDatabase(), database.NewMemDatabase(), &MeshValidatorMock{}, st, log.New("t23", "", ""))
+	defer layers.Close()
+
+	blocks := make([]*Block, 0, 4)
+	for i := 1; i <= 4; i++ {
+		b := NewBlock(true, []byte{byte(i)}, time.Now(), LayerID(i))
+		blocks = append(blocks, b)
+		assert.NoError(t, layers.AddLayer(NewExistingLayer(LayerID(i), []*Block{b})))
+		layers.ContextualValidityCallback(b.ID(), true)
+	}
+	layers.LayerCompleteCallback(1)
+	layers.LayerCompleteCallback(2)
+
+	layers.ContextualValidityCallback(blocks[0].ID(), false)
+	st.resetErr = errors.New("state is not available")
+	layers.LayerCompleteCallback(3)
+	assert.Equal(t, []state.LayerID{1, 2}, st.applied, "no layer should be applied on top of a state that could not be reorganized")
+	assert.Equal(t, uint32(2), layers.VerifiedLayer(), "the verified layer should not pass a layer that was not applied")
+	verified, err := layers.getLayerPointer(verifiedLayerKey)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), verified)
+
+	st.resetErr = nil
+	layers.LayerCompleteCallback(4)
+	assert.Equal(t, []state.LayerID{0}, st.resets)
+	assert.Equal(t, []state.LayerID{1, 2, 1, 2, 3, 4}, st.applied, "the skipped layer should be applied once the reorg succeeds")
+	assert.Equal(t, uint32(4), layers.VerifiedLayer())
+}
+
+func TestLayers_PruneByAge(t *testing.T) {
+	layers := NewMesh(config.Config{RetainAge: time.Hour}, database.NewMemDatabase(), database.NewMemDatabase(), database.NewMemDatabase(), &MeshValidatorMock{}, &MockState{}, log.New("t12", "", ""))
+
+	old := NewBlock(true, []byte("old"), time.Now().Add(-2*time.Hour), 0)
+	recent := NewBlock(true, []byte("recent"), time.Now(), 1)
+	last := NewBlock(true, []byte("last"), time.Now(), 2)
+	assert.NoError(t, layers.AddLayer(NewExistingLayer(0, []*Block{old})))
+	assert.NoError(t, layers.AddLayer(NewExistingLayer(1, []*Block{recent})))
+	assert.NoError(t, layers.AddLayer(NewExistingLayer(2, []*Block{last})))
+	time.Sleep(100 * time.Millisecond)
+	layers.LayerCompleteCallback(0)
+	layers.LayerCompleteCallback(1)
+	layers.LayerCompleteCallback(2)
+
+	_, err := layers.GetBlock(old.ID())
+	assert.Error(t, err, "old block should be pruned")
+	_, err = layers.GetBlock(recent.ID())
+	assert.NoError(t, err)
+	_, err = layers.GetBlock(last.ID())
+	assert.NoError(t, err)
+}
+
+func TestLayers_NoRetention(t *testing.T) {
+	layers := getMesh("t13")
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, layers.AddLayer(NewExistingLayer(LayerID(i), []*Block{NewBlock(true, []byte{byte(i)}, time.Now().Add(-time.Hour), LayerID(i))})))
+	}
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		layers.LayerCompleteCallback(LayerID(i))
+	}
+	for i := 0; i < 3; i++ {
+		_, err := layers.GetLayer(LayerID(i))
+		assert.NoError(t, err)
+	}
+}
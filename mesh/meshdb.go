@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"github.com/davecgh/go-xdr/xdr2"
+	"github.com/spacemeshos/go-spacemesh/common"
 	"github.com/spacemeshos/go-spacemesh/database"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"sync"
@@ -20,8 +22,8 @@ type meshDB struct {
 	layers             database.DB
 	blocks             database.DB
 	contextualValidity database.DB //map blockId to contextualValidation state of block
-	orphanBlocks       map[LayerID]map[BlockID]struct{}
-	orphanBlockCount   int32
+	orphans            *orphanIndex
+	equivocations      *equivocations
 	layerHandlers      map[LayerID]*layerHandler
 	lhMutex            sync.Mutex
 }
@@ -31,7 +33,8 @@ func NewMeshDB(layers, blocks, validity database.DB) *meshDB {
 		blocks:             blocks,
 		layers:             layers,
 		contextualValidity: validity,
-		orphanBlocks:       make(map[LayerID]map[BlockID]struct{}),
+		orphans:            newOrphanIndex(),
+		equivocations:      newEquivocations(),
 		layerHandlers:      make(map[LayerID]*layerHandler),
 	}
 	return ll
@@ -45,6 +48,23 @@ func (m *meshDB) Close() {
 }
 
 func (m *meshDB) getLayer(index LayerID) (*Layer, error) {
+	blockIds, err := m.getLayerBlockIds(index)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, err := m.getLayerBlocks(blockIds)
+	if err != nil {
+		return nil, errors.New("could not get all blocks from database ")
+	}
+
+	l := NewLayer(LayerID(index))
+	l.SetBlocks(blocks)
+
+	return l, nil
+}
+
+func (m *meshDB) getLayerBlockIds(index LayerID) (map[BlockID]bool, error) {
 	ids, err := m.layers.Get(index.ToBytes())
 	if err != nil {
 		return nil, fmt.Errorf("error getting layer %v from database ", index)
@@ -54,16 +74,88 @@ func (m *meshDB) getLayer(index LayerID) (*Layer, error) {
 	if err != nil {
 		return nil, errors.New("could not get all blocks from database ")
 	}
+	return blockIds, nil
+}
 
-	blocks, err := m.getLayerBlocks(blockIds)
+// pruneLayer deletes the bodies of the layer blocks and the id of the first block of every miner in the layer,
+// the layer block ids and hash are kept. the votes and view edges of the blocks are kept as well since
+// the tortoise is rebuilt from all the verified layers when the mesh boots
+func (m *meshDB) pruneLayer(index LayerID) error {
+	b, err := m.layers.Get(index.ToBytes())
 	if err != nil {
-		return nil, errors.New("could not get all blocks from database ")
+		return nil //no blocks were stored for the layer
+	}
+	ids, err := bytesToBlockIds(b)
+	if err != nil {
+		return err
+	}
+	if err := m.writePrunedLayer(index, ids); err != nil {
+		return fmt.Errorf("could not keep the votes of layer %v %v", index, err)
+	}
+	//the miners are taken from the kept blocks, they include the blocks whose bodies a previous attempt deleted
+	pruned, err := m.getPrunedLayer(index)
+	if err != nil {
+		return err
+	}
+	for _, b := range pruned.Blocks() {
+		if b.MinerID == "" {
+			continue
+		}
+		if err := m.layers.Delete(minerBlockKey(minerLayer{Layer: index, MinerID: b.MinerID})); err != nil {
+			return err
+		}
+	}
+	for id := range ids {
+		if err := m.blocks.Delete(id.ToBytes()); err != nil {
+			return fmt.Errorf("could not delete block %v of layer %v %v", id, index, err)
+		}
 	}
+	return nil
+}
 
-	l := NewLayer(LayerID(index))
-	l.SetBlocks(blocks)
+// writePrunedLayer stores the blocks of the layer without their transactions and data, the blocks of a
+// previous attempt to prune the layer whose bodies were already deleted are kept
+func (m *meshDB) writePrunedLayer(index LayerID, ids map[BlockID]bool) error {
+	pruned := make([]Block, 0, len(ids))
+	if l, err := m.getPrunedLayer(index); err == nil {
+		for _, b := range l.Blocks() {
+			pruned = append(pruned, *b)
+		}
+	}
+	for id := range ids {
+		b, err := m.getBlock(id)
+		if err != nil {
+			continue
+		}
+		pruned = append(pruned, Block{Id: b.Id, LayerIndex: b.LayerIndex, MinerID: b.MinerID, BlockVotes: b.BlockVotes, ViewEdges: b.ViewEdges})
+	}
+	var w bytes.Buffer
+	if _, err := xdr.Marshal(&w, &pruned); err != nil {
+		return err
+	}
+	return m.layers.Put(prunedLayerKey(index), w.Bytes())
+}
 
-	return l, nil
+// getPrunedLayer returns the blocks of a pruned layer as they were kept by pruneLayer
+func (m *meshDB) getPrunedLayer(index LayerID) (*Layer, error) {
+	b, err := m.layers.Get(prunedLayerKey(index))
+	if err != nil {
+		return nil, fmt.Errorf("no votes were kept for pruned layer %v", index)
+	}
+	var pruned []Block
+	if _, err := xdr.Unmarshal(bytes.NewReader(b), &pruned); err != nil {
+		return nil, err
+	}
+	blocks := make([]*Block, 0, len(pruned))
+	seen := make(map[BlockID]struct{}, len(pruned))
+	for i := range pruned {
+		if _, ok := seen[pruned[i].Id]; ok {
+			continue
+		}
+		seen[pruned[i].Id] = struct{}{}
+		blocks = append(blocks, &pruned[i])
+	}
+	return NewExistingLayer(index, blocks), nil
 }
 
 // addBlock adds a new block to block DB and updates the correct layer with the new block
@@ -72,7 +164,11 @@ func (m *meshDB) addBlock(block *Block) error {
 	_, err := m.blocks.Get(block.ID().ToBytes())
 	if err == nil {
 		log.Debug("block ", block.ID(), " already exists in database")
-		return errors.New("block " + string(block.ID()) + " already exists in database")
+		return fmt.Errorf("block %v already exists in database", block.ID())
+	}
+	//the body is written before returning so the block can be loaded right away, e.g. as the first block of an equivocation
+	if err := m.writeBlock(block); err != nil {
+		return err
 	}
 
 	layerHandler := m.getLayerHandler(block.LayerIndex, 1)
@@ -94,40 +190,51 @@ func (m *meshDB) getBlock(id BlockID) (*Block, error) {
 
 func (m *meshDB) getContextualValidity(id BlockID) (bool, error) {
 	b, err := m.contextualValidity.Get(id.ToBytes())
-	return b[0] == 1, err //bytes to bool
+	if err != nil {
+		return false, fmt.Errorf("no contextual validity for block %v", id)
+	}
+	if len(b) != 1 {
+		return false, fmt.Errorf("corrupted contextual validity for block %v", id)
+	}
+	return b[0] == 1, nil //bytes to bool
 }
 
 func (m *meshDB) setContextualValidity(id BlockID, valid bool) error {
-	//todo implement
 	//todo concurrency
-	var v []byte
+	v := FALSE
 	if valid {
 		v = TRUE
 	}
-	m.contextualValidity.Put(id.ToBytes(), v)
-	return nil
+	return m.contextualValidity.Put(id.ToBytes(), v)
 }
 
+// addLayer writes the blocks of the layer before it returns, so the layer can be validated and applied right away
 //todo this overwrites the previous value if it exists
 func (m *meshDB) addLayer(layer *Layer) error {
-	layerHandler := m.getLayerHandler(layer.index, int32(len(layer.blocks)))
 	ids := make(map[BlockID]bool)
 	for _, b := range layer.blocks {
 		ids[b.Id] = true
+		if err := m.writeBlock(b); err != nil {
+			return err
+		}
 	}
 
-	//add blocks to mDB
-	for _, b := range layer.blocks {
-		layerHandler.ch <- b
-	}
+	//todo recover
+	return m.writeLayerIds(layer.Index(), ids)
+}
 
-	w, err := blockIdsAsBytes(ids)
+// writeBlock stores the block body, a block that is already stored is not written again
+func (m *meshDB) writeBlock(bl *Block) error {
+	if b, err := m.blocks.Get(bl.ID().ToBytes()); err == nil && b != nil {
+		return nil
+	}
+	bytes, err := BlockAsBytes(*bl)
 	if err != nil {
-		//todo recover
-		return errors.New("could not encode layer block ids")
+		return fmt.Errorf("could not encode block %v %v", bl.ID(), err)
+	}
+	if err := m.blocks.Put(bl.ID().ToBytes(), bytes); err != nil {
+		return fmt.Errorf("could not add block %v to database %v", bl.ID(), err)
 	}
-
-	m.layers.Put(layer.Index().ToBytes(), w)
 	return nil
 }
 
@@ -146,11 +253,121 @@ func (m *meshDB) updateLayerIds(block *Block) error {
 	}
 	log.Info("added block %v to layer %v", block.ID(), block.LayerIndex)
 	blockIds[block.ID()] = true
-	w, err := blockIdsAsBytes(blockIds)
+	return m.writeLayerIds(block.LayerIndex, blockIds)
+}
+
+// writeLayerIds stores the block ids of the layer together with the layer hash
+func (m *meshDB) writeLayerIds(index LayerID, ids map[BlockID]bool) error {
+	w, err := blockIdsAsBytes(ids)
 	if err != nil {
 		return errors.New("could not encode layer block ids")
 	}
-	m.layers.Put(block.LayerIndex.ToBytes(), w)
+	if err := m.layers.Put(index.ToBytes(), w); err != nil {
+		return err
+	}
+	return m.layers.Put(layerHashKey(index), layerHash(ids))
+}
+
+func (m *meshDB) getLayerHash(index LayerID) ([]byte, error) {
+	h, err := m.layers.Get(layerHashKey(index))
+	if err != nil {
+		return nil, fmt.Errorf("error getting layer %v hash from database ", index)
+	}
+	return h, nil
+}
+
+func layerHash(ids map[BlockID]bool) []byte {
+	keys := make([]BlockID, 0, len(ids))
+	for id := range ids {
+		keys = append(keys, id)
+	}
+	return calcLayerHash(keys)
+}
+
+func (m *meshDB) setLayerPointer(key []byte, l uint32) error {
+	return m.layers.Put(key, common.Uint32ToBytes(l))
+}
+
+func (m *meshDB) getLayerPointer(key []byte) (uint32, error) {
+	b, err := m.layers.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) != 4 {
+		return 0, fmt.Errorf("corrupted layer pointer %s", key)
+	}
+	return common.BytesToUint32(b), nil
+}
+
+// addOrphan adds the block to the orphans and persists the orphans of the layers that changed,
+// blocks of miners that built more than one block in the layer are not added
+func (m *meshDB) addOrphan(block *Block) error {
+	m.equivocations.RLock()
+	defer m.equivocations.RUnlock()
+	if _, ok := m.equivocations.excluded[block.ID()]; ok {
+		return nil
+	}
+	m.orphans.Lock()
+	defer m.orphans.Unlock()
+	_, known := m.orphans.layers[block.LayerIndex]
+	changed := m.orphans.update(block)
+	return m.writeOrphanLayers(changed, !known)
+}
+
+// writeOrphanLayers persists the orphans of the given layers and, if the set of layers that have
+// orphans changed, the list of those layers. callers must hold the orphan lock
+func (m *meshDB) writeOrphanLayers(changed map[LayerID]struct{}, layersChanged bool) error {
+	for l := range changed {
+		ids := m.orphans.layerBlocksLocked(l)
+		if len(ids) == 0 {
+			layersChanged = true
+			if err := m.layers.Delete(orphanLayerKey(l)); err != nil {
+				return err
+			}
+			continue
+		}
+		b, err := blockIdListAsBytes(ids)
+		if err != nil {
+			return err
+		}
+		if err := m.layers.Put(orphanLayerKey(l), b); err != nil {
+			return err
+		}
+	}
+	if !layersChanged {
+		return nil
+	}
+	b, err := layerIdListAsBytes(m.orphans.layerIds())
+	if err != nil {
+		return err
+	}
+	return m.layers.Put(orphanLayersKey, b)
+}
+
+func (m *meshDB) loadOrphans() error {
+	b, err := m.layers.Get(orphanLayersKey)
+	if err != nil {
+		return nil //no orphans were persisted
+	}
+	layers, err := bytesToLayerIdList(b)
+	if err != nil {
+		return err
+	}
+	m.orphans.Lock()
+	defer m.orphans.Unlock()
+	for _, l := range layers {
+		b, err := m.layers.Get(orphanLayerKey(l))
+		if err != nil {
+			return fmt.Errorf("missing orphans of layer %v", l)
+		}
+		ids, err := bytesToBlockIdList(b)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			m.orphans.add(id, l)
+		}
+	}
 	return nil
 }
 
@@ -160,7 +377,7 @@ func (m *meshDB) getLayerBlocks(ids map[BlockID]bool) ([]*Block, error) {
 	for k, _ := range ids {
 		block, err := m.getBlock(k)
 		if err != nil {
-			return nil, errors.New("could not retrive block " + string(k))
+			return nil, fmt.Errorf("could not retrive block %v", k)
 		}
 		blocks = append(blocks, block)
 	}
@@ -173,22 +390,9 @@ func (m *meshDB) handleLayerBlocks(ll *layerHandler) {
 		select {
 		case bl := <-ll.ch:
 			atomic.AddInt32(&ll.pendingCount, -1)
-			bytes, err := BlockAsBytes(*bl)
-			if err != nil {
-				log.Error("could not encode bl")
-				continue
-			}
-			if b, err := m.blocks.Get(bl.ID().ToBytes()); err == nil && b != nil {
-				log.Error("bl ", bl.ID(), " already in database ")
-				continue
+			if err := m.updateLayerIds(bl); err != nil {
+				log.Error("could not add block ", bl.ID(), " to its layer ", err)
 			}
-
-			if err := m.blocks.Put(bl.ID().ToBytes(), bytes); err != nil {
-				log.Error("could not add bl to ", bl.ID(), " database ", err)
-				continue
-			}
-
-			m.updateLayerIds(bl)
 			m.tryDeleteHandler(ll) //try delete handler when done to avoid leak
 		}
 	}
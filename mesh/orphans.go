@@ -0,0 +1,106 @@
+package mesh
+
+import (
+	"sync"
+)
+
+// orphanIndex tracks the blocks that are not yet referenced by the view of any other block.
+// blocks are indexed by id so adding a block and removing the blocks it views costs O(1) per block
+type orphanIndex struct {
+	sync.RWMutex
+	layers map[LayerID]map[BlockID]struct{}
+	blocks map[BlockID]LayerID
+}
+
+func newOrphanIndex() *orphanIndex {
+	return &orphanIndex{
+		layers: make(map[LayerID]map[BlockID]struct{}),
+		blocks: make(map[BlockID]LayerID),
+	}
+}
+
+// add inserts id into the index, callers must hold the write lock
+func (o *orphanIndex) add(id BlockID, layer LayerID) {
+	if _, ok := o.layers[layer]; !ok {
+		o.layers[layer] = make(map[BlockID]struct{})
+	}
+	o.layers[layer][id] = struct{}{}
+	o.blocks[id] = layer
+}
+
+// remove deletes id from the index and returns its layer, callers must hold the write lock
+func (o *orphanIndex) remove(id BlockID) (LayerID, bool) {
+	layer, ok := o.blocks[id]
+	if !ok {
+		return 0, false
+	}
+	delete(o.blocks, id)
+	delete(o.layers[layer], id)
+	if len(o.layers[layer]) == 0 {
+		delete(o.layers, layer)
+	}
+	return layer, true
+}
+
+// update adds the block as an orphan and removes the blocks in its view,
+// it returns the layers whose orphans changed. callers must hold the write lock
+func (o *orphanIndex) update(block *Block) map[LayerID]struct{} {
+	changed := make(map[LayerID]struct{})
+	o.add(block.ID(), block.LayerIndex)
+	changed[block.LayerIndex] = struct{}{}
+	for _, id := range block.ViewEdges {
+		if l, ok := o.remove(id); ok {
+			changed[l] = struct{}{}
+		}
+	}
+	return changed
+}
+
+func (o *orphanIndex) layerBlocks(layer LayerID) []BlockID {
+	o.RLock()
+	defer o.RUnlock()
+	return o.layerBlocksLocked(layer)
+}
+
+func (o *orphanIndex) layerBlocksLocked(layer LayerID) []BlockID {
+	ids := make([]BlockID, 0, len(o.layers[layer]))
+	for id := range o.layers[layer] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// except returns the orphans of every layer but layer, the orphans are collected per layer so the blocks of
+// the excluded layer are not visited
+func (o *orphanIndex) except(layer LayerID) []BlockID {
+	o.RLock()
+	defer o.RUnlock()
+	ids := make([]BlockID, 0, len(o.blocks)-len(o.layers[layer]))
+	for l, blocks := range o.layers {
+		if l == layer {
+			continue
+		}
+		for id := range blocks {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (o *orphanIndex) all() []BlockID {
+	o.RLock()
+	defer o.RUnlock()
+	ids := make([]BlockID, 0, len(o.blocks))
+	for id := range o.blocks {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (o *orphanIndex) layerIds() []LayerID {
+	ids := make([]LayerID, 0, len(o.layers))
+	for l := range o.layers {
+		ids = append(ids, l)
+	}
+	return ids
+}
@@ -0,0 +1,78 @@
+package mesh
+
+import (
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/mesh/config"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOrphanIndex_Update(t *testing.T) {
+	o := newOrphanIndex()
+	block1 := NewExistingBlock(1, 1, nil)
+	block2 := NewExistingBlock(2, 1, nil)
+	block3 := NewExistingBlock(3, 2, nil)
+	block3.ViewEdges = []BlockID{1, 2, 7}
+
+	assert.Equal(t, map[LayerID]struct{}{1: {}}, o.update(block1))
+	assert.Equal(t, map[LayerID]struct{}{1: {}}, o.update(block2))
+	assert.ElementsMatch(t, []BlockID{1, 2}, o.layerBlocks(1))
+
+	assert.Equal(t, map[LayerID]struct{}{1: {}, 2: {}}, o.update(block3))
+	assert.Empty(t, o.layerBlocks(1))
+	assert.Equal(t, []LayerID{2}, o.layerIds(), "layers without orphans should be dropped")
+	assert.Equal(t, []BlockID{3}, o.all())
+	assert.Empty(t, o.except(2))
+
+	o.update(NewExistingBlock(4, 1, nil))
+	o.update(NewExistingBlock(5, 3, nil))
+	assert.ElementsMatch(t, []BlockID{3, 5}, o.except(1))
+	assert.ElementsMatch(t, []BlockID{4, 5}, o.except(2))
+	assert.ElementsMatch(t, []BlockID{3, 4, 5}, o.except(4), "a layer without orphans excludes nothing")
+}
+
+func TestMesh_ConcurrentOrphans(t *testing.T) {
+	ldb, bdb, cdb := database.NewMemDatabase(), database.NewMemDatabase(), database.NewMemDatabase()
+	layers := NewMesh(config.DefaultConfig(), ldb, bdb, cdb, &MeshValidatorMock{}, &MockState{}, log.New("t19", "", ""))
+
+	const perLayer = 50
+	base := make([]*Block, 0, perLayer)
+	for i := 0; i < perLayer; i++ {
+		b := NewBlock(true, []byte{byte(i)}, time.Now(), 1)
+		base = append(base, b)
+		assert.NoError(t, layers.AddBlock(b))
+	}
+	assert.Equal(t, perLayer, len(layers.GetOrphanBlocksByLayerId(1)))
+
+	//every block of layer 2 views one block of layer 1
+	var wg sync.WaitGroup
+	top := make([]BlockID, perLayer)
+	for i := 0; i < perLayer; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			b := NewBlock(true, []byte{byte(i)}, time.Now(), 2)
+			b.AddView(base[i].ID())
+			top[i] = b.ID()
+			assert.NoError(t, layers.AddBlock(b))
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Empty(t, layers.GetOrphanBlocksByLayerId(1))
+	assert.ElementsMatch(t, top, layers.GetOrphanBlocks())
+	assert.ElementsMatch(t, top, layers.GetOrphanBlocksExcept(1))
+	assert.Empty(t, layers.GetOrphanBlocksExcept(2))
+	b, err := ldb.Get(orphanLayersKey)
+	assert.NoError(t, err)
+	persisted, err := bytesToLayerIdList(b)
+	assert.NoError(t, err)
+	assert.Equal(t, []LayerID{2}, persisted, "the layer without orphans should be dropped from the persisted layers")
+
+	restarted := NewMesh(config.DefaultConfig(), ldb, bdb, cdb, &MeshValidatorMock{}, &MockState{}, log.New("t19", "", ""))
+	assert.ElementsMatch(t, top, restarted.GetOrphanBlocksExcept(1), "orphans should survive a restart")
+	assert.Empty(t, restarted.GetOrphanBlocksByLayerId(1))
+}
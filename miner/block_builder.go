@@ -2,63 +2,99 @@ package miner
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/spacemeshos/go-spacemesh/address"
-	"github.com/spacemeshos/go-spacemesh/common"
 	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/mempool"
 	"github.com/spacemeshos/go-spacemesh/mesh"
+	"github.com/spacemeshos/go-spacemesh/miner/metrics"
 	"github.com/spacemeshos/go-spacemesh/oracle"
 	"github.com/spacemeshos/go-spacemesh/p2p"
 	"github.com/spacemeshos/go-spacemesh/p2p/service"
 	"github.com/spacemeshos/go-spacemesh/state"
 	meshSync "github.com/spacemeshos/go-spacemesh/sync"
-	"math/big"
-	"math/rand"
 	"sync"
 	"time"
 )
 
 const MaxTransactionsPerBlock = 200 //todo: move to config
+const MaxGasPerBlock = 200 * DefaultGasLimit
+const MaxTransactionBytesPerBlock = 64 * 1024
 
 const DefaultGasLimit = 10
 const DefaultGas = 1
 
 const IncomingTxProtocol = "TxGossip"
 
+const producedLayers = 1000 //number of recent layers the produced blocks are counted for
+
+const maxAmountBytes = 32 //amounts and prices are 256 bit unsigned integers
+
+var (
+	errRecipient = errors.New("transaction has no recipient")
+	errAmount    = errors.New("transaction amount is out of range")
+	errPrice     = errors.New("transaction price is out of range")
+	errGasLimit  = errors.New("transaction gas limit exceeds the gas of a block")
+	errSignature = errors.New("invalid transaction signature")
+)
+
+// txRejectReasons labels the rejected transactions metric by the error a transaction was rejected with,
+// errors that are not listed here are counted as other
+var txRejectReasons = map[error]string{
+	errRecipient:          "recipient",
+	errAmount:             "amount",
+	errPrice:              "price",
+	errGasLimit:           "gas_limit",
+	errSignature:          "signature",
+	mempool.ErrOrigin:     "origin",
+	mempool.ErrNonceLow:   "nonce",
+	mempool.ErrFunds:      "funds",
+	mempool.ErrSenderFull: "sender_full",
+	mempool.ErrPoolFull:   "pool_full",
+}
+
 type BlockBuilder struct {
-	minerID string // could be a pubkey or what ever. the identity we're claiming to be as miners.
+	minerID  string // the public key of the miner, blocks are signed with the matching private key
+	coinbase address.Address
+	signer   Signer
 	log.Log
-	beginRoundEvent  chan mesh.LayerID
-	stopChan         chan struct{}
-	newTrans         chan *mesh.SerializableTransaction
-	txGossipChannel  chan service.GossipMessage
-	hareResult       HareResultProvider
-	transactionQueue []mesh.SerializableTransaction
-	mu               sync.Mutex
-	network          p2p.Service
-	weakCoinToss     WeakCoinProvider
-	orphans          OrphanBlockProvider
-	blockOracle      oracle.BlockOracle
-	started          bool
-}
-
-func NewBlockBuilder(minerID string, net p2p.Service, beginRoundEvent chan mesh.LayerID, weakCoin WeakCoinProvider,
-	orph OrphanBlockProvider, hare HareResultProvider, blockOracle oracle.BlockOracle, lg log.Log) BlockBuilder {
+	beginRoundEvent chan mesh.LayerID
+	stopChan        chan struct{}
+	txGossipChannel chan service.GossipMessage
+	hareResult      HareResultProvider
+	txPool          TransactionPool
+	mu              sync.Mutex
+	network         p2p.Service
+	weakCoinToss    WeakCoinProvider
+	orphans         OrphanBlockProvider
+	blockOracle     oracle.BlockOracle
+	started         bool
+	miningMu        sync.RWMutex //guards the coinbase and the mining state, mu is held while the loops are stopped
+	mining          bool
+	produced        map[mesh.LayerID]int
+}
+
+func NewBlockBuilder(minerID string, coinbase address.Address, signer Signer, net p2p.Service, beginRoundEvent chan mesh.LayerID, weakCoin WeakCoinProvider,
+	orph OrphanBlockProvider, hare HareResultProvider, blockOracle oracle.BlockOracle, txPool TransactionPool, lg log.Log) BlockBuilder {
 	return BlockBuilder{
-		minerID:          minerID,
-		Log:              lg,
-		beginRoundEvent:  beginRoundEvent,
-		stopChan:         make(chan struct{}),
-		newTrans:         make(chan *mesh.SerializableTransaction),
-		txGossipChannel:  net.RegisterGossipProtocol(IncomingTxProtocol),
-		hareResult:       hare,
-		transactionQueue: make([]mesh.SerializableTransaction, 0, 10),
-		mu:               sync.Mutex{},
-		network:          net,
-		weakCoinToss:     weakCoin,
-		orphans:          orph,
-		blockOracle:      blockOracle,
-		started:          false,
+		minerID:         minerID,
+		coinbase:        coinbase,
+		signer:          signer,
+		Log:             lg,
+		beginRoundEvent: beginRoundEvent,
+		stopChan:        make(chan struct{}),
+		txGossipChannel: net.RegisterGossipProtocol(IncomingTxProtocol),
+		hareResult:      hare,
+		txPool:          txPool,
+		mu:              sync.Mutex{},
+		network:         net,
+		weakCoinToss:    weakCoin,
+		orphans:         orph,
+		blockOracle:     blockOracle,
+		started:         false,
+		mining:          true,
+		produced:        make(map[mesh.LayerID]int),
 	}
 
 }
@@ -66,12 +102,12 @@ func NewBlockBuilder(minerID string, net p2p.Service, beginRoundEvent chan mesh.
 func Transaction2SerializableTransaction(tx *state.Transaction) mesh.SerializableTransaction {
 	return mesh.SerializableTransaction{
 		AccountNonce: tx.AccountNonce,
-		Origin:       tx.Origin,
 		Recipient:    tx.Recipient,
 		Amount:       tx.Amount.Bytes(),
 		Payload:      tx.Payload,
 		GasLimit:     tx.GasLimit,
 		Price:        tx.Price.Bytes(),
+		Signature:    tx.Signature,
 	}
 }
 
@@ -109,31 +145,123 @@ type WeakCoinProvider interface {
 
 type OrphanBlockProvider interface {
 	GetOrphanBlocksExcept(layer mesh.LayerID) []mesh.BlockID
+	GetLayerBlockIDs(layer mesh.LayerID) ([]mesh.BlockID, error)
+}
+
+type Signer interface {
+	Sign(m []byte) []byte
+}
+
+// TransactionPool holds the transactions that were not applied yet, blocks are filled with the transactions it selects
+type TransactionPool interface {
+	Add(tx *mesh.SerializableTransaction) error
+	Select(budget mempool.Budget) []mesh.SerializableTransaction
 }
 
 //used from external API call?
-func (t *BlockBuilder) AddTransaction(nonce uint64, origin, destination address.Address, amount *big.Int) error {
+func (t *BlockBuilder) AddTransaction(tx *mesh.SerializableTransaction) error {
 	if !t.started {
 		return fmt.Errorf("BlockBuilderStopped")
 	}
-	t.newTrans <- mesh.NewSerializableTransaction(nonce, origin, destination, amount, big.NewInt(DefaultGas), DefaultGasLimit)
+	if err := validateTx(tx); err != nil {
+		return err
+	}
+	return t.txPool.Add(tx)
+}
+
+// validateTx checks the fields of the transaction and its signature, the checks against the state of the sender are
+// done by the pool
+func validateTx(tx *mesh.SerializableTransaction) error {
+	if tx.Recipient == nil {
+		return errRecipient
+	}
+	if len(tx.Amount) > maxAmountBytes {
+		return errAmount
+	}
+	if len(tx.Price) > maxAmountBytes {
+		return errPrice
+	}
+	if tx.GasLimit > MaxGasPerBlock {
+		return errGasLimit
+	}
+	if _, err := tx.Origin(); err != nil {
+		return errSignature
+	}
 	return nil
 }
 
+// StartMining resumes building blocks in the layers the node is eligible for
+func (t *BlockBuilder) StartMining() {
+	t.miningMu.Lock()
+	defer t.miningMu.Unlock()
+	t.mining = true
+}
+
+// StopMining pauses building blocks without stopping the builder, transactions are still received
+func (t *BlockBuilder) StopMining() {
+	t.miningMu.Lock()
+	defer t.miningMu.Unlock()
+	t.mining = false
+}
+
+// MiningEligible returns false while mining is paused
+func (t *BlockBuilder) MiningEligible() bool {
+	t.miningMu.RLock()
+	defer t.miningMu.RUnlock()
+	return t.mining
+}
+
+// SetCoinbase sets the address that is recorded in the blocks built from now on
+func (t *BlockBuilder) SetCoinbase(addr address.Address) {
+	t.miningMu.Lock()
+	defer t.miningMu.Unlock()
+	t.coinbase = addr
+}
+
+func (t *BlockBuilder) Coinbase() address.Address {
+	t.miningMu.RLock()
+	defer t.miningMu.RUnlock()
+	return t.coinbase
+}
+
+// ProducedBlocks returns the number of blocks built in each of the recent layers this node built blocks in
+func (t *BlockBuilder) ProducedBlocks() map[mesh.LayerID]int {
+	t.miningMu.RLock()
+	defer t.miningMu.RUnlock()
+	res := make(map[mesh.LayerID]int, len(t.produced))
+	for l, n := range t.produced {
+		res[l] = n
+	}
+	return res
+}
+
+func (t *BlockBuilder) recordBlock(id mesh.LayerID) {
+	t.miningMu.Lock()
+	defer t.miningMu.Unlock()
+	t.produced[id]++
+	//layers without blocks leave gaps, every layer that fell out of the window is dropped
+	for l := range t.produced {
+		if l+producedLayers <= id {
+			delete(t.produced, l)
+		}
+	}
+}
+
 func (t *BlockBuilder) createBlock(id mesh.LayerID, txs []mesh.SerializableTransaction) mesh.Block {
 	var res []mesh.BlockID = nil
 	var err error
 	if id > 0 {
 		res, err = t.hareResult.GetResult(id - 1)
 		if err != nil {
-			t.Log.Error("didnt receive hare result for layer %v", id-1)
+			//without votes the tortoise never decides on the previous layer, vote for the blocks received in it instead
+			t.Log.Error("didnt receive hare result for layer %v, voting for its received blocks", id-1)
+			res, _ = t.orphans.GetLayerBlockIDs(id - 1)
 		}
 	}
 
 	b := mesh.Block{
-
 		MinerID:    t.minerID,
-		Id:         mesh.BlockID(rand.Int63()),
+		Coinbase:   t.Coinbase(),
 		LayerIndex: id,
 		Data:       nil,
 		Coin:       t.weakCoinToss.GetResult(),
@@ -142,6 +270,8 @@ func (t *BlockBuilder) createBlock(id mesh.LayerID, txs []mesh.SerializableTrans
 		BlockVotes: res,
 		ViewEdges:  t.orphans.GetOrphanBlocksExcept(id),
 	}
+	b.Id = b.CalcId()
+	b.Sig = t.signer.Sign(b.ContentHash())
 	t.Log.Info("Iv'e created block in layer %v id %v, num of transactions %v", b.LayerIndex, b.Id, len(b.Txs))
 
 	return b
@@ -158,15 +288,40 @@ func (t *BlockBuilder) listenForTx() {
 			x, err := mesh.BytesAsTransaction(bytes.NewReader(data.Bytes()))
 			if err != nil {
 				t.Log.Error("cannot parse incoming TX")
+				metrics.RejectedTransactions.With(metrics.ReasonLabel, "malformed").Add(1)
 				data.ReportValidation(IncomingTxProtocol, false)
 				break
 			}
-			data.ReportValidation(IncomingTxProtocol, true)
-			t.newTrans <- x
+			if err := validateTx(x); err != nil {
+				t.rejectTx(data, err)
+				break
+			}
+			switch err := t.txPool.Add(x); err {
+			case nil:
+				metrics.AcceptedTransactions.Add(1)
+				data.ReportValidation(IncomingTxProtocol, true)
+			case mempool.ErrKnown, mempool.ErrNonceTaken:
+				//the transaction is valid, the pool already holds it or another transaction with its nonce
+				t.Log.Info("transaction was not added to the pool %v", err)
+				data.ReportValidation(IncomingTxProtocol, true)
+			default:
+				t.rejectTx(data, err)
+			}
 		}
 	}
 }
 
+// rejectTx stops the propagation of an invalid gossiped transaction
+func (t *BlockBuilder) rejectTx(data service.GossipMessage, err error) {
+	t.Log.Info("dropping TX %v", err)
+	reason, ok := txRejectReasons[err]
+	if !ok {
+		reason = "other"
+	}
+	metrics.RejectedTransactions.With(metrics.ReasonLabel, reason).Add(1)
+	data.ReportValidation(IncomingTxProtocol, false)
+}
+
 func (t *BlockBuilder) acceptBlockData() {
 	for {
 		select {
@@ -175,13 +330,17 @@ func (t *BlockBuilder) acceptBlockData() {
 			return
 
 		case id := <-t.beginRoundEvent:
+			if !t.MiningEligible() {
+				t.Log.Info("mining is paused, not building a block in layer %v", id)
+				break
+			}
 			if !t.blockOracle.BlockEligible(id, t.minerID) {
 				break
 			}
 
-			txList := t.transactionQueue[:common.Min(len(t.transactionQueue), MaxTransactionsPerBlock)]
-			t.transactionQueue = t.transactionQueue[common.Min(len(t.transactionQueue), MaxTransactionsPerBlock):]
-			blk := t.createBlock(id, txList)
+			budget := mempool.Budget{MaxTxs: MaxTransactionsPerBlock, MaxGas: MaxGasPerBlock, MaxBytes: MaxTransactionBytesPerBlock}
+			blk := t.createBlock(id, t.txPool.Select(budget))
+			t.recordBlock(id)
 			go func() {
 				bytes, err := mesh.BlockAsBytes(blk)
 				if err != nil {
@@ -190,9 +349,6 @@ func (t *BlockBuilder) acceptBlockData() {
 				}
 				t.network.Broadcast(meshSync.NewBlockProtocol, bytes)
 			}()
-
-		case tx := <-t.newTrans:
-			t.transactionQueue = append(t.transactionQueue, *tx)
 		}
 	}
 }
@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"github.com/davecgh/go-xdr/xdr2"
 	"github.com/spacemeshos/go-spacemesh/address"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	hare2 "github.com/spacemeshos/go-spacemesh/hare"
 	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/mempool"
+	"github.com/spacemeshos/go-spacemesh/mempool/config"
 	"github.com/spacemeshos/go-spacemesh/mesh"
 	"github.com/spacemeshos/go-spacemesh/p2p/service"
 	"github.com/spacemeshos/go-spacemesh/state"
@@ -24,14 +28,20 @@ func (m MockCoin) GetResult() bool {
 
 type MockHare struct {
 	res []mesh.BlockID
+	err error
 }
 
 func (m MockHare) GetResult(id mesh.LayerID) ([]mesh.BlockID, error) {
-	return m.res, nil
+	return m.res, m.err
 }
 
 type MockOrphans struct {
-	st []mesh.BlockID
+	st    []mesh.BlockID
+	layer []mesh.BlockID
+}
+
+func (m MockOrphans) GetLayerBlockIDs(l mesh.LayerID) ([]mesh.BlockID, error) {
+	return m.layer, nil
 }
 
 func (m MockOrphans) GetOrphanBlocksExcept(l mesh.LayerID) []mesh.BlockID {
@@ -45,6 +55,19 @@ func (mbo mockBlockOracle) BlockEligible(id mesh.LayerID, pubkey string) bool {
 	return true
 }
 
+var coinbase = address.BytesToAddress([]byte{0x07})
+
+// every account exists with a fresh nonce and enough balance for the transactions of the tests
+type accountsMock struct{}
+
+func (accountsMock) Exist(addr address.Address) bool          { return true }
+func (accountsMock) GetBalance(addr address.Address) *big.Int { return big.NewInt(1000) }
+func (accountsMock) GetNonce(addr address.Address) uint64     { return 0 }
+
+func newTxPool() *mempool.TxPool {
+	return mempool.NewTxPool(config.DefaultConfig(), accountsMock{}, log.New("mempool", "", ""))
+}
+
 func TestBlockBuilder_StartStop(t *testing.T) {
 
 	net := service.NewSimulator()
@@ -55,8 +78,9 @@ func TestBlockBuilder_StartStop(t *testing.T) {
 	hareRes := []mesh.BlockID{mesh.BlockID(0), mesh.BlockID(1), mesh.BlockID(2), mesh.BlockID(3)}
 	hare := MockHare{res: hareRes}
 
-	builder := NewBlockBuilder(n.Node.String(), n, beginRound, MockCoin{}, MockOrphans{st: []mesh.BlockID{1, 2, 3}}, hare, mockBlockOracle{},
-		log.New(n.Node.String(), "", ""))
+	sgn := hare2.NewMockSigning()
+	builder := NewBlockBuilder(sgn.Verifier().String(), coinbase, sgn, n, beginRound, MockCoin{}, MockOrphans{st: []mesh.BlockID{1, 2, 3}}, hare, mockBlockOracle{},
+		newTxPool(), log.New(n.Node.String(), "", ""))
 
 	err := builder.Start()
 	assert.NoError(t, err)
@@ -70,12 +94,19 @@ func TestBlockBuilder_StartStop(t *testing.T) {
 	err = builder.Stop()
 	assert.Error(t, err)
 
-	addr1 := address.BytesToAddress([]byte{0x02})
-	addr2 := address.BytesToAddress([]byte{0x01})
-	err = builder.AddTransaction(1, addr1, addr2, big.NewInt(1))
+	err = builder.AddTransaction(signedTx(t, 1, address.BytesToAddress([]byte{0x01})))
 	assert.Error(t, err)
 }
 
+func signedTx(t *testing.T, nonce uint64, dst address.Address) *mesh.SerializableTransaction {
+	priv, _, err := crypto.GenerateKeyPair()
+	assert.NoError(t, err)
+	tx := state.NewTransaction(nonce, dst, big.NewInt(1), DefaultGasLimit, big.NewInt(DefaultGas))
+	assert.NoError(t, tx.Sign(priv))
+	stx := Transaction2SerializableTransaction(tx)
+	return &stx
+}
+
 func TestBlockBuilder_CreateBlock(t *testing.T) {
 	net := service.NewSimulator()
 	beginRound := make(chan mesh.LayerID)
@@ -85,24 +116,25 @@ func TestBlockBuilder_CreateBlock(t *testing.T) {
 	hareRes := []mesh.BlockID{mesh.BlockID(0), mesh.BlockID(1), mesh.BlockID(2), mesh.BlockID(3)}
 	hare := MockHare{res: hareRes}
 
-	builder := NewBlockBuilder(n.Node.String(), n, beginRound, MockCoin{}, MockOrphans{st: []mesh.BlockID{1, 2, 3}}, hare,
-		mockBlockOracle{}, log.New(n.Node.String(), "", ""))
+	sgn := hare2.NewMockSigning()
+	builder := NewBlockBuilder(sgn.Verifier().String(), coinbase, sgn, n, beginRound, MockCoin{}, MockOrphans{st: []mesh.BlockID{1, 2, 3}}, hare,
+		mockBlockOracle{}, newTxPool(), log.New(n.Node.String(), "", ""))
 
 	err := builder.Start()
 	assert.NoError(t, err)
 
-	addr1 := address.BytesToAddress([]byte{0x02})
-	addr2 := address.BytesToAddress([]byte{0x01})
-
+	addr := address.BytesToAddress([]byte{0x01})
 	trans := []mesh.SerializableTransaction{
-		Transaction2SerializableTransaction(state.NewTransaction(1, addr1, addr2, big.NewInt(1), DefaultGasLimit, big.NewInt(DefaultGas))),
-		Transaction2SerializableTransaction(state.NewTransaction(1, addr1, addr2, big.NewInt(1), DefaultGasLimit, big.NewInt(DefaultGas))),
-		Transaction2SerializableTransaction(state.NewTransaction(2, addr1, addr2, big.NewInt(1), DefaultGasLimit, big.NewInt(DefaultGas))),
+		*signedTx(t, 0, addr),
+		*signedTx(t, 0, addr),
+		*signedTx(t, 0, addr),
 	}
 
-	builder.AddTransaction(trans[0].AccountNonce, trans[0].Origin, *trans[0].Recipient, big.NewInt(0).SetBytes(trans[0].Price))
-	builder.AddTransaction(trans[1].AccountNonce, trans[1].Origin, *trans[1].Recipient, big.NewInt(0).SetBytes(trans[1].Price))
-	builder.AddTransaction(trans[2].AccountNonce, trans[2].Origin, *trans[2].Recipient, big.NewInt(0).SetBytes(trans[2].Price))
+	for i := range trans {
+		assert.NoError(t, builder.AddTransaction(&trans[i]))
+	}
+	assert.Error(t, builder.AddTransaction(mesh.NewSerializableTransaction(1, addr, big.NewInt(1), big.NewInt(DefaultGas), DefaultGasLimit)),
+		"unsigned transactions should be rejected")
 
 	go func() { beginRound <- mesh.LayerID(1) }()
 
@@ -113,16 +145,224 @@ func TestBlockBuilder_CreateBlock(t *testing.T) {
 		assert.Equal(t, hareRes, b.BlockVotes)
 		assert.Equal(t, trans, b.Txs)
 		assert.Equal(t, []mesh.BlockID{1, 2, 3}, b.ViewEdges)
+		assert.Equal(t, sgn.Verifier().String(), b.MinerID)
+		assert.Equal(t, coinbase, b.Coinbase)
+		assert.True(t, b.ValidId())
+		assert.True(t, b.ValidSignature())
+
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "timeout on receiving block")
+
+	}
+
+}
+
+func TestBlockBuilder_CreateBlockWithoutHareResult(t *testing.T) {
+	net := service.NewSimulator()
+	beginRound := make(chan mesh.LayerID)
+	n := net.NewNode()
+	receiver := net.NewNode()
+
+	received := []mesh.BlockID{4, 5}
+	sgn := hare2.NewMockSigning()
+	builder := NewBlockBuilder(sgn.Verifier().String(), coinbase, sgn, n, beginRound, MockCoin{}, MockOrphans{st: []mesh.BlockID{1, 2, 3}, layer: received},
+		MockHare{err: hare2.ErrTooEarly}, mockBlockOracle{}, newTxPool(), log.New(n.Node.String(), "", ""))
+	assert.NoError(t, builder.Start())
+
+	go func() { beginRound <- mesh.LayerID(1) }()
+
+	select {
+	case output := <-receiver.RegisterGossipProtocol(sync.NewBlockProtocol):
+		b := mesh.Block{}
+		xdr.Unmarshal(bytes.NewBuffer(output.Bytes()), &b)
+		assert.Equal(t, received, b.BlockVotes, "the blocks received in the previous layer should be voted for")
+		assert.True(t, b.ValidId())
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "timeout on receiving block")
+	}
+}
+
+func TestBlockBuilder_ListenForTx(t *testing.T) {
+	net := service.NewSimulator()
+	beginRound := make(chan mesh.LayerID)
+	n := net.NewNode()
+	sender := net.NewNode()
+	receiver := net.NewNode()
+
+	sgn := hare2.NewMockSigning()
+	builder := NewBlockBuilder(sgn.Verifier().String(), coinbase, sgn, n, beginRound, MockCoin{}, MockOrphans{}, MockHare{},
+		mockBlockOracle{}, newTxPool(), log.New(n.Node.String(), "", ""))
+	assert.NoError(t, builder.Start())
+	defer builder.Stop()
+
+	addr := address.BytesToAddress([]byte{0x01})
+	unsigned := mesh.NewSerializableTransaction(1, addr, big.NewInt(1), big.NewInt(DefaultGas), DefaultGasLimit)
+	signed := signedTx(t, 0, addr)
+	forged := signedTx(t, 1, addr)
+	forged.Amount = big.NewInt(1000).Bytes()
+	forged.Signature = forged.Signature[1:]
+	greedy := signedTx(t, 0, addr)
+	greedy.GasLimit = MaxGasPerBlock + 1
+	for _, tx := range []*mesh.SerializableTransaction{unsigned, forged, greedy, signed} {
+		buf, err := mesh.TransactionAsBytes(tx)
+		assert.NoError(t, err)
+		assert.NoError(t, sender.Broadcast(IncomingTxProtocol, buf))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	go func() { beginRound <- mesh.LayerID(1) }()
+
+	select {
+	case output := <-receiver.RegisterGossipProtocol(sync.NewBlockProtocol):
+		b := mesh.Block{}
+		xdr.Unmarshal(bytes.NewBuffer(output.Bytes()), &b)
+		assert.Equal(t, []mesh.SerializableTransaction{*signed}, b.Txs, "only valid transactions should be included")
 
 	case <-time.After(1 * time.Second):
 		assert.Fail(t, "timeout on receiving block")
+	}
+}
+
+type gossipMock struct {
+	data  []byte
+	valid chan bool
+}
+
+func (m gossipMock) Bytes() []byte                                           { return m.data }
+func (m gossipMock) ValidationCompletedChan() chan service.MessageValidation { return nil }
+func (m gossipMock) ReportValidation(protocol string, isValid bool)          { m.valid <- isValid }
+
+func TestBlockBuilder_ListenForKnownTx(t *testing.T) {
+	net := service.NewSimulator()
+	n := net.NewNode()
+	sgn := hare2.NewMockSigning()
+	pool := mempool.NewTxPool(config.Config{MaxTxs: 10, MaxSenderTxs: 1}, accountsMock{}, log.New("mempool", "", ""))
+	builder := NewBlockBuilder(sgn.Verifier().String(), coinbase, sgn, n, make(chan mesh.LayerID), MockCoin{}, MockOrphans{}, MockHare{},
+		mockBlockOracle{}, pool, log.New(n.Node.String(), "", ""))
+	assert.NoError(t, builder.Start())
+	defer builder.Stop()
+
+	priv, _, err := crypto.GenerateKeyPair()
+	assert.NoError(t, err)
+	nonce := uint64(0)
+	tx := func(price int64) *mesh.SerializableTransaction {
+		stx := state.NewTransaction(nonce, address.BytesToAddress([]byte{0x01}), big.NewInt(1), DefaultGasLimit, big.NewInt(price))
+		assert.NoError(t, stx.Sign(priv))
+		s := Transaction2SerializableTransaction(stx)
+		return &s
+	}
+	//the pool already holds the transaction, e.g. it was resubmitted by the client
+	submitted := tx(DefaultGas)
+	assert.NoError(t, pool.Add(submitted))
+	wrongSig := tx(DefaultGas + 2)
+	wrongSig.Signature = wrongSig.Signature[1:]
+	replacement := tx(DefaultGas + 1)
+	nonce++
+	senderFull := tx(DefaultGas)
+
+	valid := make(chan bool, 1)
+	for _, c := range []struct {
+		tx    *mesh.SerializableTransaction
+		valid bool
+	}{
+		{submitted, true},
+		{replacement, true}, //a replacement of the pending transaction with the same nonce
+		{wrongSig, false},
+		{senderFull, false}, //the pool holds as many transactions of the sender as it accepts
+	} {
+		buf, err := mesh.TransactionAsBytes(c.tx)
+		assert.NoError(t, err)
+		builder.txGossipChannel <- gossipMock{data: buf, valid: valid}
+		select {
+		case v := <-valid:
+			assert.Equal(t, c.valid, v)
+		case <-time.After(time.Second):
+			assert.Fail(t, "timeout on transaction validation")
+		}
+	}
+}
+
+func TestBlockBuilder_Mining(t *testing.T) {
+	net := service.NewSimulator()
+	beginRound := make(chan mesh.LayerID)
+	n := net.NewNode()
+	receiver := net.NewNode()
+	blocks := receiver.RegisterGossipProtocol(sync.NewBlockProtocol)
+
+	sgn := hare2.NewMockSigning()
+	builder := NewBlockBuilder(sgn.Verifier().String(), coinbase, sgn, n, beginRound, MockCoin{}, MockOrphans{}, MockHare{},
+		mockBlockOracle{}, newTxPool(), log.New(n.Node.String(), "", ""))
+	assert.NoError(t, builder.Start())
+	defer builder.Stop()
 
+	builder.StopMining()
+	assert.False(t, builder.MiningEligible())
+	beginRound <- mesh.LayerID(1)
+	select {
+	case <-blocks:
+		assert.Fail(t, "a block was built while mining was paused")
+	case <-time.After(100 * time.Millisecond):
 	}
 
+	other := address.BytesToAddress([]byte{0x08})
+	builder.SetCoinbase(other)
+	builder.StartMining()
+	beginRound <- mesh.LayerID(2)
+	select {
+	case output := <-blocks:
+		b := mesh.Block{}
+		xdr.Unmarshal(bytes.NewBuffer(output.Bytes()), &b)
+		assert.Equal(t, other, b.Coinbase)
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "timeout on receiving block")
+	}
+	assert.Equal(t, map[mesh.LayerID]int{2: 1}, builder.ProducedBlocks())
+}
+
+func TestBlockBuilder_ProducedBlocksWindow(t *testing.T) {
+	n := service.NewSimulator().NewNode()
+	sgn := hare2.NewMockSigning()
+	builder := NewBlockBuilder(sgn.Verifier().String(), coinbase, sgn, n, make(chan mesh.LayerID), MockCoin{}, MockOrphans{}, MockHare{},
+		mockBlockOracle{}, newTxPool(), log.New(n.Node.String(), "", ""))
+
+	//blocks are not built in every layer, the window moves over the layers without blocks as well
+	builder.recordBlock(3)
+	builder.recordBlock(3)
+	builder.recordBlock(10)
+	builder.recordBlock(producedLayers + 5)
+	assert.Equal(t, map[mesh.LayerID]int{10: 1, producedLayers + 5: 1}, builder.ProducedBlocks())
+
+	builder.recordBlock(3 * producedLayers)
+	assert.Equal(t, map[mesh.LayerID]int{3 * producedLayers: 1}, builder.ProducedBlocks())
+}
+
+func TestBlockBuilder_ValidateTx(t *testing.T) {
+	addr := address.BytesToAddress([]byte{0x01})
+	assert.NoError(t, validateTx(signedTx(t, 0, addr)))
+
+	tx := signedTx(t, 0, addr)
+	tx.Recipient = nil
+	assert.Equal(t, errRecipient, validateTx(tx))
+
+	tx = signedTx(t, 0, addr)
+	tx.Amount = make([]byte, maxAmountBytes+1)
+	assert.Equal(t, errAmount, validateTx(tx))
+
+	tx = signedTx(t, 0, addr)
+	tx.Price = make([]byte, maxAmountBytes+1)
+	assert.Equal(t, errPrice, validateTx(tx))
+
+	tx = signedTx(t, 0, addr)
+	tx.GasLimit = MaxGasPerBlock + 1
+	assert.Equal(t, errGasLimit, validateTx(tx))
+
+	tx = signedTx(t, 0, addr)
+	tx.Signature = tx.Signature[1:]
+	assert.Equal(t, errSignature, validateTx(tx))
 }
 
 func TestBlockBuilder_SerializeTrans(t *testing.T) {
-	tx := mesh.NewSerializableTransaction(0, address.BytesToAddress([]byte{0x01}), address.BytesToAddress([]byte{0x02}), big.NewInt(10), big.NewInt(10), 10)
+	tx := mesh.NewSerializableTransaction(0, address.BytesToAddress([]byte{0x02}), big.NewInt(10), big.NewInt(10), 10)
 	buf, err := mesh.TransactionAsBytes(tx)
 	assert.NoError(t, err)
 
@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Namespace is the metrics namespace
+	Namespace = "spacemesh"
+	// Subsystem is a subsystem shared by all metrics exposed by this package
+	Subsystem = "miner"
+
+	// ReasonLabel holds the reason a transaction was rejected for
+	ReasonLabel = "reason"
+)
+
+var (
+	// the number of gossiped transactions that were not propagated for each reason
+	RejectedTransactions = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "rejected_transactions",
+		Help:      "Number of gossiped transactions that were rejected",
+	}, []string{ReasonLabel})
+
+	// the number of gossiped transactions that were propagated
+	AcceptedTransactions = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "accepted_transactions",
+		Help:      "Number of gossiped transactions that passed validation",
+	}, []string{})
+)
@@ -59,6 +59,22 @@ func New(root common.Hash, db Database) (*StateDB, error) {
 	}, nil
 }
 
+// Reset discards the live objects and reopens the state at root, the objects obtained before the reset must not
+// be used afterwards
+func (self *StateDB) Reset(root common.Hash) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	tr, err := self.db.OpenTrie(root)
+	if err != nil {
+		return err
+	}
+	self.globalTrie = tr
+	self.stateObjects = make(map[address.Address]*StateObj)
+	self.stateObjectsDirty = make(map[address.Address]struct{})
+	self.dbErr = nil
+	return nil
+}
+
 // setError remembers the first non-nil error it is called with.
 func (self *StateDB) setError(err error) {
 	if self.dbErr == nil {
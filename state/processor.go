@@ -1,11 +1,15 @@
 package state
 
 import (
+	"bytes"
 	"container/list"
+	"errors"
 	"fmt"
 	"github.com/spacemeshos/go-spacemesh/address"
 	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/crypto/sha3"
+	"github.com/spacemeshos/go-spacemesh/database"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/rlp"
 	"github.com/spacemeshos/go-spacemesh/trie"
@@ -22,20 +26,29 @@ type Transaction struct {
 	Price        *big.Int
 	GasLimit     uint64
 	Recipient    *address.Address
-	Origin       address.Address //todo: remove this, should be calculated from sig.
+	Origin       address.Address //the sender, recovered from the signature by VerifySignature
 	Amount       *big.Int
 	Payload      []byte
-
-	//todo: add signatures
+	Signature    []byte //recoverable signature of the sender over SigningHash
 
 	hash *common.Hash
 }
 
-func NewTransaction(nonce uint64, origin address.Address, destination address.Address,
+// txBody holds the fields of a transaction that are covered by its signature
+type txBody struct {
+	AccountNonce uint64
+	Price        *big.Int
+	GasLimit     uint64
+	Recipient    *address.Address
+	Amount       *big.Int
+	Payload      []byte
+}
+
+// NewTransaction creates an unsigned transaction, it has to be signed by the sender with Sign before it can be applied
+func NewTransaction(nonce uint64, destination address.Address,
 	amount *big.Int, gasLimit uint64, gasPrice *big.Int) *Transaction {
 	return &Transaction{
 		AccountNonce: nonce,
-		Origin:       origin,
 		Recipient:    &destination,
 		Amount:       amount,
 		GasLimit:     gasLimit,
@@ -60,11 +73,66 @@ func (tx *Transaction) Hash() common.Hash {
 	return *tx.hash
 }
 
+// SigningHash returns the hash of the transaction body, this is what the sender signs
+func (tx *Transaction) SigningHash() common.Hash {
+	return rlpHash(&txBody{
+		AccountNonce: tx.AccountNonce,
+		Price:        tx.Price,
+		GasLimit:     tx.GasLimit,
+		Recipient:    tx.Recipient,
+		Amount:       tx.Amount,
+		Payload:      tx.Payload,
+	})
+}
+
+// Sign signs the transaction with the key of the sending account and sets the origin to its address
+func (tx *Transaction) Sign(key crypto.PrivateKey) error {
+	h := tx.SigningHash()
+	sig, err := crypto.SignRecoverable(key, h[:])
+	if err != nil {
+		return err
+	}
+	tx.Signature = sig
+	tx.Origin = address.PublicKeyToAddress(key.GetPublicKey().Bytes())
+	tx.hash = nil
+	return nil
+}
+
+// Sender recovers the address of the account that signed the transaction
+func (tx *Transaction) Sender() (address.Address, error) {
+	h := tx.SigningHash()
+	pub, err := crypto.RecoverPublicKey(h[:], tx.Signature)
+	if err != nil {
+		return address.Address{}, err
+	}
+	return address.PublicKeyToAddress(pub.Bytes()), nil
+}
+
+// VerifySignature recovers the sender from the signature and sets it as the origin of the transaction
+func (tx *Transaction) VerifySignature() error {
+	origin, err := tx.Sender()
+	if err != nil {
+		return err
+	}
+	tx.Origin = origin
+	tx.hash = nil
+	return nil
+}
+
+// Fee returns the fee the sender pays to the miners, there is no execution yet so the whole gas limit is charged
+func (tx *Transaction) Fee() *big.Int {
+	if tx.Price == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Mul(tx.Price, new(big.Int).SetUint64(tx.GasLimit))
+}
+
 type Transactions []*Transaction
 
 type PseudoRandomizer interface {
 	Uint32() uint32
 	Uint64() uint64
+	Seed(seed int64)
 }
 
 type StatePreImages struct {
@@ -81,46 +149,96 @@ type TransactionProcessor struct {
 	rootHash     common.Hash
 	stateQueue   list.List
 	db           *trie.Database
+	roots        database.DB //persists the state root of every applied layer
+	receipts     database.DB //persists a receipt per applied transaction hash
 	mu           sync.Mutex
+	layerReward  *big.Int                 //issued every layer and split between the miners of the layer
+	fees         *big.Int                 //fees collected from the transactions of the layer being applied
+	pending      map[common.Hash]*Receipt //receipts of the transactions of the layer being applied
 }
 
 const maxPastStates = 20
 
-func NewTransactionProcessor(rnd PseudoRandomizer, db *StateDB, logger log.Log) *TransactionProcessor {
+// NewTransactionProcessor creates a processor applying layers on top of db, the state root of every applied layer is
+// persisted in roots and the receipts of the applied transactions in receipts. db should be opened with the root
+// returned by LoadLatestRoot to continue from a previous run
+func NewTransactionProcessor(rnd PseudoRandomizer, db *StateDB, roots database.DB, receipts database.DB, logger log.Log) *TransactionProcessor {
+	current, _, err := LoadLatestRoot(roots)
+	if err != nil {
+		current = 0
+	}
 	return &TransactionProcessor{
 		Log:          logger,
 		rand:         rnd,
 		globalState:  db,
 		prevStates:   make(map[LayerID]common.Hash),
-		currentLayer: 0,
+		currentLayer: current,
 		rootHash:     common.Hash{},
 		stateQueue:   list.List{},
 		db:           db.TrieDB(),
+		roots:        roots,
+		receipts:     receipts,
 		mu:           sync.Mutex{}, //sync between reset and apply transactions
+		layerReward:  new(big.Int),
+		fees:         new(big.Int),
+		pending:      make(map[common.Hash]*Receipt),
 	}
 }
 
-//should receive sort predicate
-func (tp *TransactionProcessor) ApplyTransactions(layer LayerID, transactions Transactions) (uint32, error) {
-	//todo: need to seed the mersenne twister with random beacon seed
-	if len(transactions) == 0 {
-		return 0, nil
-	}
+// SetLayerReward sets the amount issued to the miners of every layer on top of the transaction fees
+func (tp *TransactionProcessor) SetLayerReward(reward *big.Int) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.layerReward = new(big.Int).Set(reward)
+}
 
-	txs := tp.mergeDoubles(transactions)
+// ApplyTransactions applies the transactions of a layer and rewards the miners with the layer reward and the fees
+// of the applied transactions. miners holds the coinbase of every contextually valid block of the layer.
+// the transactions are shuffled with a generator seeded by seed, all nodes must pass the same seed for a layer,
+// e.g. a hash of the layer's consensus output, to reach the same state. only layers after the last applied one are
+// accepted, a layer that is already part of the state, e.g. one before the layer of an imported snapshot, is rejected
+func (tp *TransactionProcessor) ApplyTransactions(layer LayerID, seed []byte, miners []address.Address, transactions Transactions) (uint32, error) {
 	tp.mu.Lock()
 	defer tp.mu.Unlock()
+	if latest, _, err := LoadLatestRoot(tp.roots); err == nil && layer <= latest {
+		return 0, fmt.Errorf("layer %v is already part of the state of layer %v", layer, latest)
+	}
+	if len(transactions) == 0 && len(miners) == 0 {
+		//the state does not change but its root is recorded for the layer so a reorg can revert to it
+		tp.currentLayer = layer
+		return 0, setLatestRoot(tp.roots, layer, tp.globalState.IntermediateRoot(false))
+	}
+
+	txs := tp.mergeDoubles(transactions)
+	tp.fees = new(big.Int)
+	tp.pending = make(map[common.Hash]*Receipt, len(txs))
+	tp.rand.Seed(int64(common.BytesToUint64(common.LeftPadBytes(seed, 8))))
 	failed := tp.Process(tp.randomSort(txs), tp.coalesceTransactionsBySender(txs))
+	tp.rewardMiners(layer, miners)
 	newHash, err := tp.globalState.Commit(false)
 	tp.Log.Info("new state root for layer %v is %x", layer, newHash)
 	if err != nil {
 		tp.Log.Error("db write error %v", err)
 		return failed, err
 	}
+	//the trie is flushed so the root persisted below can be loaded after a restart
+	if err := tp.db.Commit(newHash, false); err != nil {
+		tp.Log.Error("could not write state of layer %v %v", layer, err)
+		return failed, err
+	}
+	if err := setLatestRoot(tp.roots, layer, newHash); err != nil {
+		tp.Log.Error("could not persist state root of layer %v %v", layer, err)
+		return failed, err
+	}
+	if err := writeReceipts(tp.receipts, layer, tp.pending); err != nil {
+		tp.Log.Error("could not persist receipts of layer %v %v", layer, err)
+		return failed, err
+	}
 
+	tp.currentLayer = layer
 	tp.stateQueue.PushBack(newHash)
 	if tp.stateQueue.Len() > maxPastStates {
-		hash := tp.stateQueue.Remove(tp.stateQueue.Back())
+		hash := tp.stateQueue.Remove(tp.stateQueue.Front())
 		tp.db.Commit(hash.(common.Hash), false)
 	}
 	tp.prevStates[layer] = newHash
@@ -129,19 +247,141 @@ func (tp *TransactionProcessor) ApplyTransactions(layer LayerID, transactions Tr
 	return failed, nil
 }
 
-func (tp *TransactionProcessor) Reset(layer LayerID) {
+// rewardMiners splits the layer reward and the collected fees evenly between the miners, a miner with several
+// valid blocks in the layer gets a share per block. the remainder of the division is not issued
+func (tp *TransactionProcessor) rewardMiners(layer LayerID, miners []address.Address) {
+	if len(miners) == 0 {
+		tp.Log.Warning("no miners to reward in layer %v, fees of %v are burned", layer, tp.fees)
+		return
+	}
+	total := new(big.Int).Add(tp.layerReward, tp.fees)
+	share := new(big.Int).Div(total, big.NewInt(int64(len(miners))))
+	for _, m := range miners {
+		tp.globalState.AddBalance(m, share)
+	}
+	tp.Log.Info("rewarded %v miners of layer %v with %v each (fees %v)", len(miners), layer, share, tp.fees)
+}
+
+// Reset reverts the state to the one produced by layer, layers applied before a restart are looked up in the roots
+// database. the receipts of the transactions applied in the reverted layers are removed
+func (tp *TransactionProcessor) Reset(layer LayerID) error {
 	tp.mu.Lock()
 	defer tp.mu.Unlock()
-	if state, ok := tp.prevStates[layer]; ok {
-		newState, err := New(state, tp.globalState.db)
-		tp.Log.Info("reverted, new root %x", newState.IntermediateRoot(false))
-		if err != nil {
-			panic("cannot revert- improper state")
+	root, ok := tp.prevStates[layer]
+	if !ok {
+		var err error
+		if root, err = getRoot(tp.roots, layer); err != nil {
+			return fmt.Errorf("cannot revert to layer %v %v", layer, err)
+		}
+	}
+	//the state is reopened in place so holders of the state, e.g. the api, see the reverted one
+	if err := tp.globalState.Reset(root); err != nil {
+		return fmt.Errorf("cannot revert to layer %v %v", layer, err)
+	}
+	tp.Log.Info("reverted from layer %v to layer %v, new root %x", tp.currentLayer, layer, root)
+
+	for l := tp.currentLayer; l > layer; l-- {
+		if err := deleteReceipts(tp.receipts, l); err != nil {
+			tp.Log.Error("could not delete receipts of reverted layer %v %v", l, err)
 		}
+	}
+	tp.pruneAfterRevert(layer)
+	tp.currentLayer = layer
+	if err := setLatestRoot(tp.roots, layer, root); err != nil {
+		return fmt.Errorf("could not persist state root of layer %v %v", layer, err)
+	}
+	return nil
+}
+
+// SetSyncedState records root as the state produced by layer, the nodes of the state must already be in the
+// database, e.g. synced from peers. like ImportSnapshot it is only allowed before any layer was applied
+func (tp *TransactionProcessor) SetSyncedState(layer LayerID, root common.Hash) error {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	if latest, _, err := LoadLatestRoot(tp.roots); err == nil {
+		return fmt.Errorf("database already holds the state of layer %v", latest)
+	}
+	if err := tp.globalState.Reset(root); err != nil {
+		return fmt.Errorf("could not open state %x of layer %v %v", root, layer, err)
+	}
+	tp.Log.Info("set state of layer %v to synced root %x", layer, root)
 
-		tp.globalState = newState
-		tp.pruneAfterRevert(layer)
+	tp.currentLayer = layer
+	tp.prevStates[layer] = root
+	if err := setLatestRoot(tp.roots, layer, root); err != nil {
+		return fmt.Errorf("could not persist state root of layer %v %v", layer, err)
 	}
+	return nil
+}
+
+// Exist, GetBalance and GetNonce read the current state under the lock of the processor, they are used by readers
+// running alongside the layers being applied, e.g. the transaction pool
+func (tp *TransactionProcessor) Exist(addr address.Address) bool {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return tp.globalState.Exist(addr)
+}
+
+func (tp *TransactionProcessor) GetBalance(addr address.Address) *big.Int {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return new(big.Int).Set(tp.globalState.GetBalance(addr))
+}
+
+func (tp *TransactionProcessor) GetNonce(addr address.Address) uint64 {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return tp.globalState.GetNonce(addr)
+}
+
+// GetTransactionReceipt returns the receipt of the transaction with the given hash,
+// it returns an error if the transaction was not applied in any layer yet
+func (tp *TransactionProcessor) GetTransactionReceipt(hash common.Hash) (*Receipt, error) {
+	return getReceipt(tp.receipts, hash)
+}
+
+// StateAt opens a read only view of the state produced by layer, the layer can be any layer up to the last applied one.
+// the view is not affected by layers applied after it was opened
+func (tp *TransactionProcessor) StateAt(layer LayerID) (*StateDB, error) {
+	latest, err := tp.LatestLayer()
+	if err != nil || layer > latest {
+		return nil, fmt.Errorf("layer %v was not applied yet", layer)
+	}
+	root, err := getRoot(tp.roots, layer)
+	if err != nil {
+		return nil, err
+	}
+	tp.mu.Lock()
+	db := tp.globalState.db
+	tp.mu.Unlock()
+	return New(root, db)
+}
+
+// GetAccountProof returns the account of addr in the state produced by layer together with a proof
+// against the state root of the layer, the layer can be any layer up to the last applied one
+func (tp *TransactionProcessor) GetAccountProof(layer LayerID, addr address.Address) (*AccountProof, error) {
+	st, err := tp.StateAt(layer)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := st.GetProof(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &AccountProof{
+		Address: addr,
+		Layer:   layer,
+		Root:    st.IntermediateRoot(false),
+		Balance: st.GetBalance(addr),
+		Nonce:   st.GetNonce(addr),
+		Nodes:   nodes,
+	}, nil
+}
+
+// LatestLayer returns the last layer applied to the state, including layers applied before a restart
+func (tp *TransactionProcessor) LatestLayer() (LayerID, error) {
+	layer, _, err := LoadLatestRoot(tp.roots)
+	return layer, err
 }
 
 func (tp *TransactionProcessor) mergeDoubles(transactions Transactions) Transactions {
@@ -156,7 +396,13 @@ func (tp *TransactionProcessor) mergeDoubles(transactions Transactions) Transact
 	return merged
 }
 
+// randomSort shuffles the transactions, they are sorted by hash first so the result
+// only depends on the seed of the generator and not on the order the transactions were received in
 func (tp *TransactionProcessor) randomSort(transactions Transactions) Transactions {
+	sort.Slice(transactions, func(i, j int) bool {
+		hi, hj := transactions[i].Hash(), transactions[j].Hash()
+		return bytes.Compare(hi[:], hj[:]) < 0
+	})
 	vecLen := len(transactions)
 	for i := range transactions {
 		swp := int(tp.rand.Uint32()) % vecLen
@@ -173,9 +419,13 @@ func (tp *TransactionProcessor) coalesceTransactionsBySender(transactions Transa
 
 	for key := range trnsBySender {
 		sort.Slice(trnsBySender[key], func(i, j int) bool {
-			//todo: add fix here:
-			// if trnsBySender[key][i].AccountNonce == trnsBySender[key][j].AccountNonce { return trnsBySender[key][i].Hash() < trnsBySender[key][j].AccountNonce.Hash() }
-			return trnsBySender[key][i].AccountNonce < trnsBySender[key][j].AccountNonce
+			ti, tj := trnsBySender[key][i], trnsBySender[key][j]
+			if ti.AccountNonce == tj.AccountNonce {
+				//only one of the transactions with the same nonce is applied, pick it the same way on all nodes
+				hi, hj := ti.Hash(), tj.Hash()
+				return bytes.Compare(hi[:], hj[:]) < 0
+			}
+			return ti.AccountNonce < tj.AccountNonce
 		})
 	}
 
@@ -202,24 +452,33 @@ func (tp *TransactionProcessor) Process(transactions Transactions, trnsBySender
 			if err != nil {
 				errors++
 				log.Error("transaction aborted: %v", err)
+				tp.pending[trns.Hash()] = &Receipt{Status: ReceiptFailed, Reason: err.Error(), Fee: new(big.Int)}
+				continue
 			}
+			tp.pending[trns.Hash()] = &Receipt{Status: ReceiptApplied, Fee: trns.Fee()}
 
 		}
 	}
 	return errors
 }
 
+// pruneAfterRevert drops the states of the layers applied after targetLayerID, the state of the target is kept
+// so it can be reverted to again. needs to be called under mutex lock
 func (tp *TransactionProcessor) pruneAfterRevert(targetLayerID LayerID) {
-	//needs to be called under mutex lock
-	for i := tp.currentLayer; i >= targetLayerID; i-- {
-		if hash, ok := tp.prevStates[i]; ok {
-			if tp.stateQueue.Front().Value != hash {
-				panic("old state wasn't found")
+	for i := tp.currentLayer; i > targetLayerID; i-- {
+		hash, ok := tp.prevStates[i]
+		if !ok {
+			continue
+		}
+		//two layers can produce the same root, only the entry of this layer is removed
+		for e := tp.stateQueue.Back(); e != nil; e = e.Prev() {
+			if e.Value.(common.Hash) == hash {
+				tp.stateQueue.Remove(e)
+				break
 			}
-			tp.stateQueue.Remove(tp.stateQueue.Front())
-			tp.db.Dereference(hash)
-			delete(tp.prevStates, i)
 		}
+		tp.db.Dereference(hash)
+		delete(tp.prevStates, i)
 	}
 }
 
@@ -228,32 +487,43 @@ func (tp *TransactionProcessor) checkNonce(trns *Transaction) bool {
 }
 
 var (
-	ErrOrigin = "origin account doesnt exist"
-	ErrFunds  = "insufficient funds"
-	ErrNonce  = "incorrect nonce"
+	ErrOrigin    = "origin account doesnt exist"
+	ErrFunds     = "insufficient funds"
+	ErrNonce     = "incorrect nonce"
+	ErrSignature = "invalid signature"
 )
 
-//todo: mining fees...
+// ApplyTransaction transfers the amount to the recipient and collects the fee from the sender,
+// the collected fees are paid to the miners once the layer is applied
 func (tp *TransactionProcessor) ApplyTransaction(trans *Transaction) error {
+	if sender, err := trans.Sender(); err != nil || sender != trans.Origin {
+		tp.Log.Error(ErrSignature+" for transaction from %x", trans.Origin)
+		return errors.New(ErrSignature)
+	}
+
 	if !tp.globalState.Exist(trans.Origin) {
-		return fmt.Errorf(ErrOrigin)
+		return errors.New(ErrOrigin)
 	}
 
 	origin := tp.globalState.GetOrNewStateObj(trans.Origin)
+	fee := trans.Fee()
+	cost := new(big.Int).Add(trans.Amount, fee)
 
 	//todo: should we allow to spend all accounts data?
-	if origin.Balance().Cmp(trans.Amount) <= 0 {
-		tp.Log.Error(ErrFunds+" have: %v need: %v", origin.Balance(), trans.Amount)
-		return fmt.Errorf(ErrFunds)
+	if origin.Balance().Cmp(cost) <= 0 {
+		tp.Log.Error(ErrFunds+" have: %v need: %v", origin.Balance(), cost)
+		return errors.New(ErrFunds)
 	}
 
 	if !tp.checkNonce(trans) {
 		tp.Log.Error(ErrNonce+" should be %v actual %v", tp.globalState.GetNonce(trans.Origin), trans.AccountNonce)
-		return fmt.Errorf(ErrNonce)
+		return errors.New(ErrNonce)
 	}
 
 	tp.globalState.SetNonce(trans.Origin, tp.globalState.GetNonce(trans.Origin)+1)
 	transfer(tp.globalState, trans.Origin, *trans.Recipient, trans.Amount)
+	tp.globalState.SubBalance(trans.Origin, fee)
+	tp.fees.Add(tp.fees, fee)
 
 	return nil
 }
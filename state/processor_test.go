@@ -4,6 +4,7 @@ import (
 	"github.com/seehuhn/mt19937"
 	"github.com/spacemeshos/go-spacemesh/address"
 	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/database"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/stretchr/testify/assert"
@@ -26,11 +27,25 @@ func (s *ProcessorStateSuite) SetupTest() {
 	s.db = database.NewMemDatabase()
 	s.state, _ = New(common.Hash{}, NewDatabase(s.db))
 
-	s.processor = NewTransactionProcessor(rng, s.state, lg)
+	s.processor = NewTransactionProcessor(rng, s.state, database.NewTable(s.db, "roots/"), database.NewTable(s.db, "receipts/"), lg)
 }
 
-func createAccount(state *StateDB, addr []byte, balance int64, nonce uint64) *StateObj {
-	addr1 := toAddr(addr)
+// testKeys holds the keys of the accounts created by the tests so transactions can be signed by their origin
+var testKeys = make(map[address.Address]crypto.PrivateKey)
+
+// accountKey returns a deterministic key derived from seed and the address it controls
+func accountKey(seed []byte) (crypto.PrivateKey, address.Address) {
+	key, err := crypto.NewPrivateKey(common.LeftPadBytes(seed, 32))
+	if err != nil {
+		panic(err)
+	}
+	addr := address.PublicKeyToAddress(key.GetPublicKey().Bytes())
+	testKeys[addr] = key
+	return key, addr
+}
+
+func createAccount(state *StateDB, seed []byte, balance int64, nonce uint64) *StateObj {
+	_, addr1 := accountKey(seed)
 	obj1 := state.GetOrNewStateObj(addr1)
 	obj1.AddBalance(big.NewInt(balance))
 	obj1.SetNonce(nonce)
@@ -40,16 +55,17 @@ func createAccount(state *StateDB, addr []byte, balance int64, nonce uint64) *St
 
 func createTransaction(nonce uint64,
 	origin address.Address, destination address.Address, amount int64) *Transaction {
-	return &Transaction{
-		AccountNonce: nonce,
-		Origin:       origin,
-		Recipient:    &destination,
-		Amount:       big.NewInt(amount),
-		GasLimit:     10,
-		Price:        big.NewInt(1),
-		hash:         nil,
-		Payload:      nil,
+	//the transactions are free so the balances only reflect the transfers, fees are tested separately
+	tx := NewTransaction(nonce, destination, big.NewInt(amount), 10, big.NewInt(0))
+	key, ok := testKeys[origin]
+	if !ok {
+		tx.Origin = origin
+		return tx
+	}
+	if err := tx.Sign(key); err != nil {
+		panic(err)
 	}
+	return tx
 }
 
 func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction() {
@@ -67,7 +83,7 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction() {
 		createTransaction(obj1.Nonce(), obj1.address, obj2.address, 1),
 	}
 
-	failed, err := s.processor.ApplyTransactions(1, transactions)
+	failed, err := s.processor.ApplyTransactions(1, nil, nil, transactions)
 	assert.NoError(s.T(), err)
 	assert.True(s.T(), failed == 0)
 
@@ -77,17 +93,17 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction() {
 	assert.Equal(s.T(), uint64(1), s.state.GetNonce(obj1.address))
 
 	want := `{
-	"root": "7ed462059ad2df6754b5aa1f3d8a150bb9b0e1c4eb50b6217a8fc4ecbec7fb28",
+	"root": "203a44c3814790fb60f3d32d354df7cd5971fca0ad51272388184d324fab4f91",
 	"accounts": {
-		"0000000000000000000000000000000000000001": {
-			"balance": "20",
-			"nonce": 1
-		},
-		"0000000000000000000000000000000000000002": {
+		"5ff73eaeec7013c9f18f2b954cd83e33c5234b3b": {
 			"balance": "44",
 			"nonce": 0
 		},
-		"0000000000000000000000000000000000000102": {
+		"7034adcca06964c0dffdb3eae70e8666faa5a63d": {
+			"balance": "20",
+			"nonce": 1
+		},
+		"d7dae24b1886aff022fa4d8283a28a7a9209583f": {
 			"balance": "2",
 			"nonce": 10
 		}
@@ -116,7 +132,7 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction_DoubleTr
 		createTransaction(obj1.Nonce(), obj1.address, obj2.address, 1),
 	}
 
-	failed, err := s.processor.ApplyTransactions(1, transactions)
+	failed, err := s.processor.ApplyTransactions(1, nil, nil, transactions)
 	assert.NoError(s.T(), err)
 	assert.True(s.T(), failed == 0)
 
@@ -125,17 +141,17 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction_DoubleTr
 	assert.Equal(s.T(), big.NewInt(20), s.state.GetBalance(obj1.address))
 
 	want := `{
-	"root": "7ed462059ad2df6754b5aa1f3d8a150bb9b0e1c4eb50b6217a8fc4ecbec7fb28",
+	"root": "203a44c3814790fb60f3d32d354df7cd5971fca0ad51272388184d324fab4f91",
 	"accounts": {
-		"0000000000000000000000000000000000000001": {
-			"balance": "20",
-			"nonce": 1
-		},
-		"0000000000000000000000000000000000000002": {
+		"5ff73eaeec7013c9f18f2b954cd83e33c5234b3b": {
 			"balance": "44",
 			"nonce": 0
 		},
-		"0000000000000000000000000000000000000102": {
+		"7034adcca06964c0dffdb3eae70e8666faa5a63d": {
+			"balance": "20",
+			"nonce": 1
+		},
+		"d7dae24b1886aff022fa4d8283a28a7a9209583f": {
 			"balance": "2",
 			"nonce": 10
 		}
@@ -156,7 +172,7 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction_Errors()
 		createTransaction(obj1.Nonce(), obj1.address, obj2.address, 1),
 	}
 
-	failed, err := s.processor.ApplyTransactions(1, transactions)
+	failed, err := s.processor.ApplyTransactions(1, nil, nil, transactions)
 	assert.NoError(s.T(), err)
 	assert.True(s.T(), failed == 0)
 
@@ -168,7 +184,7 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction_Errors()
 	assert.Error(s.T(), err)
 	assert.Equal(s.T(), err.Error(), ErrFunds)
 
-	addr := toAddr([]byte{0x01, 0x01})
+	_, addr := accountKey([]byte{0x01, 0x01})
 
 	//Test origin
 	err = s.processor.ApplyTransaction(createTransaction(obj1.Nonce(), addr, obj2.address, 21))
@@ -176,6 +192,38 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction_Errors()
 	assert.Equal(s.T(), err.Error(), ErrOrigin)
 }
 
+func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction_Signature() {
+	obj1 := createAccount(s.state, []byte{0x01}, 21, 0)
+	obj2 := createAccount(s.state, []byte{0x01, 02}, 1, 10)
+	s.state.Commit(false)
+
+	//unsigned transaction
+	err := s.processor.ApplyTransaction(createTransaction(obj1.Nonce(), toAddr([]byte{0x01}), obj2.address, 1))
+	assert.Error(s.T(), err)
+	assert.Equal(s.T(), ErrSignature, err.Error())
+
+	//the body was changed after signing
+	tx := createTransaction(obj1.Nonce(), obj1.address, obj2.address, 1)
+	tx.Amount = big.NewInt(20)
+	err = s.processor.ApplyTransaction(tx)
+	assert.Error(s.T(), err)
+	assert.Equal(s.T(), ErrSignature, err.Error())
+
+	//obj2 signs a transaction spending the funds of obj1
+	tx = createTransaction(obj2.Nonce(), obj2.address, obj2.address, 1)
+	tx.Origin = obj1.address
+	err = s.processor.ApplyTransaction(tx)
+	assert.Error(s.T(), err)
+	assert.Equal(s.T(), ErrSignature, err.Error())
+	assert.Equal(s.T(), big.NewInt(21), s.state.GetBalance(obj1.address))
+
+	tx = createTransaction(obj1.Nonce(), obj1.address, obj2.address, 1)
+	tx.Origin = address.Address{}
+	assert.NoError(s.T(), tx.VerifySignature())
+	assert.Equal(s.T(), obj1.address, tx.Origin, "the origin should be recovered from the signature")
+	assert.NoError(s.T(), s.processor.ApplyTransaction(tx))
+}
+
 func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction_OrderByNonce() {
 	obj1 := createAccount(s.state, []byte{0x01}, 5, 0)
 	obj2 := createAccount(s.state, []byte{0x01, 02}, 1, 10)
@@ -189,7 +237,7 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction_OrderByN
 		createTransaction(obj1.Nonce(), obj1.address, obj2.address, 1),
 	}
 
-	s.processor.ApplyTransactions(1, transactions)
+	s.processor.ApplyTransactions(1, nil, nil, transactions)
 	//assert.Error(s.T(), err)
 
 	got := string(s.state.Dump())
@@ -198,17 +246,17 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_ApplyTransaction_OrderByN
 	assert.Equal(s.T(), big.NewInt(2), s.state.GetBalance(obj2.address))
 
 	want := `{
-	"root": "e5212ec1f253fc4d7f77a591f66770ccae676ece70823287638ad7c5f988bced",
+	"root": "b095f175c4f34c1d459cfc343b988fd499b1e5d83f4f659f0921ef3a6448f83e",
 	"accounts": {
-		"0000000000000000000000000000000000000001": {
-			"balance": "1",
-			"nonce": 4
-		},
-		"0000000000000000000000000000000000000002": {
+		"5ff73eaeec7013c9f18f2b954cd83e33c5234b3b": {
 			"balance": "47",
 			"nonce": 0
 		},
-		"0000000000000000000000000000000000000102": {
+		"7034adcca06964c0dffdb3eae70e8666faa5a63d": {
+			"balance": "1",
+			"nonce": 4
+		},
+		"d7dae24b1886aff022fa4d8283a28a7a9209583f": {
 			"balance": "2",
 			"nonce": 10
 		}
@@ -230,7 +278,7 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_Reset() {
 		//createTransaction(obj2.Nonce(),obj2.address, obj1.address, 1),
 	}
 
-	failed, err := s.processor.ApplyTransactions(1, transactions)
+	failed, err := s.processor.ApplyTransactions(1, nil, nil, transactions)
 	assert.NoError(s.T(), err)
 	assert.True(s.T(), failed == 0)
 
@@ -239,24 +287,24 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_Reset() {
 		createTransaction(obj2.Nonce(), obj2.address, obj1.address, 10),
 	}
 
-	failed, err = s.processor.ApplyTransactions(2, transactions)
+	failed, err = s.processor.ApplyTransactions(2, nil, nil, transactions)
 	assert.True(s.T(), failed == 0)
 	assert.NoError(s.T(), err)
 
 	got := string(s.processor.globalState.Dump())
 
 	want := `{
-	"root": "ad5e89c8f94168027dc1df5da112c76c330ffcbc7df3e73c29f1728e488b77b2",
+	"root": "27ffe346868922243270a990adfab32e5dfb74281c1fefd5f91256cfbd86887f",
 	"accounts": {
-		"0000000000000000000000000000000000000001": {
-			"balance": "29",
-			"nonce": 2
-		},
-		"0000000000000000000000000000000000000002": {
+		"5ff73eaeec7013c9f18f2b954cd83e33c5234b3b": {
 			"balance": "44",
 			"nonce": 0
 		},
-		"0000000000000000000000000000000000000102": {
+		"7034adcca06964c0dffdb3eae70e8666faa5a63d": {
+			"balance": "29",
+			"nonce": 2
+		},
+		"d7dae24b1886aff022fa4d8283a28a7a9209583f": {
 			"balance": "33",
 			"nonce": 11
 		}
@@ -273,17 +321,17 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_Reset() {
 	assert.Equal(s.T(), big.NewInt(20), s.processor.globalState.GetBalance(obj1.address))
 
 	want = `{
-	"root": "0f8918fdba9e0a1542ac89ff9a60c657ff4c5800a700266ecfdcfc2d4e1fad3e",
+	"root": "a6826f2ec0459587f2862065f0c1d199254e3f9aa1d15c97c615cf7dc5910cad",
 	"accounts": {
-		"0000000000000000000000000000000000000001": {
-			"balance": "20",
-			"nonce": 1
-		},
-		"0000000000000000000000000000000000000002": {
+		"5ff73eaeec7013c9f18f2b954cd83e33c5234b3b": {
 			"balance": "44",
 			"nonce": 0
 		},
-		"0000000000000000000000000000000000000102": {
+		"7034adcca06964c0dffdb3eae70e8666faa5a63d": {
+			"balance": "20",
+			"nonce": 1
+		},
+		"d7dae24b1886aff022fa4d8283a28a7a9209583f": {
 			"balance": "42",
 			"nonce": 10
 		}
@@ -294,6 +342,252 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_Reset() {
 	}
 }
 
+func (s *ProcessorStateSuite) TestTransactionProcessor_ResetReorg() {
+	obj1 := createAccount(s.state, []byte{0x01}, 100, 0)
+	obj2 := createAccount(s.state, []byte{0x01, 02}, 5, 0)
+	s.state.Commit(false)
+
+	first := createTransaction(0, obj1.address, obj2.address, 10)
+	_, err := s.processor.ApplyTransactions(1, nil, nil, Transactions{first})
+	assert.NoError(s.T(), err)
+	_, err = s.processor.ApplyTransactions(2, nil, nil, nil)
+	assert.NoError(s.T(), err)
+	reverted := createTransaction(1, obj1.address, obj2.address, 20)
+	_, err = s.processor.ApplyTransactions(3, nil, nil, Transactions{reverted})
+	assert.NoError(s.T(), err)
+
+	//layer 2 had no transactions, its root is still recorded
+	assert.NoError(s.T(), s.processor.Reset(2))
+	assert.Equal(s.T(), big.NewInt(90), s.state.GetBalance(obj1.address), "the state object held by the caller should be reverted")
+	latest, err := s.processor.LatestLayer()
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), LayerID(2), latest)
+	_, err = s.processor.GetTransactionReceipt(reverted.Hash())
+	assert.Error(s.T(), err, "the receipt of a reverted transaction should be removed")
+	r, err := s.processor.GetTransactionReceipt(first.Hash())
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), LayerID(1), r.Layer)
+
+	//the layer is applied again with other transactions and can be reverted again
+	_, err = s.processor.ApplyTransactions(3, nil, nil, Transactions{createTransaction(1, obj1.address, obj2.address, 30)})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), big.NewInt(60), s.state.GetBalance(obj1.address))
+	assert.NoError(s.T(), s.processor.Reset(1))
+	assert.Equal(s.T(), big.NewInt(90), s.state.GetBalance(obj1.address))
+	assert.Equal(s.T(), len(s.processor.prevStates), s.processor.stateQueue.Len())
+
+	assert.Error(s.T(), s.processor.Reset(7), "a layer that was not applied cannot be reverted to")
+}
+
+func (s *ProcessorStateSuite) TestTransactionProcessor_Fees() {
+	obj1 := createAccount(s.state, []byte{0x01}, 100, 0)
+	obj2 := createAccount(s.state, []byte{0x01, 02}, 5, 0)
+	_, miner1 := accountKey([]byte{0x03})
+	_, miner2 := accountKey([]byte{0x04})
+	s.state.Commit(false)
+	s.processor.SetLayerReward(big.NewInt(50))
+
+	tx1 := createTransaction(obj1.Nonce(), obj1.address, obj2.address, 10)
+	tx1.Price = big.NewInt(2)
+	assert.NoError(s.T(), tx1.Sign(testKeys[obj1.address]))
+	tx2 := createTransaction(obj2.Nonce(), obj2.address, obj1.address, 1)
+	tx2.Price = big.NewInt(2)
+	assert.NoError(s.T(), tx2.Sign(testKeys[obj2.address]))
+	assert.Equal(s.T(), big.NewInt(20), tx1.Fee())
+
+	//miner1 mined two valid blocks in the layer, tx2 can not pay for its fee in any order
+	failed, err := s.processor.ApplyTransactions(1, nil, []address.Address{miner1, miner2, miner1}, Transactions{tx1, tx2})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), uint32(1), failed)
+
+	assert.Equal(s.T(), big.NewInt(70), s.state.GetBalance(obj1.address))
+	assert.Equal(s.T(), big.NewInt(15), s.state.GetBalance(obj2.address))
+	//(50 + 20) / 3 = 23 per block, the remainder is not issued
+	assert.Equal(s.T(), big.NewInt(46), s.state.GetBalance(miner1))
+	assert.Equal(s.T(), big.NewInt(23), s.state.GetBalance(miner2))
+
+	//the reward is issued for layers without transactions too
+	_, err = s.processor.ApplyTransactions(2, nil, []address.Address{miner2}, Transactions{})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), big.NewInt(73), s.state.GetBalance(miner2))
+
+	//without miners the fees are burned
+	tx3 := createTransaction(obj1.Nonce(), obj1.address, obj2.address, 10)
+	tx3.Price = big.NewInt(1)
+	assert.NoError(s.T(), tx3.Sign(testKeys[obj1.address]))
+	_, err = s.processor.ApplyTransactions(3, nil, nil, Transactions{tx3})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), big.NewInt(50), s.state.GetBalance(obj1.address))
+	assert.Equal(s.T(), big.NewInt(46), s.state.GetBalance(miner1))
+}
+
+func (s *ProcessorStateSuite) TestTransactionProcessor_Receipts() {
+	obj1 := createAccount(s.state, []byte{0x01}, 100, 0)
+	obj2 := createAccount(s.state, []byte{0x01, 02}, 5, 0)
+	_, unknown := accountKey([]byte{0x05})
+	s.state.Commit(false)
+
+	applied := createTransaction(obj1.Nonce(), obj1.address, obj2.address, 10)
+	applied.Price = big.NewInt(2)
+	assert.NoError(s.T(), applied.Sign(testKeys[obj1.address]))
+	funds := createTransaction(obj2.Nonce(), obj2.address, obj1.address, 50)
+	nonce := createTransaction(obj1.Nonce()+5, obj1.address, obj2.address, 1)
+	origin := createTransaction(0, unknown, obj1.address, 1)
+
+	failed, err := s.processor.ApplyTransactions(1, nil, nil, Transactions{applied, funds, nonce, origin})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), uint32(3), failed)
+
+	r, err := s.processor.GetTransactionReceipt(applied.Hash())
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), &Receipt{Layer: 1, Status: ReceiptApplied, Fee: big.NewInt(20)}, r)
+
+	for reason, tx := range map[string]*Transaction{ErrFunds: funds, ErrNonce: nonce, ErrOrigin: origin} {
+		r, err := s.processor.GetTransactionReceipt(tx.Hash())
+		assert.NoError(s.T(), err)
+		assert.Equal(s.T(), LayerID(1), r.Layer)
+		assert.Equal(s.T(), ReceiptFailed, r.Status)
+		assert.Equal(s.T(), reason, r.Reason)
+		assert.Equal(s.T(), 0, r.Fee.Sign())
+	}
+
+	_, err = s.processor.GetTransactionReceipt(createTransaction(7, obj1.address, obj2.address, 1).Hash())
+	assert.Error(s.T(), err, "the transaction was never applied")
+
+	//a later block includes the applied transaction again, it fails on its nonce in that layer
+	failed, err = s.processor.ApplyTransactions(2, nil, nil, Transactions{applied})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), uint32(1), failed)
+	r, err = s.processor.GetTransactionReceipt(applied.Hash())
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), &Receipt{Layer: 1, Status: ReceiptApplied, Fee: big.NewInt(20)}, r, "the receipt of the applied transaction should be kept")
+
+	//reverting the later layer does not remove the receipt either
+	assert.NoError(s.T(), s.processor.Reset(1))
+	r, err = s.processor.GetTransactionReceipt(applied.Hash())
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), LayerID(1), r.Layer)
+}
+
+func (s *ProcessorStateSuite) TestTransactionProcessor_AccountProof() {
+	obj1 := createAccount(s.state, []byte{0x01}, 100, 0)
+	obj2 := createAccount(s.state, []byte{0x01, 02}, 5, 3)
+	_, unknown := accountKey([]byte{0x05})
+	s.state.Commit(false)
+
+	_, err := s.processor.GetAccountProof(1, obj1.address)
+	assert.Error(s.T(), err, "layer 1 was not applied yet")
+
+	tx := createTransaction(obj1.Nonce(), obj1.address, obj2.address, 10)
+	_, err = s.processor.ApplyTransactions(1, nil, nil, Transactions{tx})
+	assert.NoError(s.T(), err)
+	tx = createTransaction(obj1.Nonce(), obj1.address, obj2.address, 20)
+	_, err = s.processor.ApplyTransactions(2, nil, nil, Transactions{tx})
+	assert.NoError(s.T(), err)
+
+	p, err := s.processor.GetAccountProof(1, obj1.address)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), big.NewInt(90), p.Balance, "the proof should be for the state of layer 1")
+	assert.Equal(s.T(), uint64(1), p.Nonce)
+	assert.NoError(s.T(), VerifyAccountProof(p))
+
+	p, err = s.processor.GetAccountProof(2, obj2.address)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), s.state.IntermediateRoot(false), p.Root)
+	assert.Equal(s.T(), big.NewInt(35), p.Balance)
+	assert.NoError(s.T(), VerifyAccountProof(p))
+
+	absent, err := s.processor.GetAccountProof(2, unknown)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(0), absent.Balance.Int64())
+	assert.NoError(s.T(), VerifyAccountProof(absent), "absence of an account should be provable")
+
+	p.Balance = big.NewInt(1000)
+	assert.Error(s.T(), VerifyAccountProof(p), "a wrong balance should not verify")
+	p.Balance = big.NewInt(35)
+	p.Nonce++
+	assert.Error(s.T(), VerifyAccountProof(p), "a wrong nonce should not verify")
+	p.Nonce--
+	p.Root[0]++
+	assert.Error(s.T(), VerifyAccountProof(p), "the proof should not verify against another root")
+	p.Root[0]--
+	p.Address = unknown
+	assert.Error(s.T(), VerifyAccountProof(p), "the proof should not verify for another account")
+	p.Address = obj2.address
+	p.Nodes = p.Nodes[1:]
+	assert.Error(s.T(), VerifyAccountProof(p), "the proof should not verify with missing nodes")
+}
+
+func (s *ProcessorStateSuite) TestTransactionProcessor_StateAt() {
+	obj1 := createAccount(s.state, []byte{0x01}, 100, 0)
+	obj2 := createAccount(s.state, []byte{0x01, 02}, 5, 0)
+	s.state.Commit(false)
+
+	_, err := s.processor.StateAt(0)
+	assert.Error(s.T(), err, "no layer was applied yet")
+
+	for l := LayerID(1); l <= 3; l++ {
+		_, err := s.processor.ApplyTransactions(l, nil, nil, Transactions{createTransaction(uint64(l-1), obj1.address, obj2.address, 10)})
+		assert.NoError(s.T(), err)
+	}
+	st, err := s.processor.StateAt(2)
+	assert.NoError(s.T(), err)
+	_, err = s.processor.ApplyTransactions(4, nil, nil, Transactions{createTransaction(3, obj1.address, obj2.address, 10)})
+	assert.NoError(s.T(), err)
+
+	assert.Equal(s.T(), big.NewInt(80), st.GetBalance(obj1.address), "the view should not change when more layers are applied")
+	assert.Equal(s.T(), uint64(2), st.GetNonce(obj1.address))
+	assert.Equal(s.T(), big.NewInt(60), s.processor.globalState.GetBalance(obj1.address))
+	for l, balance := range map[LayerID]int64{1: 15, 3: 35, 4: 45} {
+		st, err := s.processor.StateAt(l)
+		assert.NoError(s.T(), err)
+		assert.Equal(s.T(), big.NewInt(balance), st.GetBalance(obj2.address), "balance at layer %v", l)
+	}
+
+	_, err = s.processor.StateAt(5)
+	assert.Error(s.T(), err)
+	assert.NoError(s.T(), s.processor.Reset(2))
+	_, err = s.processor.StateAt(3)
+	assert.Error(s.T(), err, "a reverted layer should not be queried")
+}
+
+func (s *ProcessorStateSuite) TestTransactionProcessor_Restart() {
+	obj1 := createAccount(s.state, []byte{0x01}, 21, 0)
+	obj2 := createAccount(s.state, []byte{0x01, 02}, 41, 10)
+	s.state.Commit(false)
+
+	roots := database.NewTable(s.db, "roots/")
+	_, _, err := LoadLatestRoot(roots)
+	assert.Error(s.T(), err, "no layer was applied yet")
+
+	_, err = s.processor.ApplyTransactions(1, nil, nil, Transactions{createTransaction(obj1.Nonce(), obj1.address, obj2.address, 1)})
+	assert.NoError(s.T(), err)
+	_, err = s.processor.ApplyTransactions(2, nil, nil, Transactions{createTransaction(obj1.Nonce(), obj1.address, obj2.address, 1)})
+	assert.NoError(s.T(), err)
+	want := s.processor.globalState.IntermediateRoot(false)
+
+	//a new processor over the same database continues from the last applied layer
+	layer, root, err := LoadLatestRoot(roots)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), LayerID(2), layer)
+	assert.Equal(s.T(), want, root)
+	st, err := New(root, NewDatabase(s.db))
+	assert.NoError(s.T(), err)
+	restarted := NewTransactionProcessor(rand.New(mt19937.New()), st, roots, database.NewMemDatabase(), log.New("proc_logger", "", ""))
+	assert.Equal(s.T(), big.NewInt(19), st.GetBalance(obj1.address))
+	assert.Equal(s.T(), uint64(2), st.GetNonce(obj1.address))
+	latest, err := restarted.LatestLayer()
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), LayerID(2), latest)
+
+	//layers applied before the restart can be reverted to
+	restarted.Reset(1)
+	assert.Equal(s.T(), big.NewInt(20), restarted.globalState.GetBalance(obj1.address))
+	layer, _, err = LoadLatestRoot(roots)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), LayerID(1), layer, "the reverted layer should be loaded after the next restart")
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -344,7 +638,7 @@ func (s *ProcessorStateSuite) TestTransactionProcessor_Multilayer() {
 
 			log.Info("transaction %v nonce %v amount %v", t.Origin.Hex(), t.AccountNonce, t.Amount)
 		}
-		failed, err := s.processor.ApplyTransactions(LayerID(i), trns)
+		failed, err := s.processor.ApplyTransactions(LayerID(i), nil, nil, trns)
 		assert.NoError(s.T(), err)
 		assert.True(s.T(), failed == 0)
 
@@ -379,7 +673,7 @@ func TestTransactionProcessor_randomSort(t *testing.T) {
 	db := database.NewMemDatabase()
 	state, _ := New(common.Hash{}, NewDatabase(db))
 	lg := log.New("proc_logger", "", "")
-	processor := NewTransactionProcessor(rng, state, lg)
+	processor := NewTransactionProcessor(rng, state, database.NewMemDatabase(), database.NewMemDatabase(), lg)
 
 	obj1 := createAccount(state, []byte{0x01}, 2, 0)
 	obj2 := createAccount(state, []byte{0x01, 02}, 1, 10)
@@ -393,14 +687,112 @@ func TestTransactionProcessor_randomSort(t *testing.T) {
 	}
 
 	expected := Transactions{
-		transactions[4],
+		transactions[2],
 		transactions[3],
-		transactions[1],
 		transactions[0],
-		transactions[2],
+		transactions[4],
+		transactions[1],
 	}
 
-	trans := processor.randomSort(transactions)
-
+	trans := processor.randomSort(append(Transactions{}, transactions...))
 	assert.Equal(t, expected, trans)
+
+	//the result depends on the seed only, not on the order the transactions were received in
+	rng.Seed(1)
+	reversed := Transactions{transactions[4], transactions[3], transactions[2], transactions[1], transactions[0]}
+	assert.Equal(t, expected, processor.randomSort(reversed))
+}
+
+func TestTransactionProcessor_CrossNodeDeterminism(t *testing.T) {
+	//every node has its own database and generator, the generators start at different states
+	newNode := func(history int) *TransactionProcessor {
+		rng := rand.New(mt19937.New())
+		rng.Seed(int64(history))
+		for i := 0; i < history; i++ {
+			rng.Uint32()
+		}
+		st, _ := New(common.Hash{}, NewDatabase(database.NewMemDatabase()))
+		createAccount(st, []byte{0x01}, 20, 0)
+		createAccount(st, []byte{0x01, 0x02}, 20, 0)
+		createAccount(st, []byte{0x02}, 20, 0)
+		st.Commit(false)
+		return NewTransactionProcessor(rng, st, database.NewMemDatabase(), database.NewMemDatabase(), log.New("proc_logger", "", ""))
+	}
+	nodes := []*TransactionProcessor{newNode(0), newNode(7), newNode(1000)}
+
+	_, addr1 := accountKey([]byte{0x01})
+	_, addr2 := accountKey([]byte{0x01, 0x02})
+	_, addr3 := accountKey([]byte{0x02})
+	accounts := []address.Address{addr1, addr2, addr3}
+	r := rand.New(rand.NewSource(1))
+	for layer := LayerID(1); layer <= 10; layer++ {
+		//transactions compete for the same nonces and funds so their order changes the result
+		txs := Transactions{}
+		next := make(map[address.Address]uint64)
+		for _, a := range accounts {
+			next[a] = nodes[0].globalState.GetNonce(a)
+		}
+		for i := 0; i < 20; i++ {
+			src, dst := accounts[r.Intn(3)], accounts[r.Intn(3)]
+			nonce := next[src]
+			if r.Intn(3) > 0 {
+				next[src]++
+			}
+			txs = append(txs, createTransaction(nonce, src, dst, int64(r.Intn(15)+1)))
+		}
+		seed := crypto.Sha256(layer.ToBytes())
+
+		roots := make([]common.Hash, 0, len(nodes))
+		for i, n := range nodes {
+			//every node receives the transactions in a different order
+			received := append(Transactions{}, txs...)
+			rand.New(rand.NewSource(int64(i))).Shuffle(len(received), func(a, b int) {
+				received[a], received[b] = received[b], received[a]
+			})
+			_, err := n.ApplyTransactions(layer, seed, []address.Address{addr3}, received)
+			assert.NoError(t, err)
+			roots = append(roots, n.globalState.IntermediateRoot(false))
+		}
+		for _, root := range roots[1:] {
+			assert.Equal(t, roots[0], root, "nodes diverged in layer %v", layer)
+		}
+	}
+}
+
+func TestTransactionProcessor_SetSyncedState(t *testing.T) {
+	db := database.NewMemDatabase()
+	st, err := New(common.Hash{}, NewDatabase(db))
+	assert.NoError(t, err)
+	processor := NewTransactionProcessor(rand.New(mt19937.New()), st, database.NewTable(db, "roots/"), database.NewMemDatabase(), log.New("synced", "", ""))
+	obj1 := createAccount(st, []byte{0x01}, 100, 0)
+	obj2 := createAccount(st, []byte{0x02}, 50, 0)
+	st.Commit(false)
+	_, err = processor.ApplyTransactions(1, nil, nil, Transactions{createTransaction(0, obj1.address, obj2.address, 10)})
+	assert.NoError(t, err)
+	root := st.IntermediateRoot(false)
+
+	//the synced node shares the nodes of the state but has not applied any layer
+	roots := database.NewTable(db, "synced-roots/")
+	synced, err := New(common.Hash{}, NewDatabase(db))
+	assert.NoError(t, err)
+	syncedProcessor := NewTransactionProcessor(rand.New(mt19937.New()), synced, roots, database.NewMemDatabase(), log.New("synced", "", ""))
+	assert.Error(t, syncedProcessor.SetSyncedState(1, common.BytesToHash([]byte{0x01})), "the state is not in the database")
+	_, err = syncedProcessor.LatestLayer()
+	assert.Error(t, err)
+
+	assert.NoError(t, syncedProcessor.SetSyncedState(1, root))
+	layer, err := syncedProcessor.LatestLayer()
+	assert.NoError(t, err)
+	assert.Equal(t, LayerID(1), layer)
+	assert.Equal(t, big.NewInt(90), synced.GetBalance(obj1.address))
+	assert.Equal(t, big.NewInt(60), synced.GetBalance(obj2.address))
+	assert.Error(t, syncedProcessor.SetSyncedState(2, root), "the state was already set")
+
+	_, err = syncedProcessor.ApplyTransactions(1, nil, nil, Transactions{})
+	assert.Error(t, err, "the synced layer is already part of the state")
+	_, err = processor.ApplyTransactions(2, nil, nil, Transactions{createTransaction(1, obj1.address, obj2.address, 10)})
+	assert.NoError(t, err)
+	_, err = syncedProcessor.ApplyTransactions(2, nil, nil, Transactions{createTransaction(1, obj1.address, obj2.address, 10)})
+	assert.NoError(t, err)
+	assert.Equal(t, st.IntermediateRoot(false), synced.IntermediateRoot(false))
 }
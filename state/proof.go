@@ -0,0 +1,71 @@
+package state
+
+import (
+	"fmt"
+	"github.com/spacemeshos/go-spacemesh/address"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/rlp"
+	"github.com/spacemeshos/go-spacemesh/trie"
+	"math/big"
+)
+
+// AccountProof holds the balance and nonce of an account in the state produced by a layer,
+// together with the trie nodes that prove them against the state root of that layer
+type AccountProof struct {
+	Address address.Address
+	Layer   LayerID
+	Root    common.Hash
+	Balance *big.Int
+	Nonce   uint64
+	Nodes   [][]byte //rlp encoded trie nodes on the path from the root to the account
+}
+
+// proofList collects the nodes written by Trie.Prove, the verifier keys them by their hash again
+type proofList [][]byte
+
+func (l *proofList) Put(key []byte, value []byte) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// GetProof returns the trie nodes proving the account of addr, or its absence, against the root of the state.
+// the proof only covers committed changes
+func (self *StateDB) GetProof(addr address.Address) ([][]byte, error) {
+	var proof proofList
+	//the state is kept in a secure trie, accounts are keyed by the hash of their address
+	if err := self.globalTrie.Prove(crypto.Keccak256(addr.Bytes()), 0, &proof); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// VerifyAccountProof checks that the nodes of p prove its balance and nonce against p.Root, an account that is
+// not in the state is proven with a zero balance and nonce. the proof only shows the account is consistent with
+// the root, the root itself has to be obtained from a source the caller trusts
+func VerifyAccountProof(p *AccountProof) error {
+	if p.Balance == nil {
+		return fmt.Errorf("proof has no balance")
+	}
+	nodes := database.NewMemDatabase()
+	for _, n := range p.Nodes {
+		if err := nodes.Put(crypto.Keccak256(n), n); err != nil {
+			return err
+		}
+	}
+	enc, _, err := trie.VerifyProof(p.Root, crypto.Keccak256(p.Address.Bytes()), nodes)
+	if err != nil {
+		return err
+	}
+	acc := Account{Balance: new(big.Int)}
+	if enc != nil {
+		if err := rlp.DecodeBytes(enc, &acc); err != nil {
+			return fmt.Errorf("corrupted account in proof %v", err)
+		}
+	}
+	if acc.Nonce != p.Nonce || acc.Balance.Cmp(p.Balance) != 0 {
+		return fmt.Errorf("root %x proves balance %v and nonce %v for %x", p.Root, acc.Balance, acc.Nonce, p.Address)
+	}
+	return nil
+}
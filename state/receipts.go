@@ -0,0 +1,96 @@
+package state
+
+import (
+	"fmt"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/rlp"
+	"math/big"
+)
+
+type ReceiptStatus uint8
+
+const (
+	ReceiptFailed ReceiptStatus = iota
+	ReceiptApplied
+)
+
+func (s ReceiptStatus) String() string {
+	switch s {
+	case ReceiptApplied:
+		return "applied"
+	case ReceiptFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(s))
+}
+
+// Receipt records the outcome of a transaction in the layer it was applied in
+type Receipt struct {
+	Layer  LayerID
+	Status ReceiptStatus
+	Reason string   //the error the transaction was aborted with, one of ErrOrigin, ErrFunds, ErrNonce or ErrSignature
+	Fee    *big.Int //the fee paid to the miners, zero for failed transactions
+}
+
+// layerReceiptsKey is the receipts database key of the hashes of the transactions applied in a layer
+func layerReceiptsKey(l LayerID) []byte { return append([]byte("l"), l.ToBytes()...) }
+
+// writeReceipts persists the receipts of the transactions applied in layer. a transaction that was applied in an
+// earlier layer keeps its receipt when it is included again, e.g. by a later block, and fails on its nonce
+func writeReceipts(db database.DB, layer LayerID, receipts map[common.Hash]*Receipt) error {
+	hashes := make([]common.Hash, 0, len(receipts))
+	for h, r := range receipts {
+		if prev, err := getReceipt(db, h); err == nil && prev.Status == ReceiptApplied && prev.Layer < layer {
+			continue
+		}
+		r.Layer = layer
+		b, err := rlp.EncodeToBytes(r)
+		if err != nil {
+			return err
+		}
+		if err := db.Put(h.Bytes(), b); err != nil {
+			return err
+		}
+		hashes = append(hashes, h)
+	}
+	b, err := rlp.EncodeToBytes(hashes)
+	if err != nil {
+		return err
+	}
+	return db.Put(layerReceiptsKey(layer), b)
+}
+
+// deleteReceipts removes the receipts written for layer when it is reverted, receipts that were overwritten by
+// another layer since are kept
+func deleteReceipts(db database.DB, layer LayerID) error {
+	b, err := db.Get(layerReceiptsKey(layer))
+	if err != nil {
+		return nil //no transactions were applied in the layer
+	}
+	var hashes []common.Hash
+	if err := rlp.DecodeBytes(b, &hashes); err != nil {
+		return fmt.Errorf("corrupted receipts index of layer %v %v", layer, err)
+	}
+	for _, h := range hashes {
+		if r, err := getReceipt(db, h); err != nil || r.Layer != layer {
+			continue
+		}
+		if err := db.Delete(h.Bytes()); err != nil {
+			return err
+		}
+	}
+	return db.Delete(layerReceiptsKey(layer))
+}
+
+func getReceipt(db database.DB, hash common.Hash) (*Receipt, error) {
+	b, err := db.Get(hash.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("no receipt for transaction %x %v", hash, err)
+	}
+	r := &Receipt{}
+	if err := rlp.DecodeBytes(b, r); err != nil {
+		return nil, fmt.Errorf("corrupted receipt for transaction %x %v", hash, err)
+	}
+	return r, nil
+}
@@ -0,0 +1,49 @@
+package state
+
+import (
+	"fmt"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/database"
+)
+
+// latestRootKey is the roots database key of the last applied layer
+var latestRootKey = []byte("latest")
+
+func (l LayerID) ToBytes() []byte { return common.Uint64ToBytes(uint64(l)) }
+
+// rootKey is the roots database key under which the state root of a layer is stored
+func rootKey(l LayerID) []byte { return append([]byte("r"), l.ToBytes()...) }
+
+// LoadLatestRoot returns the last applied layer persisted in roots and the state root it produced,
+// it returns an error if no layer was applied yet
+func LoadLatestRoot(roots database.DB) (LayerID, common.Hash, error) {
+	b, err := roots.Get(latestRootKey)
+	if err != nil {
+		return 0, common.Hash{}, err
+	}
+	if len(b) != 8 {
+		return 0, common.Hash{}, fmt.Errorf("corrupted latest layer %x", b)
+	}
+	layer := LayerID(common.BytesToUint64(b))
+	root, err := getRoot(roots, layer)
+	if err != nil {
+		return 0, common.Hash{}, err
+	}
+	return layer, root, nil
+}
+
+func getRoot(roots database.DB, layer LayerID) (common.Hash, error) {
+	b, err := roots.Get(rootKey(layer))
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("no state root for layer %v %v", layer, err)
+	}
+	return common.BytesToHash(b), nil
+}
+
+// setLatestRoot records root as the state of layer and marks layer as the last applied one
+func setLatestRoot(roots database.DB, layer LayerID, root common.Hash) error {
+	if err := roots.Put(rootKey(layer), root.Bytes()); err != nil {
+		return err
+	}
+	return roots.Put(latestRootKey, layer.ToBytes())
+}
@@ -0,0 +1,117 @@
+package state
+
+import (
+	"fmt"
+	"github.com/spacemeshos/go-spacemesh/address"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/rlp"
+	"github.com/spacemeshos/go-spacemesh/trie"
+	"io"
+	"math/big"
+)
+
+const snapshotMagic = "spacemesh-state"
+const snapshotVersion = 1
+
+// snapshotHeader starts a state snapshot file, it is followed by the accounts of the state in trie order
+type snapshotHeader struct {
+	Magic   string
+	Version uint32
+	Layer   LayerID
+	Root    common.Hash
+}
+
+type snapshotAccount struct {
+	Address address.Address
+	Nonce   uint64
+	Balance *big.Int
+}
+
+// ExportSnapshot writes the accounts of the state produced by layer to w, the state root of the layer is looked up
+// in roots. it returns the number of exported accounts
+func ExportSnapshot(w io.Writer, db Database, roots database.DB, layer LayerID) (int, error) {
+	root, err := getRoot(roots, layer)
+	if err != nil {
+		return 0, err
+	}
+	st, err := New(root, db)
+	if err != nil {
+		return 0, fmt.Errorf("could not open state of layer %v %v", layer, err)
+	}
+	if err := rlp.Encode(w, &snapshotHeader{Magic: snapshotMagic, Version: snapshotVersion, Layer: layer, Root: root}); err != nil {
+		return 0, fmt.Errorf("could not write snapshot header %v", err)
+	}
+
+	n := 0
+	it := trie.NewIterator(st.globalTrie.NodeIterator(nil))
+	for it.Next() {
+		addr := st.globalTrie.GetKey(it.Key)
+		if addr == nil {
+			return n, fmt.Errorf("missing address of account %x", it.Key)
+		}
+		var data Account
+		if err := rlp.DecodeBytes(it.Value, &data); err != nil {
+			return n, fmt.Errorf("corrupted account %x %v", addr, err)
+		}
+		acc := snapshotAccount{Address: address.BytesToAddress(addr), Nonce: data.Nonce, Balance: data.Balance}
+		if err := rlp.Encode(w, &acc); err != nil {
+			return n, fmt.Errorf("could not write account %x %v", addr, err)
+		}
+		n++
+	}
+	return n, it.Err
+}
+
+// ImportSnapshot reads a snapshot written by ExportSnapshot into db and records its root as the state of the
+// snapshot's layer in roots. the state is only written once the root of the imported accounts matches the root
+// of the snapshot, the target must not hold a state already
+func ImportSnapshot(r io.Reader, db Database, roots database.DB) (LayerID, common.Hash, error) {
+	if layer, _, err := LoadLatestRoot(roots); err == nil {
+		return 0, common.Hash{}, fmt.Errorf("database already holds the state of layer %v", layer)
+	}
+
+	s := rlp.NewStream(r, 0)
+	var hdr snapshotHeader
+	if err := s.Decode(&hdr); err != nil {
+		return 0, common.Hash{}, fmt.Errorf("could not read snapshot header %v", err)
+	}
+	if hdr.Magic != snapshotMagic {
+		return 0, common.Hash{}, fmt.Errorf("not a state snapshot file")
+	}
+	if hdr.Version != snapshotVersion {
+		return 0, common.Hash{}, fmt.Errorf("unsupported snapshot version %v", hdr.Version)
+	}
+
+	st, err := New(common.Hash{}, db)
+	if err != nil {
+		return 0, common.Hash{}, err
+	}
+	for {
+		var acc snapshotAccount
+		err := s.Decode(&acc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, common.Hash{}, fmt.Errorf("could not read account %v", err)
+		}
+		st.SetBalance(acc.Address, acc.Balance)
+		st.SetNonce(acc.Address, acc.Nonce)
+	}
+
+	root, err := st.Commit(false)
+	if err != nil {
+		return 0, common.Hash{}, err
+	}
+	if root != hdr.Root {
+		return 0, common.Hash{}, fmt.Errorf("imported state root %x does not match the snapshot root %x", root, hdr.Root)
+	}
+	if err := st.TrieDB().Commit(root, false); err != nil {
+		return 0, common.Hash{}, fmt.Errorf("could not write state %v", err)
+	}
+	if err := setLatestRoot(roots, hdr.Layer, root); err != nil {
+		return 0, common.Hash{}, err
+	}
+	return hdr.Layer, root, nil
+}
@@ -0,0 +1,107 @@
+package state
+
+import (
+	"bytes"
+	"github.com/seehuhn/mt19937"
+	"github.com/spacemeshos/go-spacemesh/address"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/rlp"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+func TestSnapshot_ExportImport(t *testing.T) {
+	db := database.NewMemDatabase()
+	roots := database.NewTable(db, "roots/")
+	st, err := New(common.Hash{}, NewDatabase(db))
+	assert.NoError(t, err)
+	processor := NewTransactionProcessor(rand.New(mt19937.New()), st, roots, database.NewMemDatabase(), log.New("snapshot", "", ""))
+
+	obj1 := createAccount(st, []byte{0x01}, 100, 0)
+	obj2 := createAccount(st, []byte{0x02}, 50, 4)
+	_, miner := accountKey([]byte{0x03})
+	st.Commit(false)
+	processor.SetLayerReward(big.NewInt(7))
+	_, err = processor.ApplyTransactions(1, nil, []address.Address{miner}, Transactions{createTransaction(0, obj1.address, obj2.address, 10)})
+	assert.NoError(t, err)
+	_, err = processor.ApplyTransactions(2, nil, []address.Address{miner}, Transactions{createTransaction(4, obj2.address, obj1.address, 30)})
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	n, err := ExportSnapshot(&buf, NewDatabase(db), roots, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	_, err = ExportSnapshot(&bytes.Buffer{}, NewDatabase(db), roots, 3)
+	assert.Error(t, err, "layer 3 was not applied")
+
+	target := database.NewMemDatabase()
+	targetRoots := database.NewTable(target, "roots/")
+	layer, root, err := ImportSnapshot(bytes.NewReader(buf.Bytes()), NewDatabase(target), targetRoots)
+	assert.NoError(t, err)
+	assert.Equal(t, LayerID(1), layer)
+	expected, err := getRoot(roots, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, root)
+
+	//the imported state can be loaded the same way a node loads its state on startup
+	l, r, err := LoadLatestRoot(targetRoots)
+	assert.NoError(t, err)
+	assert.Equal(t, LayerID(1), l)
+	imported, err := New(r, NewDatabase(target))
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewInt(90), imported.GetBalance(obj1.address))
+	assert.Equal(t, uint64(1), imported.GetNonce(obj1.address))
+	assert.Equal(t, big.NewInt(60), imported.GetBalance(obj2.address))
+	assert.Equal(t, big.NewInt(7), imported.GetBalance(miner))
+
+	_, _, err = ImportSnapshot(bytes.NewReader(buf.Bytes()), NewDatabase(target), targetRoots)
+	assert.Error(t, err, "the target already holds a state")
+
+	//layers up to the layer of the snapshot are part of the imported state and can not be applied on top of it
+	targetProcessor := NewTransactionProcessor(rand.New(mt19937.New()), imported, targetRoots, database.NewMemDatabase(), log.New("snapshot", "", ""))
+	targetProcessor.SetLayerReward(big.NewInt(7))
+	_, err = targetProcessor.ApplyTransactions(0, nil, []address.Address{miner}, Transactions{})
+	assert.Error(t, err)
+	_, err = targetProcessor.ApplyTransactions(1, nil, nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, big.NewInt(7), imported.GetBalance(miner))
+	l, r, err = LoadLatestRoot(targetRoots)
+	assert.NoError(t, err)
+	assert.Equal(t, LayerID(1), l, "the latest layer should not move backwards")
+	assert.Equal(t, root, r)
+
+	_, err = targetProcessor.ApplyTransactions(2, nil, []address.Address{miner}, Transactions{createTransaction(4, obj2.address, obj1.address, 30)})
+	assert.NoError(t, err)
+	expected, err = getRoot(roots, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, imported.IntermediateRoot(false), "the next layer should be applied on top of the imported state")
+}
+
+func TestSnapshot_ImportInvalid(t *testing.T) {
+	importInto := func(b []byte) error {
+		_, _, err := ImportSnapshot(bytes.NewReader(b), NewDatabase(database.NewMemDatabase()), database.NewMemDatabase())
+		return err
+	}
+	assert.Error(t, importInto([]byte("garbage")))
+
+	hdr, err := rlp.EncodeToBytes(&snapshotHeader{Magic: snapshotMagic, Version: snapshotVersion + 1})
+	assert.NoError(t, err)
+	assert.Error(t, importInto(hdr), "unsupported version")
+
+	//an account that is not part of the state the root commits to
+	_, addr := accountKey([]byte{0x01})
+	hdr, err = rlp.EncodeToBytes(&snapshotHeader{Magic: snapshotMagic, Version: snapshotVersion, Layer: 5, Root: common.HexToHash("0102")})
+	assert.NoError(t, err)
+	acc, err := rlp.EncodeToBytes(&snapshotAccount{Address: addr, Nonce: 1, Balance: big.NewInt(10)})
+	assert.NoError(t, err)
+	target := database.NewMemDatabase()
+	roots := database.NewTable(target, "roots/")
+	_, _, err = ImportSnapshot(bytes.NewReader(append(hdr, acc...)), NewDatabase(target), roots)
+	assert.Error(t, err, "the root does not match")
+	_, _, err = LoadLatestRoot(roots)
+	assert.Error(t, err, "nothing should be recorded for a snapshot that does not match its root")
+}
@@ -17,6 +17,7 @@ type MessageServer server.MessageServer
 
 const BlockProtocol = "/blocks/1.0/"
 const NewBlockProtocol = "newBlock"
+const EvidenceProtocol = "evidence"
 
 type BlockListener struct {
 	*server.MessageServer
@@ -28,6 +29,9 @@ type BlockListener struct {
 	semaphore            chan struct{}
 	unknownQueue         chan mesh.BlockID //todo consider benefits of changing to stack
 	receivedGossipBlocks chan service.GossipMessage
+	receivedEvidence     chan service.GossipMessage
+	equivocations        mesh.EventChannel
+	network              server.Service
 	startLock            uint32
 	timeout              time.Duration
 	exit                 chan struct{}
@@ -40,12 +44,14 @@ type TickProvider interface {
 
 func (bl *BlockListener) Close() {
 	close(bl.exit)
+	bl.Mesh.Unsubscribe(bl.equivocations)
 }
 
 func (bl *BlockListener) Start() {
 	if atomic.CompareAndSwapUint32(&bl.startLock, 0, 1) {
 		go bl.run()
 		go bl.ListenToGossipBlocks()
+		go bl.ListenToEvidence()
 		go bl.onTick()
 	}
 }
@@ -66,6 +72,9 @@ func NewBlockListener(net server.Service, bv BlockValidator, layers *mesh.Mesh,
 		unknownQueue:         make(chan mesh.BlockID, 200), //todo tune buffer size + get buffer from config
 		exit:                 make(chan struct{}),
 		receivedGossipBlocks: net.RegisterGossipProtocol(NewBlockProtocol),
+		receivedEvidence:     net.RegisterGossipProtocol(EvidenceProtocol),
+		equivocations:        layers.Subscribe(16, mesh.Equivocation),
+		network:              net,
 		tick:                 clock.Subscribe(),
 	}
 	bl.RegisterMsgHandler(BLOCK, newBlockRequestHandler(layers, logger))
@@ -87,6 +96,18 @@ func (bl *BlockListener) ListenToGossipBlocks() {
 				break
 			}
 
+			if !blk.ValidId() {
+				log.Error("received block %v with content that does not match its id", blk.ID())
+				data.ReportValidation(NewBlockProtocol, false)
+				break
+			}
+
+			if !blk.ValidSignature() {
+				log.Error("received block %v with an invalid signature of miner %v", blk.ID(), blk.MinerID)
+				data.ReportValidation(NewBlockProtocol, false)
+				break
+			}
+
 			if bl.BlockEligible(blk.LayerIndex, blk.MinerID) {
 				data.ReportValidation(NewBlockProtocol, true)
 				err := bl.AddBlock(&blk)
@@ -102,6 +123,51 @@ func (bl *BlockListener) ListenToGossipBlocks() {
 	}
 }
 
+// ListenToEvidence propagates the gossiped evidence of miners that built more than one block in a layer once it
+// was validated and recorded, and gossips the evidence found by this node
+func (bl *BlockListener) ListenToEvidence() {
+	for {
+		select {
+		case <-bl.exit:
+			bl.Log.Info("listening to evidence stopped")
+			return
+		case data := <-bl.receivedEvidence:
+			e, err := mesh.BytesAsEvidence(bytes.NewReader(data.Bytes()))
+			if err != nil {
+				bl.Log.Error("received invalid evidence %v", err)
+				data.ReportValidation(EvidenceProtocol, false)
+				break
+			}
+			if !bl.BlockEligible(e.Layer, e.MinerID) {
+				data.ReportValidation(EvidenceProtocol, false)
+				break
+			}
+			added, err := bl.AddEvidence(e)
+			if err != nil {
+				bl.Log.Error("could not add evidence against %v in layer %v %v", e.MinerID, e.Layer, err)
+			}
+			data.ReportValidation(EvidenceProtocol, added)
+		case ev, ok := <-bl.equivocations:
+			if !ok {
+				return
+			}
+			e, err := bl.EvidenceOf(ev.Block)
+			if err != nil {
+				bl.Log.Error("could not read evidence of block %v %v", ev.Block, err)
+				break
+			}
+			payload, err := mesh.EvidenceAsBytes(e)
+			if err != nil {
+				bl.Log.Error("could not encode evidence %v", err)
+				break
+			}
+			if err := bl.network.Broadcast(EvidenceProtocol, payload); err != nil {
+				bl.Log.Error("could not gossip evidence against %v in layer %v %v", e.MinerID, e.Layer, err)
+			}
+		}
+	}
+}
+
 func (bl *BlockListener) run() {
 	for {
 		select {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/spacemeshos/go-spacemesh/address"
+	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/mesh"
 	"github.com/spacemeshos/go-spacemesh/p2p"
@@ -50,12 +51,13 @@ func TestBlockListener(t *testing.T) {
 	bl2 := ListenerFactory(n2, PeersMock{func() []p2p.Peer { return []p2p.Peer{n1.PublicKey()} }}, "2")
 	bl2.Start()
 
-	block1 := mesh.NewExistingBlock(mesh.BlockID(123), 0, nil)
-	block2 := mesh.NewExistingBlock(mesh.BlockID(321), 1, nil)
-	block3 := mesh.NewExistingBlock(mesh.BlockID(222), 2, nil)
+	block1 := newSignedBlock(true, []byte("data123"), time.Now(), 0)
+	block2 := newSignedBlock(true, []byte("data321"), time.Now(), 1)
+	block3 := newSignedBlock(true, []byte("data222"), time.Now(), 2)
 
 	block1.AddView(block2.ID())
 	block1.AddView(block3.ID())
+	signBlock(block1)
 
 	bl1.AddBlock(block1)
 	bl1.AddBlock(block2)
@@ -91,29 +93,39 @@ func TestBlockListener2(t *testing.T) {
 
 	bl2.Start()
 
-	block1 := mesh.NewBlock(true, nil, time.Now(), 0)
-	block2 := mesh.NewBlock(true, nil, time.Now(), 1)
-	block3 := mesh.NewBlock(true, nil, time.Now(), 2)
-	block4 := mesh.NewBlock(true, nil, time.Now(), 2)
-	block5 := mesh.NewBlock(true, nil, time.Now(), 3)
-	block6 := mesh.NewBlock(true, nil, time.Now(), 3)
-	block7 := mesh.NewBlock(true, nil, time.Now(), 4)
-	block8 := mesh.NewBlock(true, nil, time.Now(), 4)
-	block9 := mesh.NewBlock(true, nil, time.Now(), 4)
-	block10 := mesh.NewBlock(true, nil, time.Now(), 5)
+	block1 := newSignedBlock(true, nil, time.Now(), 0)
+	block2 := newSignedBlock(true, nil, time.Now(), 1)
+	block3 := newSignedBlock(true, nil, time.Now(), 2)
+	block4 := newSignedBlock(true, nil, time.Now(), 2)
+	block5 := newSignedBlock(true, nil, time.Now(), 3)
+	block6 := newSignedBlock(true, nil, time.Now(), 3)
+	block7 := newSignedBlock(true, nil, time.Now(), 4)
+	block8 := newSignedBlock(true, nil, time.Now(), 4)
+	block9 := newSignedBlock(true, nil, time.Now(), 4)
+	block10 := newSignedBlock(true, nil, time.Now(), 5)
 
+	//the id of a block changes with its view so every block is completed before it is viewed
 	block2.AddView(block1.ID())
+	signBlock(block2)
 	block3.AddView(block2.ID())
+	signBlock(block3)
 	block4.AddView(block2.ID())
+	signBlock(block4)
 	block5.AddView(block3.ID())
 	block5.AddView(block4.ID())
+	signBlock(block5)
 	block6.AddView(block4.ID())
+	signBlock(block6)
 	block7.AddView(block6.ID())
 	block7.AddView(block5.ID())
+	signBlock(block7)
 	block8.AddView(block6.ID())
+	signBlock(block8)
 	block9.AddView(block5.ID())
+	signBlock(block9)
 	block10.AddView(block8.ID())
 	block10.AddView(block9.ID())
+	signBlock(block10)
 
 	bl1.AddBlock(block1)
 	bl1.AddBlock(block2)
@@ -156,11 +168,13 @@ func TestBlockListener_ListenToGossipBlocks(t *testing.T) {
 	bl1.Start()
 	bl2.Start()
 
-	blk := mesh.NewBlock(false, nil, time.Now(), 1)
-	tx := mesh.NewSerializableTransaction(0, address.BytesToAddress([]byte{0x01}), address.BytesToAddress([]byte{0x02}), big.NewInt(10), big.NewInt(10), 10)
+	blk := newSignedBlock(false, nil, time.Now(), 1)
+	tx := mesh.NewSerializableTransaction(0, address.BytesToAddress([]byte{0x02}), big.NewInt(10), big.NewInt(10), 10)
+	assert.NoError(t, tx.Sign(minerPriv))
 	blk.AddTransaction(tx)
 	blk.AddVote(1)
 	blk.AddView(2)
+	signBlock(blk)
 
 	data, err := mesh.BlockAsBytes(*blk)
 	blk2, ok := mesh.BytesAsBlock(bytes.NewReader(data))
@@ -190,3 +204,87 @@ func TestBlockListener_ListenToGossipBlocks(t *testing.T) {
 }
 
 //todo integration testing
+
+func TestBlockListener_ListenToGossipBlocksContentMismatch(t *testing.T) {
+	sim := service.NewSimulator()
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+
+	bl1 := ListenerFactory(n1, PeersMock{func() []p2p.Peer { return []p2p.Peer{n2.PublicKey()} }}, "6")
+	bl1.Start()
+
+	blk := newSignedBlock(false, []byte("data"), time.Now(), 1)
+	blk.Data = []byte("tampered data")
+
+	data, err := mesh.BlockAsBytes(*blk)
+	assert.NoError(t, err)
+	n2.Broadcast(NewBlockProtocol, data)
+
+	time.Sleep(500 * time.Millisecond)
+	_, err = bl1.GetBlock(blk.Id)
+	assert.Error(t, err, "block with content that does not match its id should not be added")
+}
+
+func TestBlockListener_ListenToGossipBlocksBadSignature(t *testing.T) {
+	sim := service.NewSimulator()
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+
+	bl1 := ListenerFactory(n1, PeersMock{func() []p2p.Peer { return []p2p.Peer{n2.PublicKey()} }}, "7")
+	bl1.Start()
+
+	blk := newSignedBlock(false, []byte("data"), time.Now(), 1)
+	_, otherPub, _ := crypto.GenerateKeyPair()
+	blk.MinerID = otherPub.String()
+	blk.Id = blk.CalcId()
+
+	data, err := mesh.BlockAsBytes(*blk)
+	assert.NoError(t, err)
+	n2.Broadcast(NewBlockProtocol, data)
+
+	time.Sleep(500 * time.Millisecond)
+	_, err = bl1.GetBlock(blk.Id)
+	assert.Error(t, err, "block not signed by its miner should not be added")
+}
+
+func TestBlockListener_Evidence(t *testing.T) {
+	sim := service.NewSimulator()
+	n1 := sim.NewNode()
+	n2 := sim.NewNode()
+	bl1 := ListenerFactory(n1, PeersMock{func() []p2p.Peer { return []p2p.Peer{n2.PublicKey()} }}, "8")
+	bl2 := ListenerFactory(n2, PeersMock{func() []p2p.Peer { return []p2p.Peer{n1.PublicKey()} }}, "9")
+	bl1.Start()
+	bl2.Start()
+	defer bl1.Close()
+	defer bl2.Close()
+
+	blk1 := newSignedBlock(true, []byte("data1"), time.Now(), 1)
+	blk2 := newSignedBlock(true, []byte("data2"), time.Now(), 1)
+	blk2.MinerID = blk1.MinerID
+	signBlock(blk2)
+
+	forged := &mesh.Evidence{Layer: 1, MinerID: blk1.MinerID, First: *blk1, Second: *blk1}
+	data, err := mesh.EvidenceAsBytes(forged)
+	assert.NoError(t, err)
+	assert.NoError(t, n1.Broadcast(EvidenceProtocol, data))
+	time.Sleep(200 * time.Millisecond)
+	assert.False(t, bl2.IsExcluded(blk1.ID()), "evidence with a single block should be rejected")
+
+	//the first node finds that the miner built two blocks in the layer and gossips the evidence
+	assert.NoError(t, bl1.AddBlock(blk1))
+	assert.NoError(t, bl1.AddBlock(blk2))
+	assert.True(t, bl1.IsExcluded(blk2.ID()))
+
+	timeout := time.After(2 * time.Second)
+	for !bl2.IsExcluded(blk1.ID()) {
+		select {
+		case <-timeout:
+			t.Fatal("evidence was not received")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	e, err := bl2.Evidence()
+	assert.NoError(t, err)
+	assert.Len(t, e, 1)
+	assert.True(t, bl2.IsExcluded(blk2.ID()))
+}
@@ -0,0 +1,204 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gogo/protobuf/proto"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/p2p"
+	"github.com/spacemeshos/go-spacemesh/p2p/config"
+	"github.com/spacemeshos/go-spacemesh/p2p/server"
+	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/spacemeshos/go-spacemesh/sync/pb"
+	"github.com/spacemeshos/go-spacemesh/trie"
+	"time"
+)
+
+const StateProtocol = "/state/1.0/"
+
+const (
+	TRIE_NODES   server.MessageType = 1
+	maxTrieNodes                    = 384 //number of trie nodes requested from a single peer at once
+	maxStalls                       = 3   //rounds in a row in which no peer delivered a node before a state sync gives up
+)
+
+// StateSyncer serves the trie nodes of the local state to peers and fetches the state of a given root from them.
+// nodes are read from and written to the database backing the state trie
+type StateSyncer struct {
+	p2p.Peers
+	log.Log
+	*server.MessageServer
+	db          database.Database
+	concurrency int //number of requests in flight
+	timeout     time.Duration
+}
+
+func NewStateSync(srv server.Service, db database.Database, timeout time.Duration, concurrency int, logger log.Log) *StateSyncer {
+	s := StateSyncer{
+		Peers:         p2p.NewPeers(srv),
+		Log:           logger,
+		MessageServer: server.NewMsgServer(srv, StateProtocol, timeout, make(chan service.DirectMessage, config.ConfigValues.BufferSize), logger),
+		db:            db,
+		concurrency:   concurrency,
+		timeout:       timeout,
+	}
+	s.RegisterMsgHandler(TRIE_NODES, newTrieNodesRequestHandler(db, logger))
+	return &s
+}
+
+func (s *StateSyncer) Close() {
+	s.MessageServer.Close()
+}
+
+// SyncState fetches the state trie with the given root from the peers. every node is checked against the hash it
+// was requested by, and is written once its whole subtrie is in the database, so a sync that was interrupted
+// continues from the nodes it already wrote when it is started again with the same root
+func (s *StateSyncer) SyncState(root common.Hash) error {
+	sched := trie.NewSync(root, s.db, nil)
+	retry := make([]common.Hash, 0)
+	stalls := 0
+	for round := 0; sched.Pending() > 0; round++ {
+		peers := s.GetPeers()
+		if len(peers) == 0 {
+			return errors.New("no peers")
+		}
+		missing := retry
+		if n := s.concurrency*maxTrieNodes - len(retry); n > 0 {
+			missing = append(missing, sched.Missing(n)...)
+		}
+		if len(missing) == 0 {
+			return fmt.Errorf("%v trie nodes are pending but none is missing", sched.Pending())
+		}
+
+		nodes := s.fetchTrieNodes(peers, missing, round)
+		results := make([]trie.SyncResult, 0, len(nodes))
+		retry = make([]common.Hash, 0)
+		for _, h := range missing {
+			if data, ok := nodes[h]; ok {
+				results = append(results, trie.SyncResult{Hash: h, Data: data})
+			} else {
+				retry = append(retry, h)
+			}
+		}
+		if _, i, err := sched.Process(results); err != nil {
+			return fmt.Errorf("could not process trie node %x %v", results[i].Hash, err)
+		}
+		batch := s.db.NewBatch()
+		if _, err := sched.Commit(batch); err != nil {
+			return err
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+
+		if len(results) == 0 {
+			if stalls++; stalls >= maxStalls {
+				return fmt.Errorf("peers did not deliver any of %v missing trie nodes", len(missing))
+			}
+		} else {
+			stalls = 0
+		}
+		s.Debug("state sync round %v received %v of %v trie nodes, %v pending", round, len(results), len(missing), sched.Pending())
+	}
+	s.Info("synced state %x", root)
+	return nil
+}
+
+// fetchTrieNodes requests the nodes from the peers in batches of maxTrieNodes, the peer of a batch is rotated every
+// round so nodes that were not delivered are asked from another peer. it returns the nodes that match their hash
+func (s *StateSyncer) fetchTrieNodes(peers []p2p.Peer, hashes []common.Hash, round int) map[common.Hash][]byte {
+	chs := make([]chan map[common.Hash][]byte, 0, len(hashes)/maxTrieNodes+1)
+	for i := 0; i*maxTrieNodes < len(hashes); i++ {
+		end := (i + 1) * maxTrieNodes
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+		peer := peers[(i+round)%len(peers)]
+		ch, err := s.sendTrieNodesRequest(peer, hashes[i*maxTrieNodes:end])
+		if err != nil {
+			s.Error("could not request trie nodes from peer %v %v", peer, err)
+			continue
+		}
+		chs = append(chs, ch)
+	}
+
+	nodes := make(map[common.Hash][]byte, len(hashes))
+	timeout := time.After(s.timeout)
+	for _, ch := range chs {
+		select {
+		case res := <-ch:
+			for h, n := range res {
+				nodes[h] = n
+			}
+		case <-timeout:
+			s.Warning("not all peers responded to trie nodes request")
+			return nodes
+		}
+	}
+	return nodes
+}
+
+func (s *StateSyncer) sendTrieNodesRequest(peer p2p.Peer, hashes []common.Hash) (chan map[common.Hash][]byte, error) {
+	requested := make(map[common.Hash]struct{}, len(hashes))
+	req := &pb.TrieNodesReq{Hashes: make([][]byte, 0, len(hashes))}
+	for _, h := range hashes {
+		requested[h] = struct{}{}
+		req.Hashes = append(req.Hashes, h.Bytes())
+	}
+	payload, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	//buffered so a response that arrives after the round timed out does not block the handler
+	ch := make(chan map[common.Hash][]byte, 1)
+	foo := func(msg []byte) {
+		res := &pb.TrieNodesResp{}
+		if err := proto.Unmarshal(msg, res); err != nil {
+			s.Error("could not unmarshal trie nodes response %v", err)
+			return
+		}
+		nodes := make(map[common.Hash][]byte, len(res.Nodes))
+		for _, n := range res.Nodes {
+			h := common.BytesToHash(crypto.Keccak256(n))
+			if _, ok := requested[h]; !ok {
+				s.Warning("peer %v sent trie node %x that was not requested", peer, h)
+				continue
+			}
+			nodes[h] = n
+		}
+		ch <- nodes
+	}
+	return ch, s.SendRequest(TRIE_NODES, payload, peer, foo)
+}
+
+func newTrieNodesRequestHandler(db database.Database, logger log.Log) func(msg []byte) []byte {
+	return func(msg []byte) []byte {
+		req := &pb.TrieNodesReq{}
+		if err := proto.Unmarshal(msg, req); err != nil {
+			return nil
+		}
+		if len(req.Hashes) > maxTrieNodes {
+			req.Hashes = req.Hashes[:maxTrieNodes]
+		}
+
+		nodes := make([][]byte, 0, len(req.Hashes))
+		for _, h := range req.Hashes {
+			if len(h) != common.HashLength {
+				continue
+			}
+			if n, err := db.Get(h); err == nil {
+				nodes = append(nodes, n)
+			}
+		}
+
+		payload, err := proto.Marshal(&pb.TrieNodesResp{Nodes: nodes})
+		if err != nil {
+			logger.Error("Error marshaling response message (TrieNodesResp) with error: %v", err)
+			return nil
+		}
+		return payload
+	}
+}
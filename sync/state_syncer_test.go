@@ -0,0 +1,138 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/gogo/protobuf/proto"
+	"github.com/spacemeshos/go-spacemesh/address"
+	"github.com/spacemeshos/go-spacemesh/common"
+	"github.com/spacemeshos/go-spacemesh/database"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/p2p"
+	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/spacemeshos/go-spacemesh/state"
+	"github.com/spacemeshos/go-spacemesh/sync/pb"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func StateSyncMockFactory(number int, name string) ([]*StateSyncer, []*service.Node, []*database.MemDatabase) {
+	syncs := make([]*StateSyncer, 0, number)
+	nodes := make([]*service.Node, 0, number)
+	dbs := make([]*database.MemDatabase, 0, number)
+	sim := service.NewSimulator()
+	for i := 0; i < number; i++ {
+		net := sim.NewNode()
+		db := database.NewMemDatabase()
+		syncs = append(syncs, NewStateSync(net, db, 1*time.Second, 2, log.New(fmt.Sprintf(name+"_%d", i), "", "")))
+		nodes = append(nodes, net)
+		dbs = append(dbs, db)
+	}
+	return syncs, nodes, dbs
+}
+
+// createState writes a state with the given number of accounts to db and returns its root
+func createState(t *testing.T, db database.Database, accounts int) common.Hash {
+	st, err := state.New(common.Hash{}, state.NewDatabase(db))
+	assert.NoError(t, err)
+	for i := 0; i < accounts; i++ {
+		addr := address.BytesToAddress(big.NewInt(int64(i + 1)).Bytes())
+		st.SetBalance(addr, big.NewInt(int64(i*10+1)))
+		st.SetNonce(addr, uint64(i))
+	}
+	root, err := st.Commit(false)
+	assert.NoError(t, err)
+	assert.NoError(t, st.TrieDB().Commit(root, false))
+	return root
+}
+
+func assertSameState(t *testing.T, root common.Hash, expected, actual database.Database) {
+	st1, err := state.New(root, state.NewDatabase(expected))
+	assert.NoError(t, err)
+	st2, err := state.New(root, state.NewDatabase(actual))
+	assert.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		addr := address.BytesToAddress(big.NewInt(int64(i + 1)).Bytes())
+		assert.Equal(t, st1.GetBalance(addr), st2.GetBalance(addr))
+		assert.Equal(t, st1.GetNonce(addr), st2.GetNonce(addr))
+	}
+}
+
+// countServedTrieNodes wraps the trie nodes handler of s and counts the nodes it serves
+func countServedTrieNodes(s *StateSyncer, db database.Database) *int32 {
+	served := int32(0)
+	handler := newTrieNodesRequestHandler(db, s.Log)
+	s.RegisterMsgHandler(TRIE_NODES, func(msg []byte) []byte {
+		payload := handler(msg)
+		res := &pb.TrieNodesResp{}
+		if err := proto.Unmarshal(payload, res); err == nil {
+			atomic.AddInt32(&served, int32(len(res.Nodes)))
+		}
+		return payload
+	})
+	return &served
+}
+
+func TestStateSyncer_SyncState(t *testing.T) {
+	syncs, nodes, dbs := StateSyncMockFactory(3, "TestStateSyncer_SyncState")
+	for _, s := range syncs {
+		defer s.Close()
+	}
+	root := createState(t, dbs[0], 1000)
+	createState(t, dbs[1], 1000)
+	syncs[2].Peers = getPeersMock([]p2p.Peer{nodes[0].PublicKey(), nodes[1].PublicKey()})
+
+	assert.NoError(t, syncs[2].SyncState(root))
+	assertSameState(t, root, dbs[0], dbs[2])
+
+	//nothing is requested for a state that is already in the database
+	served := countServedTrieNodes(syncs[0], dbs[0])
+	assert.NoError(t, syncs[2].SyncState(root))
+	assert.Equal(t, int32(0), atomic.LoadInt32(served))
+}
+
+func TestStateSyncer_Resume(t *testing.T) {
+	syncs, nodes, dbs := StateSyncMockFactory(3, "TestStateSyncer_Resume")
+	for _, s := range syncs {
+		defer s.Close()
+	}
+	root := createState(t, dbs[0], 1000)
+
+	//the first peer lost part of the state, the sync stops once no peer can deliver the missing nodes
+	for i, k := range dbs[0].Keys() {
+		if i%20 != 0 {
+			v, err := dbs[0].Get(k)
+			assert.NoError(t, err)
+			assert.NoError(t, dbs[1].Put(k, v))
+		}
+	}
+	syncs[2].Peers = getPeersMock([]p2p.Peer{nodes[1].PublicKey()})
+	assert.Error(t, syncs[2].SyncState(root))
+	partial := dbs[2].Len()
+	assert.True(t, partial > 0, "complete subtries should be written before the sync stops")
+
+	served := countServedTrieNodes(syncs[0], dbs[0])
+	syncs[2].Peers = getPeersMock([]p2p.Peer{nodes[0].PublicKey()})
+	assert.NoError(t, syncs[2].SyncState(root))
+	assertSameState(t, root, dbs[0], dbs[2])
+	assert.Equal(t, int32(dbs[2].Len()-partial), atomic.LoadInt32(served), "only the nodes that were not written before should be fetched")
+}
+
+func TestStateSyncer_InvalidNodes(t *testing.T) {
+	syncs, nodes, dbs := StateSyncMockFactory(2, "TestStateSyncer_InvalidNodes")
+	for _, s := range syncs {
+		defer s.Close()
+	}
+	root := createState(t, dbs[0], 10)
+
+	//a peer that answers with nodes that do not match the requested hashes
+	for _, k := range dbs[0].Keys() {
+		v, _ := dbs[0].Get(k)
+		assert.NoError(t, dbs[0].Put(k, append(v, 0x00)))
+	}
+	syncs[1].Peers = getPeersMock([]p2p.Peer{nodes[0].PublicKey()})
+	assert.Error(t, syncs[1].SyncState(root))
+	assert.Equal(t, 0, dbs[1].Len(), "nodes that do not match their hash should not be written")
+}
@@ -1,6 +1,7 @@
 package sync
 
 import (
+	"bytes"
 	"errors"
 	"github.com/gogo/protobuf/proto"
 	"github.com/spacemeshos/go-spacemesh/log"
@@ -190,7 +191,7 @@ type peerHashPair struct {
 
 func sendBlockRequest(msgServ *server.MessageServer, peer p2p.Peer, id mesh.BlockID, logger log.Log) (chan *mesh.Block, error) {
 	logger.Info("send block request Peer: %v id: %v", peer, id)
-	data := &pb.FetchBlockReq{Id: uint32(id)}
+	data := &pb.FetchBlockReq{Id: uint64(id)}
 	payload, err := proto.Marshal(data)
 	if err != nil {
 		return nil, err
@@ -204,14 +205,23 @@ func sendBlockRequest(msgServ *server.MessageServer, peer p2p.Peer, id mesh.Bloc
 			logger.Error("could not unmarshal block data")
 			return
 		}
-		mp := make([]mesh.BlockID, 0, len(data.Block.VisibleMesh))
-		for _, b := range data.Block.VisibleMesh {
-			mp = append(mp, mesh.BlockID(b))
+		block, err := mesh.BytesAsBlock(bytes.NewReader(data.Block))
+		if err != nil {
+			logger.Error("could not unmarshal block %v", err)
+			return
+		}
+
+		if block.ID() != id || !block.ValidId() {
+			logger.Error("block content does not match requested id %v", id)
+			return
 		}
 
-		block := mesh.NewExistingBlock(mesh.BlockID(data.Block.GetId()), mesh.LayerID(data.Block.GetLayer()), nil)
-		block.ViewEdges = mp
-		ch <- block
+		if !block.ValidSignature() {
+			logger.Error("block %v is not signed by its miner %v", id, block.MinerID)
+			return
+		}
+
+		ch <- &block
 	}
 
 	return ch, msgServ.SendRequest(BLOCK, payload, peer, foo)
@@ -231,7 +241,7 @@ func (s *Syncer) getLayerBlockIDs(index mesh.LayerID) (chan mesh.BlockID, error)
 func (s *Syncer) getIdsForHash(m map[string]p2p.Peer, index mesh.LayerID) (chan mesh.BlockID, error) {
 	reqCounter := 0
 
-	ch := make(chan []uint32, len(m))
+	ch := make(chan []uint64, len(m))
 	wg := sync.WaitGroup{}
 	for _, v := range m {
 		c, err := s.sendLayerIDsRequest(v, index)
@@ -353,7 +363,7 @@ func (s *Syncer) sendLayerHashRequest(peer p2p.Peer, layer mesh.LayerID) (chan p
 	return ch, s.SendRequest(LAYER_HASH, payload, peer, foo)
 }
 
-func (s *Syncer) sendLayerIDsRequest(peer p2p.Peer, idx mesh.LayerID) (chan []uint32, error) {
+func (s *Syncer) sendLayerIDsRequest(peer p2p.Peer, idx mesh.LayerID) (chan []uint64, error) {
 	s.Debug("send Layer ids request Peer: ", peer, " layer: ", idx)
 
 	data := &pb.LayerIdsReq{Layer: uint32(idx)}
@@ -361,7 +371,7 @@ func (s *Syncer) sendLayerIDsRequest(peer p2p.Peer, idx mesh.LayerID) (chan []ui
 	if err != nil {
 		return nil, err
 	}
-	ch := make(chan []uint32)
+	ch := make(chan []uint64)
 	foo := func(msg []byte) {
 		defer close(ch)
 		data := &pb.LayerIdsResp{}
@@ -389,12 +399,13 @@ func newBlockRequestHandler(layers *mesh.Mesh, logger log.Log) func(msg []byte)
 			return nil
 		}
 
-		vm := make([]uint32, 0, len(block.ViewEdges))
-		for _, b := range block.ViewEdges {
-			vm = append(vm, uint32(b))
+		bbytes, err := mesh.BlockAsBytes(*block)
+		if err != nil {
+			logger.Error("Error marshaling block, with BlockID: %d and err: %v", block.ID(), err)
+			return nil
 		}
 
-		payload, err := proto.Marshal(&pb.FetchBlockResp{Block: &pb.Block{Id: uint32(block.ID()), Layer: uint32(block.Layer()), VisibleMesh: vm}})
+		payload, err := proto.Marshal(&pb.FetchBlockResp{Block: bbytes})
 		if err != nil {
 			logger.Error("Error marshaling response message (FetchBlockResp), with BlockID: %d, LayerID: %d and err:", block.ID(), block.Layer(), err)
 			return nil
@@ -414,13 +425,13 @@ func newLayerHashRequestHandler(layers *mesh.Mesh, logger log.Log) func(msg []by
 			return nil
 		}
 
-		layer, err := layers.GetLayer(mesh.LayerID(req.Layer))
+		hash, err := layers.GetLayerHash(mesh.LayerID(req.Layer))
 		if err != nil {
 			logger.Error("Error handling layer ", req.Layer, " request message with error:", err)
 			return nil
 		}
 
-		payload, err := proto.Marshal(&pb.LayerHashResp{Hash: layer.Hash()})
+		payload, err := proto.Marshal(&pb.LayerHashResp{Hash: hash})
 		if err != nil {
 			logger.Error("Error marshaling response message (LayerHashResp) with error:", err)
 			return nil
@@ -437,18 +448,16 @@ func newLayerIdsRequestHandler(layers *mesh.Mesh, logger log.Log) func(msg []byt
 			return nil
 		}
 
-		layer, err := layers.GetLayer(mesh.LayerID(req.Layer))
+		blockIds, err := layers.GetLayerBlockIDs(mesh.LayerID(req.Layer))
 		if err != nil {
 			logger.Error("Error handling layer ids request message with LayerID: %d and error: %s", req.Layer, err.Error())
 			return nil
 		}
 
-		blocks := layer.Blocks()
-
-		ids := make([]uint32, 0, len(blocks))
+		ids := make([]uint64, 0, len(blockIds))
 
-		for _, b := range blocks {
-			ids = append(ids, uint32(b.ID()))
+		for _, id := range blockIds {
+			ids = append(ids, uint64(id))
 		}
 
 		payload, err := proto.Marshal(&pb.LayerIdsResp{Ids: ids})
@@ -3,10 +3,12 @@ package sync
 import (
 	"errors"
 	"fmt"
-	"github.com/google/uuid"
+	"github.com/spacemeshos/go-spacemesh/address"
+	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/database"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/mesh"
+	"github.com/spacemeshos/go-spacemesh/mesh/config"
 	"github.com/spacemeshos/go-spacemesh/p2p"
 	"github.com/spacemeshos/go-spacemesh/p2p/service"
 	"github.com/spacemeshos/go-spacemesh/state"
@@ -43,6 +45,34 @@ func SyncMockFactory(number int, conf Configuration, name string, dbType string)
 	return nodes, p2ps
 }
 
+var minerPriv, minerPub, _ = crypto.GenerateKeyPair()
+
+// minerKeys holds the keys of the test miners by their id, every block is built by a miner of its own
+// since the blocks of a miner that builds more than one block in a layer are excluded
+var minerKeys = map[string]crypto.PrivateKey{minerPub.String(): minerPriv}
+
+// newSignedBlock creates a block mined by a new test miner, blocks that are changed afterwards need to be signed again
+func newSignedBlock(coin bool, data []byte, ts time.Time, layerId mesh.LayerID) *mesh.Block {
+	priv, pub, err := crypto.GenerateKeyPair()
+	if err != nil {
+		panic(err)
+	}
+	minerKeys[pub.String()] = priv
+	b := mesh.NewBlock(coin, data, ts, layerId)
+	b.MinerID = pub.String()
+	signBlock(b)
+	return b
+}
+
+func signBlock(b *mesh.Block) {
+	b.Id = b.CalcId()
+	sig, err := minerKeys[b.MinerID].Sign(b.ContentHash())
+	if err != nil {
+		panic(err)
+	}
+	b.Sig = sig
+}
+
 type BlockValidatorMock struct {
 }
 
@@ -52,16 +82,25 @@ func (BlockValidatorMock) BlockEligible(id mesh.LayerID, key string) bool {
 
 type MeshValidatorMock struct{}
 
-func (m *MeshValidatorMock) HandleIncomingLayer(layer *mesh.Layer)            {}
-func (m *MeshValidatorMock) HandleLateBlock(bl *mesh.Block)                   {}
-func (m *MeshValidatorMock) RegisterLayerCallback(func(layerId mesh.LayerID)) {}
+func (m *MeshValidatorMock) HandleIncomingLayer(layer *mesh.Layer)                      {}
+func (m *MeshValidatorMock) HandleLateBlock(bl *mesh.Block)                             {}
+func (m *MeshValidatorMock) RegisterLayerCallback(func(layerId mesh.LayerID))           {}
+func (m *MeshValidatorMock) RegisterValidityCallback(func(id mesh.BlockID, valid bool)) {}
 
 type stateMock struct{}
 
-func (s *stateMock) ApplyTransactions(id state.LayerID, tx state.Transactions) (uint32, error) {
+func (s *stateMock) ApplyTransactions(id state.LayerID, seed []byte, miners []address.Address, tx state.Transactions) (uint32, error) {
 	return 0, nil
 }
 
+func (s *stateMock) Reset(layer state.LayerID) error {
+	return nil
+}
+
+func (s *stateMock) LatestLayer() (state.LayerID, error) {
+	return 0, errors.New("no layer was applied")
+}
+
 func getMeshWithLevelDB(id string) *mesh.Mesh {
 	//time := time.Now()
 	bdb := database.NewLevelDbStore("blocks_test_"+id, nil, nil)
@@ -69,22 +108,30 @@ func getMeshWithLevelDB(id string) *mesh.Mesh {
 	cv := database.NewLevelDbStore("contextually_valid_test_"+id, nil, nil)
 	//odb := database.NewLevelDbStore("orphans_test_"+id+"_"+time.String(), nil, nil)
 
-	layers := mesh.NewMesh(ldb, bdb, cv, &MeshValidatorMock{}, &stateMock{}, log.New(id, "", ""))
+	layers := mesh.NewMesh(config.DefaultConfig(), ldb, bdb, cv, &MeshValidatorMock{}, &stateMock{}, log.New(id, "", ""))
 	return layers
 }
 
 type MockState struct{}
 
-func (MockState) ApplyTransactions(layer state.LayerID, txs state.Transactions) (uint32, error) {
+func (MockState) ApplyTransactions(layer state.LayerID, seed []byte, miners []address.Address, txs state.Transactions) (uint32, error) {
 	return 0, nil
 }
 
+func (MockState) Reset(layer state.LayerID) error {
+	return nil
+}
+
+func (MockState) LatestLayer() (state.LayerID, error) {
+	return 0, errors.New("no layer was applied")
+}
+
 func getMeshWithMemoryDB(id string) *mesh.Mesh {
 	bdb := database.NewMemDatabase()
 	ldb := database.NewMemDatabase()
 	cv := database.NewMemDatabase()
 	//odb := database.NewMemDatabase()
-	layers := mesh.NewMesh(ldb, bdb, cv, &MeshValidatorMock{}, &stateMock{}, log.New(id, "", ""))
+	layers := mesh.NewMesh(config.DefaultConfig(), ldb, bdb, cv, &MeshValidatorMock{}, &stateMock{}, log.New(id, "", ""))
 	return layers
 }
 
@@ -136,7 +183,7 @@ func TestSyncProtocol_BlockRequest(t *testing.T) {
 	syncObj2 := syncs[1]
 	defer syncObj.Close()
 	lid := mesh.LayerID(1)
-	block := mesh.NewExistingBlock(mesh.BlockID(uuid.New().ID()), lid, []byte("data data data"))
+	block := newSignedBlock(true, []byte("data data data"), time.Now(), lid)
 	syncObj.AddLayer(mesh.NewExistingLayer(lid, []*mesh.Block{block}))
 	ch, err := sendBlockRequest(syncObj2.MessageServer, nodes[0].Node.PublicKey(), block.ID(), syncObj.Log)
 	timeout := time.NewTimer(2 * time.Second)
@@ -151,6 +198,50 @@ func TestSyncProtocol_BlockRequest(t *testing.T) {
 
 }
 
+func TestSyncProtocol_BlockRequestContentMismatch(t *testing.T) {
+	syncs, nodes := SyncMockFactory(2, conf, "TestSyncProtocol_BlockRequestContentMismatch_", memoryDB)
+	syncObj := syncs[0]
+	syncObj2 := syncs[1]
+	defer syncObj.Close()
+	lid := mesh.LayerID(1)
+	block := mesh.NewExistingBlock(mesh.BlockID(123), lid, []byte("data data data"))
+	syncObj.AddLayer(mesh.NewExistingLayer(lid, []*mesh.Block{block}))
+	ch, err := sendBlockRequest(syncObj2.MessageServer, nodes[0].Node.PublicKey(), block.ID(), syncObj.Log)
+	assert.NoError(t, err, "Should not return error")
+	timeout := time.NewTimer(2 * time.Second)
+
+	select {
+	case a := <-ch:
+		assert.Nil(t, a, "block with content that does not match its id should be rejected")
+	case <-timeout.C:
+		assert.Fail(t, "no message received on channel")
+	}
+}
+
+func TestSyncProtocol_BlockRequestBadSignature(t *testing.T) {
+	syncs, nodes := SyncMockFactory(2, conf, "TestSyncProtocol_BlockRequestBadSignature_", memoryDB)
+	syncObj := syncs[0]
+	syncObj2 := syncs[1]
+	defer syncObj.Close()
+	lid := mesh.LayerID(1)
+	block := mesh.NewBlock(true, []byte("data data data"), time.Now(), lid)
+	block.MinerID = minerPub.String()
+	block.Id = block.CalcId()
+	otherPriv, _, _ := crypto.GenerateKeyPair()
+	block.Sig, _ = otherPriv.Sign(block.ContentHash())
+	syncObj.AddLayer(mesh.NewExistingLayer(lid, []*mesh.Block{block}))
+	ch, err := sendBlockRequest(syncObj2.MessageServer, nodes[0].Node.PublicKey(), block.ID(), syncObj.Log)
+	assert.NoError(t, err, "Should not return error")
+	timeout := time.NewTimer(2 * time.Second)
+
+	select {
+	case a := <-ch:
+		assert.Nil(t, a, "block not signed by its miner should be rejected")
+	case <-timeout.C:
+		assert.Fail(t, "no message received on channel")
+	}
+}
+
 func TestSyncProtocol_LayerHashRequest(t *testing.T) {
 	syncs, nodes := SyncMockFactory(2, conf, "TestSyncProtocol_LayerHashRequest_", memoryDB)
 	syncObj1 := syncs[0]
@@ -159,14 +250,15 @@ func TestSyncProtocol_LayerHashRequest(t *testing.T) {
 	defer syncObj2.Close()
 	lid := mesh.LayerID(1)
 
-	syncObj1.AddLayer(mesh.NewExistingLayer(lid, make([]*mesh.Block, 0, 10)))
+	layer := mesh.NewExistingLayer(lid, make([]*mesh.Block, 0, 10))
+	syncObj1.AddLayer(layer)
 	syncObj1.LayerCompleteCallback(lid) //this is to simulate the approval of the tortoise...
 	timeout := time.NewTimer(2 * time.Second)
 	ch, err := syncObj2.sendLayerHashRequest(nodes[0].Node.PublicKey(), lid)
 	select {
 	case hash := <-ch:
 		assert.NoError(t, err, "Should not return error")
-		assert.Equal(t, "some hash representing the layer", string(hash.hash), "wrong block")
+		assert.Equal(t, layer.Hash(), hash.hash, "wrong hash")
 	case <-timeout.C:
 		assert.Fail(t, "no message received on channel")
 	}
@@ -181,10 +273,10 @@ func TestSyncProtocol_LayerIdsRequest(t *testing.T) {
 	defer syncObj1.Close()
 	lid := mesh.LayerID(1)
 	layer := mesh.NewExistingLayer(lid, make([]*mesh.Block, 0, 10))
-	layer.AddBlock(mesh.NewExistingBlock(mesh.BlockID(123), lid, nil))
-	layer.AddBlock(mesh.NewExistingBlock(mesh.BlockID(132), lid, nil))
-	layer.AddBlock(mesh.NewExistingBlock(mesh.BlockID(111), lid, nil))
-	layer.AddBlock(mesh.NewExistingBlock(mesh.BlockID(222), lid, nil))
+	layer.AddBlock(newSignedBlock(true, []byte("data123"), time.Now(), lid))
+	layer.AddBlock(newSignedBlock(true, []byte("data132"), time.Now(), lid))
+	layer.AddBlock(newSignedBlock(true, []byte("data111"), time.Now(), lid))
+	layer.AddBlock(newSignedBlock(true, []byte("data222"), time.Now(), lid))
 	syncObj1.AddLayer(layer)
 	ch, err := syncObj.sendLayerIDsRequest(nodes[1].Node.PublicKey(), lid)
 	timeout := time.NewTimer(2 * time.Second)
@@ -234,9 +326,9 @@ func TestSyncProtocol_FetchBlocks(t *testing.T) {
 	n1 := nodes[0]
 	syncObj1.Log.Info("started fetch_blocks")
 
-	block1 := mesh.NewExistingBlock(mesh.BlockID(123), 0, nil)
-	block2 := mesh.NewExistingBlock(mesh.BlockID(321), 1, nil)
-	block3 := mesh.NewExistingBlock(mesh.BlockID(222), 2, nil)
+	block1 := newSignedBlock(true, []byte("data123"), time.Now(), 0)
+	block2 := newSignedBlock(true, []byte("data321"), time.Now(), 1)
+	block3 := newSignedBlock(true, []byte("data222"), time.Now(), 2)
 
 	syncObj1.AddLayer(mesh.NewExistingLayer(0, []*mesh.Block{block1}))
 	syncObj1.AddLayer(mesh.NewExistingLayer(1, []*mesh.Block{block2}))
@@ -251,7 +343,7 @@ func TestSyncProtocol_FetchBlocks(t *testing.T) {
 		t.Error("timed out ")
 	case hash = <-ch:
 		assert.NoError(t, err, "Should not return error")
-		assert.Equal(t, "some hash representing the layer", string(hash.hash), "wrong block")
+		assert.Equal(t, mesh.NewExistingLayer(0, []*mesh.Block{block1}).Hash(), hash.hash, "wrong hash")
 	}
 	ch2, err2 := sendBlockRequest(syncObj2.MessageServer, n1.PublicKey(), block1.ID(), syncObj2.Log)
 	assert.NoError(t, err2, "Should not return error")
@@ -265,7 +357,8 @@ func TestSyncProtocol_FetchBlocks(t *testing.T) {
 	}
 	ch, err = syncObj2.sendLayerHashRequest(n1.PublicKey(), 1)
 	assert.NoError(t, err, "Should not return error")
-	assert.Equal(t, "some hash representing the layer", string(hash.hash), "wrong block")
+	hash = <-ch
+	assert.Equal(t, mesh.NewExistingLayer(1, []*mesh.Block{block2}).Hash(), hash.hash, "wrong hash")
 
 	ch2, err2 = sendBlockRequest(syncObj2.MessageServer, n1.PublicKey(), block2.ID(), syncObj2.Log)
 	assert.NoError(t, err2, "Should not return error")
@@ -279,7 +372,8 @@ func TestSyncProtocol_FetchBlocks(t *testing.T) {
 	}
 	ch, err = syncObj2.sendLayerHashRequest(n1.PublicKey(), 2)
 	assert.NoError(t, err, "Should not return error")
-	assert.Equal(t, "some hash representing the layer", string(hash.hash), "wrong block")
+	hash = <-ch
+	assert.Equal(t, mesh.NewExistingLayer(2, []*mesh.Block{block3}).Hash(), hash.hash, "wrong hash")
 
 	ch2, err2 = sendBlockRequest(syncObj2.MessageServer, n1.PublicKey(), block3.ID(), syncObj2.Log)
 	assert.NoError(t, err2, "Should not return error")
@@ -304,16 +398,16 @@ func TestSyncProtocol_SyncTwoNodes(t *testing.T) {
 	syncObj2.Peers = pm2 //override peers with mock
 	defer syncObj2.Close()
 
-	block1 := mesh.NewExistingBlock(mesh.BlockID(111), 0, nil)
-	block2 := mesh.NewExistingBlock(mesh.BlockID(222), 0, nil)
-	block3 := mesh.NewExistingBlock(mesh.BlockID(333), 1, nil)
-	block4 := mesh.NewExistingBlock(mesh.BlockID(444), 1, nil)
-	block5 := mesh.NewExistingBlock(mesh.BlockID(555), 2, nil)
-	block6 := mesh.NewExistingBlock(mesh.BlockID(666), 2, nil)
-	block7 := mesh.NewExistingBlock(mesh.BlockID(777), 3, nil)
-	block8 := mesh.NewExistingBlock(mesh.BlockID(888), 3, nil)
-	block9 := mesh.NewExistingBlock(mesh.BlockID(999), 4, nil)
-	block10 := mesh.NewExistingBlock(mesh.BlockID(101), 5, nil)
+	block1 := newSignedBlock(true, []byte("data111"), time.Now(), 0)
+	block2 := newSignedBlock(true, []byte("data222"), time.Now(), 0)
+	block3 := newSignedBlock(true, []byte("data333"), time.Now(), 1)
+	block4 := newSignedBlock(true, []byte("data444"), time.Now(), 1)
+	block5 := newSignedBlock(true, []byte("data555"), time.Now(), 2)
+	block6 := newSignedBlock(true, []byte("data666"), time.Now(), 2)
+	block7 := newSignedBlock(true, []byte("data777"), time.Now(), 3)
+	block8 := newSignedBlock(true, []byte("data888"), time.Now(), 3)
+	block9 := newSignedBlock(true, []byte("data999"), time.Now(), 4)
+	block10 := newSignedBlock(true, []byte("data101"), time.Now(), 5)
 	syncObj1.AddLayer(mesh.NewExistingLayer(0, []*mesh.Block{block1, block2}))
 	syncObj1.AddLayer(mesh.NewExistingLayer(1, []*mesh.Block{block3, block4}))
 	syncObj1.AddLayer(mesh.NewExistingLayer(2, []*mesh.Block{block5, block6}))
@@ -373,16 +467,16 @@ func syncTest(dpType string, t *testing.T) {
 	syncObj3.Peers = getPeersMock([]p2p.Peer{n1.PublicKey(), n2.PublicKey(), n4.PublicKey()})
 	syncObj4.Peers = getPeersMock([]p2p.Peer{n1.PublicKey(), n2.PublicKey()})
 
-	block1 := mesh.NewExistingBlock(mesh.BlockID(111), 0, nil)
-	block2 := mesh.NewExistingBlock(mesh.BlockID(222), 0, nil)
-	block3 := mesh.NewExistingBlock(mesh.BlockID(333), 1, nil)
-	block4 := mesh.NewExistingBlock(mesh.BlockID(444), 1, nil)
-	block5 := mesh.NewExistingBlock(mesh.BlockID(555), 2, nil)
-	block6 := mesh.NewExistingBlock(mesh.BlockID(666), 2, nil)
-	block7 := mesh.NewExistingBlock(mesh.BlockID(777), 3, nil)
-	block8 := mesh.NewExistingBlock(mesh.BlockID(888), 3, nil)
-	block9 := mesh.NewExistingBlock(mesh.BlockID(999), 4, nil)
-	block10 := mesh.NewExistingBlock(mesh.BlockID(101), 4, nil)
+	block1 := newSignedBlock(true, []byte("data111"), time.Now(), 0)
+	block2 := newSignedBlock(true, []byte("data222"), time.Now(), 0)
+	block3 := newSignedBlock(true, []byte("data333"), time.Now(), 1)
+	block4 := newSignedBlock(true, []byte("data444"), time.Now(), 1)
+	block5 := newSignedBlock(true, []byte("data555"), time.Now(), 2)
+	block6 := newSignedBlock(true, []byte("data666"), time.Now(), 2)
+	block7 := newSignedBlock(true, []byte("data777"), time.Now(), 3)
+	block8 := newSignedBlock(true, []byte("data888"), time.Now(), 3)
+	block9 := newSignedBlock(true, []byte("data999"), time.Now(), 4)
+	block10 := newSignedBlock(true, []byte("data101"), time.Now(), 4)
 
 	syncObj1.AddLayer(mesh.NewExistingLayer(0, []*mesh.Block{block1, block2}))
 	syncObj1.AddLayer(mesh.NewExistingLayer(1, []*mesh.Block{block3, block4}))
@@ -461,16 +555,16 @@ func Test_TwoNodes_SyncIntegrationSuite(t *testing.T) {
 
 func (sis *syncIntegrationTwoNodes) TestSyncProtocol_TwoNodes() {
 	t := sis.T()
-	block1 := mesh.NewExistingBlock(mesh.BlockID(111), 1, nil)
-	block2 := mesh.NewExistingBlock(mesh.BlockID(222), 1, nil)
-	block3 := mesh.NewExistingBlock(mesh.BlockID(333), 2, nil)
-	block4 := mesh.NewExistingBlock(mesh.BlockID(444), 2, nil)
-	block5 := mesh.NewExistingBlock(mesh.BlockID(555), 3, nil)
-	block6 := mesh.NewExistingBlock(mesh.BlockID(666), 3, nil)
-	block7 := mesh.NewExistingBlock(mesh.BlockID(777), 4, nil)
-	block8 := mesh.NewExistingBlock(mesh.BlockID(888), 4, nil)
-	block9 := mesh.NewExistingBlock(mesh.BlockID(999), 5, nil)
-	block10 := mesh.NewExistingBlock(mesh.BlockID(101), 5, nil)
+	block1 := newSignedBlock(true, []byte("data111"), time.Now(), 1)
+	block2 := newSignedBlock(true, []byte("data222"), time.Now(), 1)
+	block3 := newSignedBlock(true, []byte("data333"), time.Now(), 2)
+	block4 := newSignedBlock(true, []byte("data444"), time.Now(), 2)
+	block5 := newSignedBlock(true, []byte("data555"), time.Now(), 3)
+	block6 := newSignedBlock(true, []byte("data666"), time.Now(), 3)
+	block7 := newSignedBlock(true, []byte("data777"), time.Now(), 4)
+	block8 := newSignedBlock(true, []byte("data888"), time.Now(), 4)
+	block9 := newSignedBlock(true, []byte("data999"), time.Now(), 5)
+	block10 := newSignedBlock(true, []byte("data101"), time.Now(), 5)
 
 	syncObj0 := sis.syncers[0]
 	defer syncObj0.Close()
@@ -530,16 +624,16 @@ func Test_Multiple_SyncIntegrationSuite(t *testing.T) {
 func (sis *syncIntegrationMultipleNodes) TestSyncProtocol_MultipleNodes() {
 	t := sis.T()
 
-	block1 := mesh.NewExistingBlock(mesh.BlockID(111), 0, nil)
-	block2 := mesh.NewExistingBlock(mesh.BlockID(222), 1, nil)
-	block3 := mesh.NewExistingBlock(mesh.BlockID(333), 2, nil)
-	block4 := mesh.NewExistingBlock(mesh.BlockID(444), 2, nil)
-	block5 := mesh.NewExistingBlock(mesh.BlockID(555), 3, nil)
-	block6 := mesh.NewExistingBlock(mesh.BlockID(666), 3, nil)
-	//block7 := mesh.NewExistingBlock(mesh.BlockID(777), 4, nil)
-	//block8 := mesh.NewExistingBlock(mesh.BlockID(888), 4, nil)
-	//block9 := mesh.NewExistingBlock(mesh.BlockID(999), 5, nil)
-	//block10 := mesh.NewExistingBlock(mesh.BlockID(101), 5, nil)
+	block1 := newSignedBlock(true, []byte("data111"), time.Now(), 0)
+	block2 := newSignedBlock(true, []byte("data222"), time.Now(), 1)
+	block3 := newSignedBlock(true, []byte("data333"), time.Now(), 2)
+	block4 := newSignedBlock(true, []byte("data444"), time.Now(), 2)
+	block5 := newSignedBlock(true, []byte("data555"), time.Now(), 3)
+	block6 := newSignedBlock(true, []byte("data666"), time.Now(), 3)
+	//block7 := newSignedBlock(true, []byte("data777"), time.Now(), 4)
+	//block8 := newSignedBlock(true, []byte("data888"), time.Now(), 4)
+	//block9 := newSignedBlock(true, []byte("data999"), time.Now(), 5)
+	//block10 := newSignedBlock(true, []byte("data101"), time.Now(), 5)
 
 	syncObj1 := sis.syncers[0]
 	defer syncObj1.Close()